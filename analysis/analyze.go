@@ -0,0 +1,175 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Count is one row of a breakdown: the records and bits flipped for a key.
+type Count struct {
+	Key     string `json:"key"`
+	Records int    `json:"records"`
+	Bits    int    `json:"bits"`
+}
+
+// Summary breaks the records of a campaign down several ways.
+type Summary struct {
+	Records int `json:"records"`
+	Bits    int `json:"bits"`
+
+	ByRate     []Count `json:"byRate"`
+	BySite     []Count `json:"bySite"`
+	ByTestType []Count `json:"byTestType"`
+
+	// BitPositions counts flips per bit position, 0 being the least
+	// significant bit of the value.
+	BitPositions []Count `json:"bitPositions"`
+
+	// DeltaMagnitudes counts records by the bit length of |DeltaValue|, so
+	// key "n" holds deltas in [2^(n-1), 2^n).
+	DeltaMagnitudes []Count `json:"deltaMagnitudes"`
+	PositiveDeltas  int     `json:"positiveDeltas"`
+	NegativeDeltas  int     `json:"negativeDeltas"`
+}
+
+// counter accumulates one breakdown.
+type counter map[string]*Count
+
+func (c counter) add(key string, records, bits int) {
+	count, ok := c[key]
+	if !ok {
+		count = &Count{Key: key}
+		c[key] = count
+	}
+	count.Records += records
+	count.Bits += bits
+}
+
+// sorted returns the counts ordered by key, numerically if numeric is set.
+func (c counter) sorted(numeric bool) []Count {
+	counts := make([]Count, 0, len(c))
+	for _, count := range c {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if numeric {
+			a, _ := strconv.ParseFloat(counts[i].Key, 64)
+			b, _ := strconv.ParseFloat(counts[j].Key, 64)
+			return a < b
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	return counts
+}
+
+// Summarize breaks iters down by rate, site, test type, bit position and
+// delta magnitude.
+func Summarize(iters []injection.Iteration) Summary {
+	var (
+		summary = Summary{Records: len(iters)}
+		rates   = counter{}
+		sites   = counter{}
+		types   = counter{}
+		bits    = counter{}
+		deltas  = counter{}
+	)
+	for _, iter := range iters {
+		flipped := len(iter.ErrorData.IntBits)
+		summary.Bits += flipped
+
+		rates.add(formatRate(iter.Rate), 1, flipped)
+		sites.add(iter.ErrorData.Msg, 1, flipped)
+		types.add(iter.TestType, 1, flipped)
+		for _, bit := range iter.ErrorData.IntBits {
+			bits.add(strconv.Itoa(bit), 1, 1)
+		}
+		if delta := Delta(iter); delta != nil {
+			deltas.add(strconv.Itoa(delta.BitLen()), 1, flipped)
+			switch delta.Sign() {
+			case 1:
+				summary.PositiveDeltas++
+			case -1:
+				summary.NegativeDeltas++
+			}
+		}
+	}
+	summary.ByRate = rates.sorted(true)
+	summary.BySite = sites.sorted(false)
+	summary.ByTestType = types.sorted(false)
+	summary.BitPositions = bits.sorted(true)
+	summary.DeltaMagnitudes = deltas.sorted(true)
+	return summary
+}
+
+// WriteTable prints the summary as plain text tables.
+func (s Summary) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Records\t%d\n", s.Records)
+	fmt.Fprintf(tw, "Bits flipped\t%d\n", s.Bits)
+	fmt.Fprintf(tw, "Positive deltas\t%d\n", s.PositiveDeltas)
+	fmt.Fprintf(tw, "Negative deltas\t%d\n", s.NegativeDeltas)
+
+	writeCounts(tw, "Rate", s.ByRate, s.Records)
+	writeCounts(tw, "Site", s.BySite, s.Records)
+	writeCounts(tw, "Test type", s.ByTestType, s.Records)
+	writeHistogram(tw, "Bit position", s.BitPositions)
+	writeHistogram(tw, "|Delta| bit length", s.DeltaMagnitudes)
+	return tw.Flush()
+}
+
+func writeCounts(w io.Writer, title string, counts []Count, total int) {
+	fmt.Fprintf(w, "\n%s\tRecords\tShare\tBits\n", title)
+	for _, count := range counts {
+		key := count.Key
+		if key == "" {
+			key = "(none)"
+		}
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(count.Records) / float64(total)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f%%\t%d\n", key, count.Records, share, count.Bits)
+	}
+}
+
+// histogramWidth is the length of the longest bar in a histogram.
+const histogramWidth = 40
+
+func writeHistogram(w io.Writer, title string, counts []Count) {
+	fmt.Fprintf(w, "\n%s\tCount\t\n", title)
+	most := 0
+	for _, count := range counts {
+		if count.Records > most {
+			most = count.Records
+		}
+	}
+	for _, count := range counts {
+		bar := 0
+		if most > 0 {
+			bar = (count.Records*histogramWidth + most - 1) / most
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", count.Key, count.Records, strings.Repeat("#", bar))
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// record returns a record of the flip of bits at site, with delta as its
+// DeltaValue.
+func record(site string, rate float64, testType string, delta interface{}, bits ...int) injection.Iteration {
+	var iter injection.Iteration
+	iter.Rate = rate
+	iter.TestType = testType
+	iter.ErrorData.Msg = site
+	iter.ErrorData.IntBits = bits
+	iter.ErrorData.DeltaValue = delta
+	return iter
+}
+
+// records is a campaign of two rates over three sites, with deltas of every
+// type a record may carry once read back.
+var records = []injection.Iteration{
+	record("evm.stack", 0.5, "bit", json.Number("4"), 2),
+	record("evm.stack", 0.5, "bit", json.Number("-1"), 0),
+	record("evm.memory", 0.25, "variable", big.NewInt(-96), 5, 6),
+	record("evm.memory", 10, "variable", float64(1<<10), 10),
+	record("", 0.25, "variable", nil, 0, 2, 10),
+}
+
+// hasLine reports whether a line of table holds the columns of want, however
+// they are aligned.
+func hasLine(table, want string) bool {
+	for _, line := range strings.Split(table, "\n") {
+		if strings.Join(strings.Fields(line), " ") == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSummarize(t *testing.T) {
+	want := Summary{
+		Records: 5,
+		Bits:    8,
+		ByRate: []Count{ // numerically, not "10" < "0.25"
+			{Key: "0.25", Records: 2, Bits: 5},
+			{Key: "0.5", Records: 2, Bits: 2},
+			{Key: "10", Records: 1, Bits: 1},
+		},
+		BySite: []Count{
+			{Key: "", Records: 1, Bits: 3},
+			{Key: "evm.memory", Records: 2, Bits: 3},
+			{Key: "evm.stack", Records: 2, Bits: 2},
+		},
+		ByTestType: []Count{
+			{Key: "bit", Records: 2, Bits: 2},
+			{Key: "variable", Records: 3, Bits: 6},
+		},
+		BitPositions: []Count{
+			{Key: "0", Records: 2, Bits: 2},
+			{Key: "2", Records: 2, Bits: 2},
+			{Key: "5", Records: 1, Bits: 1},
+			{Key: "6", Records: 1, Bits: 1},
+			{Key: "10", Records: 2, Bits: 2},
+		},
+		DeltaMagnitudes: []Count{ // 1, 4, 96 and 1024 have bit lengths 1, 3, 7 and 11
+			{Key: "1", Records: 1, Bits: 1},
+			{Key: "3", Records: 1, Bits: 1},
+			{Key: "7", Records: 1, Bits: 2},
+			{Key: "11", Records: 1, Bits: 1},
+		},
+		PositiveDeltas: 2,
+		NegativeDeltas: 2,
+	}
+	if got := Summarize(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSummarizeEmpty(t *testing.T) {
+	summary := Summarize(nil)
+	if summary.Records != 0 || summary.Bits != 0 || len(summary.BySite) != 0 || len(summary.DeltaMagnitudes) != 0 {
+		t.Errorf("got %+v from no records", summary)
+	}
+	var b strings.Builder
+	if err := summary.WriteTable(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !hasLine(b.String(), "Records 0") {
+		t.Errorf("table of no records:\n%s", b.String())
+	}
+}
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		delta interface{}
+		want  *big.Int
+	}{
+		{big.NewInt(-3), big.NewInt(-3)},
+		{json.Number("340282366920938463463374607431768211456"), new(big.Int).Lsh(big.NewInt(1), 128)},
+		{json.Number("1.5"), nil},
+		{float64(-8), big.NewInt(-8)},
+		{"8", nil},
+		{nil, nil},
+	}
+	for _, test := range tests {
+		got := Delta(record("", 1, "bit", test.delta))
+		if (got == nil) != (test.want == nil) || (got != nil && got.Cmp(test.want) != 0) {
+			t.Errorf("Delta(%#v) = %v, want %v", test.delta, got, test.want)
+		}
+	}
+}
+
+// The stdout, jsonl, batch and collector formats all read back the same
+// record.
+func TestReadRecords(t *testing.T) {
+	const iter = `{"Campaign": "c", "Rate": 0.5, "ErrorData": {"IntBits": [3], "DeltaValue": 8, "Msg": "evm.stack"}}`
+	tests := []struct {
+		name  string
+		input string
+		n     int
+	}{
+		{"stdout", strings.Replace(iter, ", ", ",\n  ", -1) + "\n" + iter, 2},
+		{"jsonl", iter + "\n" + iter + "\n" + iter + "\n", 3},
+		{"batch", "[" + iter + ", " + iter + "]", 2},
+		{"collector", `[{"id": 1, "data": ` + iter + `}]`, 1},
+		{"empty", "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			iters, err := ReadRecords(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(iters) != test.n {
+				t.Fatalf("%d records, want %d", len(iters), test.n)
+			}
+			for _, iter := range iters {
+				data := iter.ErrorData
+				if iter.Campaign != "c" || iter.Rate != 0.5 || data.Msg != "evm.stack" || !reflect.DeepEqual(data.IntBits, []int{3}) || data.DeltaValue != json.Number("8") {
+					t.Errorf("read %+v", iter)
+				}
+			}
+		})
+	}
+}
+
+func TestReadRecordsMalformed(t *testing.T) {
+	for _, input := range []string{`{"Rate": `, `{"Rate": "high"}`, `[{"Rate": 1}, 3]`} {
+		if _, err := ReadRecords(strings.NewReader(input)); err == nil {
+			t.Errorf("no error reading %q", input)
+		}
+	}
+}
+
+func TestSummaryWriteTable(t *testing.T) {
+	var b strings.Builder
+	if err := Summarize(records).WriteTable(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Records 5",
+		"Bits flipped 8",
+		"evm.memory 2 40.0% 3",
+		"(none) 1 20.0% 3",
+		"0.25 2 40.0% 5",
+		"10 2 " + strings.Repeat("#", histogramWidth),
+		"5 1 " + strings.Repeat("#", histogramWidth/2),
+	} {
+		if !hasLine(b.String(), want) {
+			t.Errorf("table has no line %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// allSites is the key of the estimate over every run.
+const allSites = "all"
+
+// Estimate is the architectural vulnerability factor of a site: the fraction
+// of the runs injected at it whose fault became visible, as silent data
+// corruption, a detected error, a crash or a hang, rather than being masked.
+type Estimate struct {
+	Site     string         `json:"site"`
+	Runs     int            `json:"runs"`
+	Visible  int            `json:"visible"`
+	Outcomes map[string]int `json:"outcomes"`
+	AVF      float64        `json:"avf"`
+	Low      float64        `json:"low"`
+	High     float64        `json:"high"`
+	Margin   float64        `json:"margin"`
+	Needed   int            `json:"needed"` // runs for the target margin
+}
+
+// Estimates holds the AVF of every site and of the campaign as a whole.
+type Estimates struct {
+	Confidence float64    `json:"confidence"`
+	Target     float64    `json:"targetMargin"`
+	Uninjected int        `json:"uninjected"` // runs in which nothing was flipped
+	Total      Estimate   `json:"total"`
+	Sites      []Estimate `json:"sites"`
+}
+
+// ReadResults decodes every result written by 'flipcfg compare' in r, be they
+// single results or arrays of them. Numbers in the flips are kept as written,
+// since the delta of a memory flip overflows a float64.
+func ReadResults(r io.Reader) ([]harness.Result, error) {
+	dec := json.NewDecoder(r)
+	var results []harness.Result
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return results, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error unmarshaling results: %v", err)
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] != '[' {
+			raw = append(append([]byte{'['}, raw...), ']')
+		}
+		var decoded []harness.Result
+		array := json.NewDecoder(bytes.NewReader(raw))
+		array.UseNumber()
+		if err := array.Decode(&decoded); err != nil {
+			return nil, fmt.Errorf("error unmarshaling results: %v", err)
+		}
+		results = append(results, decoded...)
+	}
+}
+
+// EstimateAVF estimates the AVF of every site injected at in results, and of
+// every site in sites whether or not it was, with Wilson score intervals at
+// the given confidence. A run counts towards each distinct site it flipped at,
+// since which of its flips made the fault visible is not known; campaigns
+// meant for per site estimates should flip once per run.
+func EstimateAVF(results []harness.Result, sites []string, confidence, target float64) Estimates {
+	est := Estimates{Confidence: confidence, Target: target}
+	bySite := make(map[string]*Estimate)
+	get := func(site string) *Estimate {
+		e, ok := bySite[site]
+		if !ok {
+			e = &Estimate{Site: site, Outcomes: make(map[string]int)}
+			bySite[site] = e
+		}
+		return e
+	}
+	for _, site := range sites {
+		get(site)
+	}
+	total := &Estimate{Site: allSites, Outcomes: make(map[string]int)}
+	for _, result := range results {
+		if result.Outcome == "" {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, flip := range result.Faulty.Flips {
+			seen[flip.Iteration.ErrorData.Msg] = true
+		}
+		if len(seen) == 0 {
+			est.Uninjected++
+			continue
+		}
+		total.add(result.Outcome)
+		for site := range seen {
+			get(site).add(result.Outcome)
+		}
+	}
+
+	total.finish(confidence, target)
+	est.Total = *total
+	for _, e := range bySite {
+		e.finish(confidence, target)
+		est.Sites = append(est.Sites, *e)
+	}
+	sort.Slice(est.Sites, func(i, j int) bool {
+		if est.Sites[i].AVF != est.Sites[j].AVF {
+			return est.Sites[i].AVF > est.Sites[j].AVF
+		}
+		return est.Sites[i].Site < est.Sites[j].Site
+	})
+	return est
+}
+
+func (e *Estimate) add(outcome harness.Outcome) {
+	e.Runs++
+	e.Outcomes[string(outcome)]++
+	if outcome != harness.Masked {
+		e.Visible++
+	}
+}
+
+func (e *Estimate) finish(confidence, target float64) {
+	e.Low, e.High = 0, 1
+	if e.Runs > 0 {
+		e.AVF = float64(e.Visible) / float64(e.Runs)
+		e.Low, e.High = Wilson(e.Visible, e.Runs, confidence)
+	}
+	e.Margin = (e.High - e.Low) / 2
+	// Plan for the worst case the interval allows, as an AVF of 0 or 1
+	// observed in a few runs would otherwise call for a single run.
+	p := math.Min(math.Max(0.5, e.Low), e.High)
+	e.Needed = SampleSize(p, target, confidence, 0)
+}
+
+// ZScore returns the two sided critical value of the standard normal
+// distribution for confidence, 1.96 for 0.95.
+func ZScore(confidence float64) float64 {
+	return math.Sqrt2 * math.Erfinv(confidence)
+}
+
+// Wilson returns the Wilson score interval of the proportion of successes in
+// n trials. Unlike the normal approximation it stays within [0, 1] and is
+// usable when no or every run is visible, which is common for a single site.
+func Wilson(successes, n int, confidence float64) (float64, float64) {
+	if n == 0 {
+		return 0, 1
+	}
+	var (
+		z      = ZScore(confidence)
+		z2     = z * z
+		nf     = float64(n)
+		p      = float64(successes) / nf
+		denom  = 1 + z2/nf
+		center = (p + z2/(2*nf)) / denom
+		half   = z / denom * math.Sqrt(p*(1-p)/nf+z2/(4*nf*nf))
+	)
+	return math.Max(0, center-half), math.Min(1, center+half)
+}
+
+// SampleSize returns the number of runs needed to estimate an AVF near avf to
+// within margin at the given confidence. A population of the possible distinct
+// injections, if it is known and finite, reduces the number. With nothing
+// known about the AVF beforehand, 0.5 gives the worst case.
+func SampleSize(avf, margin, confidence float64, population int) int {
+	if margin <= 0 {
+		return 0
+	}
+	z := ZScore(confidence)
+	n := z * z * avf * (1 - avf) / (margin * margin)
+	if population > 0 {
+		n = n / (1 + (n-1)/float64(population))
+	}
+	if n < 1 {
+		return 1
+	}
+	return int(math.Ceil(n - 1e-9))
+}
+
+// WriteTable writes est as aligned text.
+func (est Estimates) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "AVF at %g%% confidence, %d runs", est.Confidence*100, est.Total.Runs)
+	if est.Uninjected > 0 {
+		fmt.Fprintf(w, " (%d more flipped nothing and are left out)", est.Uninjected)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Site\tRuns\tAVF\tInterval\t±\tNeeded ±%g\t", est.Target)
+	outcomes := []harness.Outcome{harness.Masked, harness.SDC, harness.Detected, harness.Crash, harness.Hang}
+	for _, outcome := range outcomes {
+		fmt.Fprintf(tw, "%s\t", outcome)
+	}
+	fmt.Fprintln(tw)
+	for _, e := range append(est.Sites, est.Total) {
+		avf := "-"
+		if e.Runs > 0 {
+			avf = fmt.Sprintf("%.3f", e.AVF)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t[%.3f, %.3f]\t%.3f\t%d\t", e.Site, e.Runs, avf, e.Low, e.High, e.Margin, e.Needed)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(tw, "%d\t", e.Outcomes[string(outcome)])
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+var (
+	confidenceFlag = cli.Float64Flag{
+		Name:  "confidence",
+		Usage: "Confidence level of the intervals",
+		Value: 0.95,
+	}
+	marginFlag = cli.Float64Flag{
+		Name:  "margin",
+		Usage: "Target margin of error of the AVF",
+		Value: 0.05,
+	}
+	avfFlag = cli.Float64Flag{
+		Name:  "avf",
+		Usage: "Expected AVF, if known from an earlier campaign",
+		Value: 0.5,
+	}
+	populationFlag = cli.IntFlag{
+		Name:  "population",
+		Usage: "Number of distinct injections possible, if finite (default: unbounded)",
+	}
+
+	// AVFCommand is the 'flipcfg avf' subcommand.
+	AVFCommand = cli.Command{
+		Action:    avf,
+		Name:      "avf",
+		Usage:     "Estimate the vulnerability factor of each site from classified runs",
+		ArgsUsage: "[result files...]",
+		Flags:     []cli.Flag{confidenceFlag, marginFlag, configFlag, formatFlag},
+		Description: `
+Reads the results of 'flipcfg compare --runs' from the given files, or standard
+input, and estimates the architectural vulnerability factor of every site: the
+fraction of the runs injected at it that did not end masked. Each estimate has
+a Wilson score interval and the number of runs it needs for the target margin
+of error. The sites of the configuration are listed even if they were never
+injected at.`,
+	}
+
+	// PlanCommand is the 'flipcfg plan' subcommand.
+	PlanCommand = cli.Command{
+		Action: plan,
+		Name:   "plan",
+		Usage:  "Work out how many runs a campaign needs before starting it",
+		Flags:  []cli.Flag{marginFlag, confidenceFlag, avfFlag, populationFlag, configFlag},
+		Description: `
+Prints the number of runs needed to estimate an AVF to within the margin of
+error at the confidence level. The default expected AVF of 0.5 is the worst
+case. The number is per site, and is multiplied out over the sites of the
+configuration.`,
+	}
+)
+
+// campaignConfig reads the configuration named by --config, or the current
+// one. It is an error only if --config was given.
+func campaignConfig(ctx *cli.Context) (*config.Config, error) {
+	var (
+		cfg config.Config
+		err error
+	)
+	if path := ctx.String(configFlag.Name); path != "" {
+		cfg, err = config.ReadConfigFile(path)
+	} else {
+		cfg, err = config.ReadConfig()
+	}
+	if err != nil {
+		if ctx.IsSet(configFlag.Name) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+func checkLevels(confidence, margin float64) error {
+	if confidence <= 0 || confidence >= 1 {
+		return fmt.Errorf("confidence must be between 0 and 1, not %g", confidence)
+	}
+	if margin <= 0 || margin >= 1 {
+		return fmt.Errorf("margin must be between 0 and 1, not %g", margin)
+	}
+	return nil
+}
+
+func avf(ctx *cli.Context) error {
+	confidence, margin := ctx.Float64(confidenceFlag.Name), ctx.Float64(marginFlag.Name)
+	if err := checkLevels(confidence, margin); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	cfg, err := campaignConfig(ctx)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	var sites []string
+	if cfg != nil {
+		sites = cfg.Sites
+	}
+
+	var results []harness.Result
+	if len(ctx.Args()) == 0 {
+		if results, err = ReadResults(os.Stdin); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	}
+	for _, path := range ctx.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("error reading in results from %s", path), 1)
+		}
+		decoded, err := ReadResults(file)
+		file.Close()
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("%s: %v", path, err), 1)
+		}
+		results = append(results, decoded...)
+	}
+	est := EstimateAVF(results, sites, confidence, margin)
+
+	switch ctx.String(formatFlag.Name) {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(est)
+	case "table":
+		return est.WriteTable(os.Stdout)
+	}
+	return cli.NewExitError(fmt.Sprintf("unknown format \"%s\"", ctx.String(formatFlag.Name)), 1)
+}
+
+func plan(ctx *cli.Context) error {
+	confidence, margin := ctx.Float64(confidenceFlag.Name), ctx.Float64(marginFlag.Name)
+	if err := checkLevels(confidence, margin); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	expected := ctx.Float64(avfFlag.Name)
+	if expected < 0 || expected > 1 {
+		return cli.NewExitError(fmt.Sprintf("expected AVF must be between 0 and 1, not %g", expected), 1)
+	}
+	cfg, err := campaignConfig(ctx)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	n := SampleSize(expected, margin, confidence, ctx.Int(populationFlag.Name))
+	fmt.Printf("%d runs per site for an AVF of %g within ±%g at %g%% confidence\n", n, expected, margin, confidence*100)
+	if cfg != nil && len(cfg.Sites) > 1 {
+		fmt.Printf("%d runs for the %d sites of the configuration, flipping once per run\n", n*len(cfg.Sites), len(cfg.Sites))
+	}
+	return nil
+}
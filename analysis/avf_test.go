@@ -0,0 +1,112 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"github.com/griffindavis02/eth-bit-flip/tracer"
+)
+
+func TestWilson(t *testing.T) {
+	tests := []struct {
+		successes, n int
+		confidence   float64
+		low, high    float64
+	}{
+		{0, 0, 0.95, 0, 1},
+		{0, 10, 0.95, 0, 0.2775},
+		{10, 10, 0.95, 0.7225, 1},
+		{5, 10, 0.95, 0.2366, 0.7634},
+		{20, 100, 0.99, 0.1172, 0.3202},
+	}
+	for _, test := range tests {
+		low, high := Wilson(test.successes, test.n, test.confidence)
+		if math.Abs(low-test.low) > 1e-4 || math.Abs(high-test.high) > 1e-4 {
+			t.Errorf("Wilson(%d, %d, %g) = [%.4f, %.4f], want [%.4f, %.4f]",
+				test.successes, test.n, test.confidence, low, high, test.low, test.high)
+		}
+	}
+}
+
+func TestSampleSize(t *testing.T) {
+	tests := []struct {
+		avf, margin, confidence float64
+		population              int
+		want                    int
+	}{
+		{0.5, 0.05, 0.95, 0, 385},
+		{0.5, 0.05, 0.95, 1000, 278},
+		{0.1, 0.01, 0.99, 0, 5972},
+		{0.5, 0.5, 0.5, 0, 1},
+		{0.5, 0, 0.95, 0, 0},
+	}
+	for _, test := range tests {
+		if got := SampleSize(test.avf, test.margin, test.confidence, test.population); got != test.want {
+			t.Errorf("SampleSize(%g, %g, %g, %d) = %d, want %d",
+				test.avf, test.margin, test.confidence, test.population, got, test.want)
+		}
+	}
+}
+
+// ReadResults reads what flipcfg compare --workload corpus --tracer --runs 20
+// writes, whose memory flips have deltas beyond a float64.
+func TestReadResults(t *testing.T) {
+	cfg := configtest.New(t, 1.0/256)
+	corpus := harness.NewCorpusWorkload(nil)
+	corpus.Tracer = tracer.NewInjector()
+	results, err := harness.CompareRuns(corpus, cfg, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(results); err != nil {
+		t.Fatal(err)
+	}
+	// A single result, as written by --runs 1, follows the array
+	huge := "1" + strings.Repeat("0", 400)
+	out.WriteString(`{"outcome": "sdc", "faulty": {"flips": [{"iteration": {"ErrorData": {"Msg": "evm.memory", "DeltaValue": ` + huge + `}}}]}}`)
+
+	read, err := ReadResults(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(results)+1 {
+		t.Fatalf("%d results read, want %d", len(read), len(results)+1)
+	}
+	for i, result := range results {
+		if read[i].Outcome != result.Outcome || len(read[i].Faulty.Flips) != len(result.Faulty.Flips) {
+			t.Errorf("result %d: outcome %s with %d flips, want %s with %d", i,
+				read[i].Outcome, len(read[i].Faulty.Flips), result.Outcome, len(result.Faulty.Flips))
+		}
+	}
+	last := read[len(results)]
+	if delta := Delta(last.Faulty.Flips[0].Iteration); delta == nil || delta.String() != huge {
+		t.Errorf("delta %v, want %s", delta, huge)
+	}
+	if est := EstimateAVF(read, nil, 0.95, 0.05); est.Total.Runs+est.Uninjected != len(read) {
+		t.Errorf("estimate over %d runs and %d uninjected, want %d", est.Total.Runs, est.Uninjected, len(read))
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	formatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: table or json",
+		Value: "table",
+	}
+	reportFormatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Report format: html or markdown",
+		Value: "html",
+	}
+	configFlag = cli.StringFlag{
+		Name:  "config",
+		Usage: "Configuration file the campaign was run with (default: the current configuration)",
+	}
+	outcomesFlag = cli.StringFlag{
+		Name:  "outcomes",
+		Usage: "File of classified run results to include",
+	}
+	outputFlag = cli.StringFlag{
+		Name:  "output, o",
+		Usage: "File to write the report to (default: standard output)",
+	}
+
+	// AnalyzeCommand is the 'flipcfg analyze' subcommand.
+	AnalyzeCommand = cli.Command{
+		Action:    analyze,
+		Name:      "analyze",
+		Usage:     "Summarise the records of a campaign",
+		ArgsUsage: "[record files...]",
+		Flags:     []cli.Flag{formatFlag},
+		Description: `
+Reads iteration records from the given files, or standard input, and breaks
+them down by error rate, site and test type, with histograms of the flipped
+bit positions and of the magnitude of the change in value. The records may be
+the stdout or jsonl sink output, or the output of the collector's /records
+endpoint.`,
+	}
+
+	// ReportCommand is the 'flipcfg report' subcommand.
+	ReportCommand = cli.Command{
+		Action:    report,
+		Name:      "report",
+		Usage:     "Write an HTML or Markdown report of a campaign",
+		ArgsUsage: "[record files...]",
+		Flags:     []cli.Flag{reportFormatFlag, configFlag, outcomesFlag, outputFlag},
+		Description: `
+Writes a self-contained report of the records read from the given files, or
+standard input, together with the parameters of the configuration the campaign
+was run with. The report needs no network access to be viewed.`,
+	}
+)
+
+func analyze(ctx *cli.Context) error {
+	iters, err := ReadFiles(ctx.Args())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	summary := Summarize(iters)
+
+	switch ctx.String(formatFlag.Name) {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(summary)
+	case "table":
+		return summary.WriteTable(os.Stdout)
+	}
+	return cli.NewExitError(fmt.Sprintf("unknown format \"%s\"", ctx.String(formatFlag.Name)), 1)
+}
+
+func report(ctx *cli.Context) error {
+	iters, err := ReadFiles(ctx.Args())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	var cfg config.Config
+	if path := ctx.String(configFlag.Name); path != "" {
+		cfg, err = config.ReadConfigFile(path)
+	} else {
+		cfg, err = config.ReadConfig()
+	}
+	var pCfg *config.Config
+	if err == nil {
+		pCfg = &cfg
+	} else if ctx.IsSet(configFlag.Name) {
+		return cli.NewExitError(err, 1)
+	}
+	var outcomes []Count
+	if path := ctx.String(outcomesFlag.Name); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("error reading in outcomes from %s", path), 1)
+		}
+		outcomes, err = ReadOutcomes(file)
+		file.Close()
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	}
+	rep := NewReport(iters, pCfg, outcomes)
+
+	var out io.Writer = os.Stdout
+	if path := ctx.String("output"); path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("error creating file \"%s\"", path), 1)
+		}
+		defer file.Close()
+		out = file
+	}
+	switch ctx.String(reportFormatFlag.Name) {
+	case "html":
+		err = rep.WriteHTML(out)
+	case "markdown", "md":
+		err = rep.WriteMarkdown(out)
+	default:
+		err = fmt.Errorf("unknown format \"%s\"", ctx.String(reportFormatFlag.Name))
+	}
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Suspect is a flip ranked by how likely it is to have caused a crash.
+type Suspect struct {
+	Flip    injection.CrashFlip
+	Age     int // flips made after this one
+	Score   int
+	Reasons []string
+}
+
+// panicNumber matches the decimal and hexadecimal numbers in a panic message.
+var panicNumber = regexp.MustCompile(`0x[0-9a-fA-F]+|\b[0-9]+\b`)
+
+// Suspects ranks the flips of a crash report, most likely cause first. A flip
+// scores for having been made from a function on the panicking stack, for its
+// corrupted value appearing in the panic message and for being recent.
+func Suspects(report *injection.CrashReport) []Suspect {
+	var values []*big.Int
+	for _, match := range panicNumber.FindAllString(report.Panic, -1) {
+		if v, ok := new(big.Int).SetString(match, 0); ok && v.Sign() > 0 {
+			values = append(values, v)
+		}
+	}
+	suspects := make([]Suspect, len(report.Flips))
+	for i, flip := range report.Flips {
+		s := Suspect{Flip: flip, Age: len(report.Flips) - 1 - i}
+		if flip.Caller != "" && strings.Contains(report.Stack, flip.Caller+"(") {
+			s.Score += 3
+			s.Reasons = append(s.Reasons, "made on the panicking stack")
+		}
+		if value, ok := new(big.Int).SetString(strings.TrimPrefix(flip.ErrorData.ErrorByte, "0x"), 16); ok {
+			for _, v := range values {
+				if v.Cmp(value) == 0 {
+					s.Score += 2
+					s.Reasons = append(s.Reasons, "corrupted value in the panic message")
+					break
+				}
+			}
+		}
+		if s.Age == 0 {
+			s.Score++
+			s.Reasons = append(s.Reasons, "most recent flip")
+		}
+		suspects[i] = s
+	}
+	sort.SliceStable(suspects, func(i, j int) bool {
+		if suspects[i].Score != suspects[j].Score {
+			return suspects[i].Score > suspects[j].Score
+		}
+		return suspects[i].Age < suspects[j].Age
+	})
+	return suspects
+}
+
+// ParsePanic finds the last panic in a process's output, such as geth's
+// standard error, returning the panic message and the goroutine trace after
+// it.
+func ParsePanic(output string) (string, string) {
+	start := strings.LastIndex(output, "\npanic: ")
+	if start < 0 {
+		if !strings.HasPrefix(output, "panic: ") {
+			return "", ""
+		}
+	} else {
+		output = output[start+1:]
+	}
+	output = strings.TrimPrefix(output, "panic: ")
+	msg, stack := output, ""
+	if end := strings.IndexByte(output, '\n'); end >= 0 {
+		msg, stack = output[:end], strings.TrimSpace(output[end+1:])
+	}
+	return strings.TrimSuffix(msg, " [recovered]"), stack
+}
+
+var (
+	crashFileFlag = cli.StringFlag{
+		Name:  "file",
+		Usage: "Crash file to read (default: the configured crash file)",
+	}
+	crashLogFlag = cli.StringFlag{
+		Name:  "log",
+		Usage: "Output of the crashed process to take the panic from, if it was not captured",
+	}
+	crashTopFlag = cli.IntFlag{
+		Name:  "top",
+		Usage: "Number of suspect flips to list",
+		Value: 5,
+	}
+
+	// CrashCommand is the 'flipcfg crash' subcommand.
+	CrashCommand = cli.Command{
+		Action: crash,
+		Name:   "crash",
+		Usage:  "Show which flip most likely caused a crash",
+		Flags:  []cli.Flag{crashFileFlag, crashLogFlag, crashTopFlag},
+		Description: `
+Reads the crash file holding the most recent flips of a process and ranks them
+by how likely they are to have caused its panic. The panic is captured in the
+crash file by injection.CapturePanic; panics in other goroutines can be read
+from the process's output with --log.`,
+	}
+)
+
+func crash(ctx *cli.Context) error {
+	path := ctx.String(crashFileFlag.Name)
+	if path == "" {
+		cfg, _ := config.ReadConfig()
+		path = injection.CrashPath(&cfg)
+	}
+	report, err := injection.ReadCrashReport(path)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if logPath := ctx.String(crashLogFlag.Name); logPath != "" {
+		output, err := os.ReadFile(logPath)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("error reading in log from %s", logPath), 1)
+		}
+		if msg, stack := ParsePanic(string(output)); msg != "" {
+			report.Panic, report.Stack = msg, stack
+		}
+	}
+	writeCrash(os.Stdout, report, Suspects(report), ctx.Int(crashTopFlag.Name))
+	return nil
+}
+
+// stackFrames is the number of lines of the stack shown.
+const stackFrames = 12
+
+func writeCrash(w io.Writer, report *injection.CrashReport, suspects []Suspect, top int) {
+	fmt.Fprintf(w, "Crash file written %s by process %d\n", report.Written.Format("2006-01-02 15:04:05"), report.Pid)
+	if report.Panic == "" {
+		fmt.Fprintln(w, "No panic was captured; pass the process output with --log.")
+	} else {
+		fmt.Fprintf(w, "Panic: %s\n", report.Panic)
+		lines := strings.Split(strings.TrimSpace(report.Stack), "\n")
+		if len(lines) > stackFrames {
+			lines = append(lines[:stackFrames], "...")
+		}
+		for _, line := range lines {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+	if len(suspects) == 0 {
+		fmt.Fprintln(w, "\nNo flips were recorded.")
+		return
+	}
+	if top > 0 && len(suspects) > top {
+		suspects = suspects[:top]
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Score\tAge\tSite\tWhen\tPrevious\tError\tCaller\tReasons")
+	for _, s := range suspects {
+		caller := s.Flip.Caller
+		if s.Flip.File != "" {
+			caller = fmt.Sprintf("%s (%s:%d)", caller, s.Flip.File, s.Flip.Line)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Score, s.Age, s.Flip.ErrorData.Msg, s.Flip.ErrorData.When,
+			s.Flip.ErrorData.PreviousByte, s.Flip.ErrorData.ErrorByte, caller, strings.Join(s.Reasons, "; "))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package analysis summarises the iteration records of a campaign.
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// ReadRecords decodes every iteration in r. It accepts the indented JSON
+// printed by the stdout sink, the lines of the jsonl sink, JSON arrays of
+// records as posted in batches and the output of the collector's /records
+// endpoint.
+func ReadRecords(r io.Reader) ([]injection.Iteration, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var iters []injection.Iteration
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return iters, nil
+		} else if err != nil {
+			return iters, fmt.Errorf("error unmarshaling records: %v", err)
+		}
+		decoded, err := decodeValue(raw)
+		if err != nil {
+			return iters, err
+		}
+		iters = append(iters, decoded...)
+	}
+}
+
+// ReadFiles reads the records in every file, or standard input if there are
+// none.
+func ReadFiles(paths []string) ([]injection.Iteration, error) {
+	if len(paths) == 0 {
+		return ReadRecords(os.Stdin)
+	}
+	var iters []injection.Iteration
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading in records from %s", path)
+		}
+		decoded, err := ReadRecords(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		iters = append(iters, decoded...)
+	}
+	return iters, nil
+}
+
+func decodeValue(raw json.RawMessage) ([]injection.Iteration, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(raw, &raws); err != nil {
+			return nil, err
+		}
+		var iters []injection.Iteration
+		for _, item := range raws {
+			decoded, err := decodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			iters = append(iters, decoded...)
+		}
+		return iters, nil
+	}
+	// Collector records wrap the iteration in a data field.
+	var wrapped struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil && len(wrapped.Data) > 0 {
+		raw = wrapped.Data
+	}
+	var iter injection.Iteration
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&iter); err != nil {
+		return nil, fmt.Errorf("error unmarshaling record: %v", err)
+	}
+	return []injection.Iteration{iter}, nil
+}
+
+// Delta returns the DeltaValue of iter as an integer, or nil if it is missing.
+func Delta(iter injection.Iteration) *big.Int {
+	switch delta := iter.ErrorData.DeltaValue.(type) {
+	case *big.Int:
+		return delta
+	case json.Number:
+		if d, ok := new(big.Int).SetString(delta.String(), 10); ok {
+			return d
+		}
+	case float64:
+		d, _ := big.NewFloat(delta).Int(nil)
+		return d
+	}
+	return nil
+}
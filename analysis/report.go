@@ -0,0 +1,458 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// RateSpan is the part of a campaign spent at one error rate.
+type RateSpan struct {
+	Index   int
+	Rate    float64
+	Records int
+	Bits    int
+	First   time.Time
+	Last    time.Time
+}
+
+// SiteTable breaks the records of one site down by error rate.
+type SiteTable struct {
+	Site   string
+	Counts []Count
+}
+
+// Report is everything shown in a campaign report.
+type Report struct {
+	Generated   time.Time
+	Config      *config.Config // nil if the configuration is unknown
+	Summary     Summary
+	Timeline    []RateSpan
+	Sites       []SiteTable
+	Outcomes    []Count
+	Heatmap     string // SVG
+	GethVersion string
+	ToolVersion string
+}
+
+// NewReport builds the report for iters. cfg may be nil, in which case the
+// parameters are taken from the records alone; outcomes may be nil if the
+// runs were not classified.
+func NewReport(iters []injection.Iteration, cfg *config.Config, outcomes []Count) *Report {
+	report := &Report{
+		Generated:   time.Now(),
+		Config:      cfg,
+		Summary:     Summarize(iters),
+		Timeline:    timeline(iters, cfg),
+		Sites:       siteTables(iters),
+		Outcomes:    outcomes,
+		GethVersion: gethVersions(iters),
+		ToolVersion: "(devel)",
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.ToolVersion = info.Main.Version
+	}
+	report.Heatmap = heatmap(iters)
+	return report
+}
+
+// gethVersions lists the go-ethereum versions the records were injected by,
+// as recorded at run time.
+func gethVersions(iters []injection.Iteration) string {
+	var (
+		versions []string
+		seen     = make(map[string]bool)
+	)
+	for _, iter := range iters {
+		if v := iter.GethVersion; v != "" && !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return "unknown (not recorded)"
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ", ")
+}
+
+// ReadOutcomes counts the "outcome" field of the run results in r, as
+// written by 'flipcfg compare'. Results may be concatenated or in an array.
+func ReadOutcomes(r io.Reader) ([]Count, error) {
+	dec := json.NewDecoder(r)
+	counts := counter{}
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return counts.sorted(false), nil
+		} else if err != nil {
+			return nil, fmt.Errorf("error unmarshaling outcomes: %v", err)
+		}
+		var runs []struct {
+			Outcome string `json:"outcome"`
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] != '[' {
+			raw = append(append([]byte{'['}, raw...), ']')
+		}
+		if err := json.Unmarshal(raw, &runs); err != nil {
+			return nil, fmt.Errorf("error unmarshaling outcomes: %v", err)
+		}
+		for _, run := range runs {
+			if run.Outcome != "" {
+				counts.add(run.Outcome, 1, 0)
+			}
+		}
+	}
+}
+
+// recordTime parses the When of iter, returning the zero time if it is
+// missing or malformed.
+func recordTime(iter injection.Iteration) time.Time {
+	when, _ := injection.ParseWhen(iter.ErrorData.When)
+	return when
+}
+
+// timeline returns the rates of the campaign in the order they were run.
+func timeline(iters []injection.Iteration, cfg *config.Config) []RateSpan {
+	var spans []RateSpan
+	index := make(map[float64]int)
+	if cfg != nil {
+		for i, rate := range cfg.State.ErrorRates {
+			if _, ok := index[rate]; !ok {
+				index[rate] = len(spans)
+				spans = append(spans, RateSpan{Index: i, Rate: rate})
+			}
+		}
+	}
+	for _, iter := range iters {
+		i, ok := index[iter.Rate]
+		if !ok {
+			i = len(spans)
+			index[iter.Rate] = i
+			spans = append(spans, RateSpan{Index: i, Rate: iter.Rate})
+		}
+		span := &spans[i]
+		span.Records++
+		span.Bits += len(iter.ErrorData.IntBits)
+		if when := recordTime(iter); !when.IsZero() {
+			if span.First.IsZero() || when.Before(span.First) {
+				span.First = when
+			}
+			if when.After(span.Last) {
+				span.Last = when
+			}
+		}
+	}
+	if cfg == nil {
+		sort.SliceStable(spans, func(i, j int) bool {
+			return spans[i].First.Before(spans[j].First)
+		})
+		for i := range spans {
+			spans[i].Index = i
+		}
+	}
+	return spans
+}
+
+// Duration is the time between the first and last record at the rate.
+func (span RateSpan) Duration() time.Duration {
+	return span.Last.Sub(span.First)
+}
+
+func siteTables(iters []injection.Iteration) []SiteTable {
+	sites := make(map[string]counter)
+	for _, iter := range iters {
+		rates, ok := sites[iter.ErrorData.Msg]
+		if !ok {
+			rates = counter{}
+			sites[iter.ErrorData.Msg] = rates
+		}
+		rates.add(formatRate(iter.Rate), 1, len(iter.ErrorData.IntBits))
+	}
+	tables := make([]SiteTable, 0, len(sites))
+	for site, rates := range sites {
+		tables = append(tables, SiteTable{Site: site, Counts: rates.sorted(true)})
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Site < tables[j].Site })
+	return tables
+}
+
+// Heatmap layout, in pixels.
+const (
+	heatmapCell    = 8
+	heatmapRow     = 16
+	heatmapLabel   = 160
+	heatmapMaxCols = 128
+)
+
+// heatmap draws an SVG of flips per site and bit position. Wide values are
+// grouped into at most heatmapMaxCols columns of equal width.
+func heatmap(iters []injection.Iteration) string {
+	maxBit := 0
+	cells := make(map[string]map[int]int)
+	for _, iter := range iters {
+		for _, bit := range iter.ErrorData.IntBits {
+			if bit > maxBit {
+				maxBit = bit
+			}
+		}
+	}
+	group := (maxBit + heatmapMaxCols) / heatmapMaxCols
+	columns := maxBit/group + 1
+
+	most := 0
+	for _, iter := range iters {
+		row, ok := cells[iter.ErrorData.Msg]
+		if !ok {
+			row = make(map[int]int)
+			cells[iter.ErrorData.Msg] = row
+		}
+		for _, bit := range iter.ErrorData.IntBits {
+			row[bit/group]++
+			if row[bit/group] > most {
+				most = row[bit/group]
+			}
+		}
+	}
+	sites := make([]string, 0, len(cells))
+	for site := range cells {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+
+	width := heatmapLabel + columns*heatmapCell
+	height := (len(sites) + 1) * heatmapRow
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="10">`, width, height)
+	for y, site := range sites {
+		label := site
+		if label == "" {
+			label = "(none)"
+		}
+		fmt.Fprintf(&b, `<text x="0" y="%d">%s</text>`, y*heatmapRow+11, htmltemplate.HTMLEscapeString(label))
+		for col := 0; col < columns; col++ {
+			count := cells[site][col]
+			shade := 0.0
+			if most > 0 {
+				shade = math.Sqrt(float64(count) / float64(most))
+			}
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="rgb(255,%d,%d)"><title>bits %d-%d: %d</title></rect>`,
+				heatmapLabel+col*heatmapCell, y*heatmapRow, heatmapCell, heatmapRow-2,
+				int(255-200*shade), int(255-255*shade), col*group, (col+1)*group-1, count)
+		}
+	}
+	for col := 0; col < columns; col += 8 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%d</text>`, heatmapLabel+col*heatmapCell, len(sites)*heatmapRow+11, col*group)
+	}
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+func formatRate(rate float64) string {
+	return fmt.Sprintf("%g", rate)
+}
+
+var reportFuncs = map[string]interface{}{
+	"rate": formatRate,
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05.000")
+	},
+	"site": func(site string) string {
+		if site == "" {
+			return "(none)"
+		}
+		return site
+	},
+	"share": func(n, total int) string {
+		if total == 0 {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+	},
+	"svg": func(svg string) htmltemplate.HTML {
+		return htmltemplate.HTML(svg)
+	},
+	"svgdata": func(svg string) string {
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg))
+	},
+	"join": func(items []string) string {
+		if len(items) == 0 {
+			return "all"
+		}
+		return strings.Join(items, ", ")
+	},
+}
+
+// WriteMarkdown writes the report as Markdown. The heatmap is embedded as a
+// data URI so the file needs nothing else to render.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	return markdownTemplate.Execute(w, r)
+}
+
+// WriteHTML writes the report as a standalone HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(`# Campaign report{{with .Config}}{{if .Campaign}}: {{.Campaign}}{{end}}{{end}}
+
+Generated {{time .Generated}}.
+
+## Parameters
+{{with .Config}}
+| Parameter | Value |
+| --------- | ----- |
+| Campaign | {{.Campaign}} |
+| Test type | {{.State.TestType}} |
+| Bits per rate | {{.State.Bits}} |
+| Variables per rate | {{.State.VariablesChanged}} |
+| Duration per rate | {{.State.Duration}} |
+| Error rates | {{range $i, $r := .State.ErrorRates}}{{if $i}}, {{end}}{{rate $r}}{{end}} |
+| Sites | {{join .Sites}} |
+{{else}}
+No configuration was given; parameters are inferred from the records.
+{{end}}
+## Reproducibility
+
+| | |
+| - | - |
+| Seed | {{with .Config}}{{if .Seed}}{{.Seed}}{{else}}none, seeded from the clock{{end}}{{else}}unknown{{end}} |
+| go-ethereum | {{.GethVersion}} |
+| eth-bit-flip | {{.ToolVersion}} |
+
+## Summary
+
+{{.Summary.Records}} records, {{.Summary.Bits}} bits flipped. {{.Summary.PositiveDeltas}} flips increased the value and {{.Summary.NegativeDeltas}} decreased it.
+
+## Rate timeline
+
+| # | Rate | Records | Bits | First | Last | Duration |
+| - | ---- | ------- | ---- | ----- | ---- | -------- |
+{{range .Timeline}}| {{.Index}} | {{rate .Rate}} | {{.Records}} | {{.Bits}} | {{time .First}} | {{time .Last}} | {{.Duration}} |
+{{end}}
+## Sites
+
+| Site | Records | Share | Bits |
+| ---- | ------- | ----- | ---- |
+{{range .Summary.BySite}}| {{site .Key}} | {{.Records}} | {{share .Records $.Summary.Records}} | {{.Bits}} |
+{{end}}{{range .Sites}}
+### {{site .Site}}
+
+| Rate | Records | Bits |
+| ---- | ------- | ---- |
+{{range .Counts}}| {{.Key}} | {{.Records}} | {{.Bits}} |
+{{end}}{{end}}
+## Bit positions
+
+Flips per site and bit position, bit 0 being the least significant.
+
+![Bit position heatmap]({{svgdata .Heatmap}})
+
+## Outcomes
+{{if .Outcomes}}
+| Outcome | Runs |
+| ------- | ---- |
+{{range .Outcomes}}| {{.Key}} | {{.Records}} |
+{{end}}{{else}}
+No run outcomes were given.
+{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Campaign report{{with .Config}}{{if .Campaign}}: {{.Campaign}}{{end}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>Campaign report{{with .Config}}{{if .Campaign}}: {{.Campaign}}{{end}}{{end}}</h1>
+<p>Generated {{time .Generated}}.</p>
+
+<h2>Parameters</h2>
+{{with .Config}}<table>
+<tr><th>Campaign</th><td>{{.Campaign}}</td></tr>
+<tr><th>Test type</th><td>{{.State.TestType}}</td></tr>
+<tr><th>Bits per rate</th><td>{{.State.Bits}}</td></tr>
+<tr><th>Variables per rate</th><td>{{.State.VariablesChanged}}</td></tr>
+<tr><th>Duration per rate</th><td>{{.State.Duration}}</td></tr>
+<tr><th>Error rates</th><td>{{range $i, $r := .State.ErrorRates}}{{if $i}}, {{end}}{{rate $r}}{{end}}</td></tr>
+<tr><th>Sites</th><td>{{join .Sites}}</td></tr>
+</table>
+{{else}}<p>No configuration was given; parameters are inferred from the records.</p>
+{{end}}
+<h2>Reproducibility</h2>
+<table>
+<tr><th>Seed</th><td>{{with .Config}}{{if .Seed}}{{.Seed}}{{else}}none, seeded from the clock{{end}}{{else}}unknown{{end}}</td></tr>
+<tr><th>go-ethereum</th><td>{{.GethVersion}}</td></tr>
+<tr><th>eth-bit-flip</th><td>{{.ToolVersion}}</td></tr>
+</table>
+
+<h2>Summary</h2>
+<p>{{.Summary.Records}} records, {{.Summary.Bits}} bits flipped. {{.Summary.PositiveDeltas}} flips increased the value and {{.Summary.NegativeDeltas}} decreased it.</p>
+
+<h2>Rate timeline</h2>
+<table>
+<tr><th>#</th><th>Rate</th><th>Records</th><th>Bits</th><th>First</th><th>Last</th><th>Duration</th></tr>
+{{range .Timeline}}<tr><td>{{.Index}}</td><td>{{rate .Rate}}</td><td>{{.Records}}</td><td>{{.Bits}}</td><td>{{time .First}}</td><td>{{time .Last}}</td><td>{{.Duration}}</td></tr>
+{{end}}</table>
+
+<h2>Sites</h2>
+<table>
+<tr><th>Site</th><th>Records</th><th>Share</th><th>Bits</th></tr>
+{{range .Summary.BySite}}<tr><td>{{site .Key}}</td><td>{{.Records}}</td><td>{{share .Records $.Summary.Records}}</td><td>{{.Bits}}</td></tr>
+{{end}}</table>
+{{range .Sites}}<h3>{{site .Site}}</h3>
+<table>
+<tr><th>Rate</th><th>Records</th><th>Bits</th></tr>
+{{range .Counts}}<tr><td>{{.Key}}</td><td>{{.Records}}</td><td>{{.Bits}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Bit positions</h2>
+<p>Flips per site and bit position, bit 0 being the least significant.</p>
+{{svg .Heatmap}}
+
+<h2>Outcomes</h2>
+{{if .Outcomes}}<table>
+<tr><th>Outcome</th><th>Runs</th></tr>
+{{range .Outcomes}}<tr><td>{{.Key}}</td><td>{{.Records}}</td></tr>
+{{end}}</table>
+{{else}}<p>No run outcomes were given.</p>
+{{end}}</body>
+</html>
+`))
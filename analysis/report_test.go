@@ -0,0 +1,256 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+var start = time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+// timed returns the record of the flip of bits at site, made after seconds
+// of the campaign.
+func timed(site string, rate float64, seconds int, bits ...int) injection.Iteration {
+	iter := record(site, rate, "bit", nil, bits...)
+	iter.ErrorData.When = start.Add(time.Duration(seconds) * time.Second).Format(injection.TimeFormat)
+	iter.GethVersion = "v1.10.13"
+	return iter
+}
+
+// campaign ran its second rate first, as the records would show if the rates
+// were replaced, and its last rate not at all.
+var campaign = []injection.Iteration{
+	timed("evm.stack", 0.5, 0, 1),
+	timed("evm.stack", 0.5, 3, 2, 3),
+	timed("<db>", 0.5, 2, 200),
+	timed("evm.stack", 0.1, 10, 1),
+	timed("evm.stack", 0.1, 12),
+}
+
+// configure returns the configuration of campaign, whose state is of a type
+// only the config package can spell.
+func configure() *config.Config {
+	cfg := &config.Config{Campaign: "stack & db", Seed: 7, Sites: []string{"evm.stack", "<db>"}}
+	cfg.State.TestType = "bit"
+	cfg.State.ErrorRates = []float64{0.1, 0.5, 0.1, 0.9}
+	return cfg
+}
+
+func TestTimeline(t *testing.T) {
+	span := func(index int, rate float64, records, bits, first, last int) RateSpan {
+		return RateSpan{Index: index, Rate: rate, Records: records, Bits: bits,
+			First: start.Add(time.Duration(first) * time.Second), Last: start.Add(time.Duration(last) * time.Second)}
+	}
+	tests := []struct {
+		name string
+		cfg  *config.Config
+		want []RateSpan
+	}{
+		// In the order of the configuration, a repeated rate in its first
+		// place and a rate without records left empty
+		{"config", configure(), []RateSpan{
+			span(0, 0.1, 2, 1, 10, 12),
+			span(1, 0.5, 3, 4, 0, 3),
+			{Index: 3, Rate: 0.9},
+		}},
+		// In the order the rates were first flipped at
+		{"records", nil, []RateSpan{
+			span(0, 0.5, 3, 4, 0, 3),
+			span(1, 0.1, 2, 1, 10, 12),
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := timeline(campaign, test.cfg)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+			for i := range got {
+				g, w := got[i], test.want[i]
+				if g.Index != w.Index || g.Rate != w.Rate || g.Records != w.Records || g.Bits != w.Bits ||
+					!g.First.Equal(w.First) || !g.Last.Equal(w.Last) {
+					t.Errorf("span %d: got %+v, want %+v", i, g, w)
+				}
+			}
+			if d := got[0].Duration(); test.cfg != nil && d != 2*time.Second {
+				t.Errorf("first span lasted %v, want 2s", d)
+			}
+		})
+	}
+}
+
+func TestSiteTables(t *testing.T) {
+	want := []SiteTable{
+		{Site: "<db>", Counts: []Count{{Key: "0.5", Records: 1, Bits: 1}}},
+		{Site: "evm.stack", Counts: []Count{{Key: "0.1", Records: 2, Bits: 1}, {Key: "0.5", Records: 2, Bits: 3}}},
+	}
+	if got := siteTables(campaign); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// Bits beyond the width of the heatmap are grouped into its columns.
+func TestHeatmap(t *testing.T) {
+	svg := heatmap(campaign)
+	for _, want := range []string{
+		`width="968"`, // 101 columns of two bits after the labels
+		`<text x="0" y="11">&lt;db&gt;</text>`,
+		`<title>bits 200-201: 1</title>`,
+		`<title>bits 2-3: 2</title>`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("heatmap has no %s", want)
+		}
+	}
+	if strings.Contains(svg, "<db>") {
+		t.Error("site name not escaped")
+	}
+	if !strings.Contains(heatmap(nil), `height="16"`) {
+		t.Error("heatmap of no records not empty")
+	}
+}
+
+func TestReadOutcomes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"array", `[{"outcome": "sdc"}, {"outcome": "masked"}, {"outcome": "sdc"}, {"workload": "corpus"}]`},
+		{"concatenated", `{"outcome": "sdc"} {"outcome": "masked"}` + "\n" + `[{"outcome": "sdc"}]`},
+	}
+	want := []Count{{Key: "masked", Records: 1}, {Key: "sdc", Records: 2}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadOutcomes(strings.NewReader(test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+	if _, err := ReadOutcomes(strings.NewReader(`{"outcome": 3}`)); err == nil {
+		t.Error("no error reading a numeric outcome")
+	}
+}
+
+func TestReportRender(t *testing.T) {
+	outcomes := []Count{{Key: "masked", Records: 4}, {Key: "sdc", Records: 1}}
+	tests := []struct {
+		name     string
+		cfg      *config.Config
+		outcomes []Count
+		html     bool
+		want     []string
+		unwanted []string
+	}{
+		{"markdown", configure(), outcomes, false, []string{
+			"# Campaign report: stack & db",
+			"| Error rates | 0.1, 0.5, 0.1, 0.9 |",
+			"| Sites | evm.stack, <db> |",
+			"| Seed | 7 |",
+			"| go-ethereum | v1.10.13 |",
+			"| 1 | 0.5 | 3 | 4 | 2021-11-01 12:00:00.000 | 2021-11-01 12:00:03.000 | 3s |",
+			"| 3 | 0.9 | 0 | 0 | - | - | 0s |",
+			"| evm.stack | 4 | 80.0% | 4 |",
+			"### <db>",
+			"![Bit position heatmap](data:image/svg+xml;base64,",
+			"| sdc | 1 |",
+		}, []string{"<svg"}},
+		{"markdown without config", nil, nil, false, []string{
+			"# Campaign report\n",
+			"No configuration was given",
+			"| Seed | unknown |",
+			"No run outcomes were given.",
+		}, nil},
+		{"html", configure(), outcomes, true, []string{
+			"<title>Campaign report: stack &amp; db</title>",
+			"<tr><th>Sites</th><td>evm.stack, &lt;db&gt;</td></tr>",
+			"<tr><th>Seed</th><td>7</td></tr>",
+			"<h3>&lt;db&gt;</h3>",
+			`<svg xmlns="http://www.w3.org/2000/svg"`,
+			"<tr><td>sdc</td><td>1</td></tr>",
+		}, []string{"<db>", "data:image"}},
+		{"html without config", nil, nil, true, []string{
+			"<title>Campaign report</title>",
+			"<p>No configuration was given",
+			"<tr><th>Seed</th><td>unknown</td></tr>",
+			"<p>No run outcomes were given.</p>",
+		}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := NewReport(campaign, test.cfg, test.outcomes)
+			var (
+				b   strings.Builder
+				err error
+			)
+			if test.html {
+				err = report.WriteHTML(&b)
+			} else {
+				err = report.WriteMarkdown(&b)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("report has no %q", want)
+				}
+			}
+			for _, unwanted := range test.unwanted {
+				if strings.Contains(b.String(), unwanted) {
+					t.Errorf("report has %q", unwanted)
+				}
+			}
+			// Nothing is fetched to render the report
+			if strings.Contains(strings.Replace(b.String(), "http://www.w3.org/2000/svg", "", -1), "http") {
+				t.Error("report links to another resource")
+			}
+		})
+	}
+}
+
+// The Markdown report embeds the heatmap itself.
+func TestReportMarkdownHeatmap(t *testing.T) {
+	report := NewReport(campaign, nil, nil)
+	var b strings.Builder
+	if err := report.WriteMarkdown(&b); err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "(data:image/svg+xml;base64,"
+	i := strings.Index(b.String(), prefix)
+	if i < 0 {
+		t.Fatal("no heatmap in the report")
+	}
+	encoded := b.String()[i+len(prefix):]
+	svg, err := base64.StdEncoding.DecodeString(encoded[:strings.Index(encoded, ")")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(svg) != report.Heatmap {
+		t.Error("embedded heatmap differs from the report's")
+	}
+}
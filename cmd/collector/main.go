@@ -0,0 +1,122 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// collector is a results server for soft error campaigns. It stores the
+// records posted by the injection package and serves queries over them.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/griffindavis02/eth-bit-flip/collector"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	addrFlag = cli.StringFlag{
+		Name:  "addr",
+		Usage: "Listening address",
+		Value: "localhost:5000",
+	}
+	dataDirFlag = cli.StringFlag{
+		Name:  "datadir",
+		Usage: "Directory of the record database",
+		Value: filepath.Join(os.Getenv("HOME"), ".flipconfig", "collector"),
+	}
+	authFlag = cli.StringFlag{
+		Name:  "auth",
+		Usage: "Authentication required of posts and queries: bearer or hmac",
+	}
+	secretEnvFlag = cli.StringFlag{
+		Name:  "secret.env",
+		Usage: "Environment variable holding the bearer token or HMAC key",
+	}
+	secretFileFlag = cli.StringFlag{
+		Name:  "secret.file",
+		Usage: "File holding the bearer token or HMAC key",
+	}
+	certFlag = cli.StringFlag{
+		Name:  "tls.cert",
+		Usage: "Server certificate, enables TLS",
+	}
+	keyFlag = cli.StringFlag{
+		Name:  "tls.key",
+		Usage: "Server certificate key",
+	}
+	clientCAFlag = cli.StringFlag{
+		Name:  "tls.clientca",
+		Usage: "Certificate authorities whose client certificates are required",
+	}
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "collector"
+	app.Usage = "Store and query the results of soft error simulations"
+	app.Flags = []cli.Flag{addrFlag, dataDirFlag, authFlag, secretEnvFlag, secretFileFlag, certFlag, keyFlag, clientCAFlag}
+	app.Action = run
+	if err := app.Run(os.Args); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+}
+
+func run(ctx *cli.Context) error {
+	store, err := collector.OpenStore(ctx.String(dataDirFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	srv := collector.NewServer(store)
+	if srv.AuthType = ctx.String(authFlag.Name); srv.AuthType != "" {
+		auth := config.AuthConfig{
+			Type:       srv.AuthType,
+			SecretEnv:  ctx.String(secretEnvFlag.Name),
+			SecretFile: ctx.String(secretFileFlag.Name),
+		}
+		if srv.Secret, err = auth.ResolveSecret(); err != nil {
+			return err
+		}
+	}
+	server := &http.Server{Addr: ctx.String(addrFlag.Name), Handler: srv}
+
+	if ca := ctx.String(clientCAFlag.Name); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return fmt.Errorf("error reading in CA file from %s", ca)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", ca)
+		}
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+	log.Printf("Collecting records on %s into %s", server.Addr, ctx.String(dataDirFlag.Name))
+	if cert := ctx.String(certFlag.Name); cert != "" {
+		return server.ListenAndServeTLS(cert, ctx.String(keyFlag.Name))
+	}
+	if server.TLSConfig != nil {
+		return fmt.Errorf("--%s requires --%s", clientCAFlag.Name, certFlag.Name)
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package codec corrupts values in their RLP encoding and decodes them again,
+// measuring how much of a fault the structure of RLP catches.
+package codec
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the typed helpers, to be named in the configured sites.
+const (
+	BlockSite       = "rlp.block"
+	HeaderSite      = "rlp.header"
+	TransactionSite = "rlp.transaction"
+	ReceiptSite     = "rlp.receipt"
+)
+
+// Outcome classifies a round trip through a corrupted encoding.
+type Outcome string
+
+const (
+	// Unchanged round trips decode to the value encoded.
+	Unchanged Outcome = "unchanged"
+	// Changed round trips decode to a different value.
+	Changed Outcome = "changed"
+	// Rejected round trips fail to decode.
+	Rejected Outcome = "rejected"
+)
+
+// Record is the outcome of a round trip. Bits lists the bits of the encoding
+// the fault changed, counted from the most significant bit of its first byte;
+// a round trip without any is unchanged.
+type Record struct {
+	Site    string  `json:"site"`
+	Size    int     `json:"size"`
+	Bits    []int   `json:"bits,omitempty"`
+	Outcome Outcome `json:"outcome"`
+	Err     string  `json:"error,omitempty"`
+}
+
+// Corrupted reports whether the fault changed the encoding.
+func (r *Record) Corrupted() bool {
+	return len(r.Bits) > 0
+}
+
+// RoundTrip encodes value, runs the encoding through BitFlip at site and
+// decodes it into out, a pointer. The decoded value is compared with value by
+// its encoding. Every flip carries the size of the encoding in its context.
+// An error is only returned if value cannot be encoded.
+func RoundTrip(site string, value, out interface{}) (*Record, error) {
+	enc, err := rlp.EncodeToBytes(value)
+	if err != nil {
+		return nil, err
+	}
+	corrupted := injection.BitFlip(common.CopyBytes(enc), site, injection.Context{"size": len(enc)}).([]byte)
+
+	r := &Record{Site: site, Size: len(enc), Bits: changedBits(enc, corrupted)}
+	if err := rlp.DecodeBytes(corrupted, out); err != nil {
+		r.Outcome, r.Err = Rejected, err.Error()
+		return r, nil
+	}
+	if dec, err := rlp.EncodeToBytes(out); err == nil && bytes.Equal(dec, enc) {
+		r.Outcome = Unchanged
+	} else {
+		r.Outcome = Changed
+	}
+	return r, nil
+}
+
+// Block round trips block, returning the decoded block unless it was
+// rejected.
+func Block(block *types.Block) (*types.Block, *Record, error) {
+	out := new(types.Block)
+	r, err := RoundTrip(BlockSite, block, out)
+	if err != nil || r.Outcome == Rejected {
+		return nil, r, err
+	}
+	return out, r, nil
+}
+
+// Header round trips header, returning the decoded header unless it was
+// rejected.
+func Header(header *types.Header) (*types.Header, *Record, error) {
+	out := new(types.Header)
+	r, err := RoundTrip(HeaderSite, header, out)
+	if err != nil || r.Outcome == Rejected {
+		return nil, r, err
+	}
+	return out, r, nil
+}
+
+// Transaction round trips tx in its network encoding, returning the decoded
+// transaction unless it was rejected.
+func Transaction(tx *types.Transaction) (*types.Transaction, *Record, error) {
+	out := new(types.Transaction)
+	r, err := RoundTrip(TransactionSite, tx, out)
+	if err != nil || r.Outcome == Rejected {
+		return nil, r, err
+	}
+	return out, r, nil
+}
+
+// Receipt round trips the consensus fields of receipt, returning the decoded
+// receipt unless it was rejected.
+func Receipt(receipt *types.Receipt) (*types.Receipt, *Record, error) {
+	out := new(types.Receipt)
+	r, err := RoundTrip(ReceiptSite, receipt, out)
+	if err != nil || r.Outcome == Rejected {
+		return nil, r, err
+	}
+	return out, r, nil
+}
+
+// changedBits lists the bits in which a and b, of equal length, differ.
+func changedBits(a, b []byte) []int {
+	var bits []int
+	for i := range a {
+		for x, j := a[i]^b[i], 0; x != 0; x, j = x<<1, j+1 {
+			if x&0x80 != 0 {
+				bits = append(bits, i*8+j)
+			}
+		}
+	}
+	return bits
+}
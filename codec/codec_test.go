@@ -0,0 +1,147 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package codec
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+const site = "rlp.test"
+
+// inject writes a configuration inverting every bit at sites and returns a
+// sink recording the flips.
+func inject(t *testing.T, sites ...string) *injection.RingSink {
+	t.Helper()
+	configtest.New(t, 1, sites...)
+	ring := injection.NewRingSink(16)
+	injection.AddSink(ring)
+	t.Cleanup(func() { injection.RemoveSink(ring) })
+	return ring
+}
+
+// Inverting every bit of a single byte encoding classifies the round trip by
+// what the inverted byte encodes: 0x7f becomes 0x80, the empty string, which
+// decodes to 0; 0x05 becomes 0xfa, a list, which an integer cannot decode.
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		site    string
+		value   uint64
+		want    uint64
+		bits    []int
+		outcome Outcome
+	}{
+		{"uncorrupted", "rlp.other", 0x7f, 0x7f, nil, Unchanged},
+		{"changed", site, 0x7f, 0, []int{0, 1, 2, 3, 4, 5, 6, 7}, Changed},
+		{"rejected", site, 0x05, 0, []int{0, 1, 2, 3, 4, 5, 6, 7}, Rejected},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring := inject(t, site)
+			var out uint64
+			r, err := RoundTrip(test.site, test.value, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Site != test.site || r.Size != 1 || !reflect.DeepEqual(r.Bits, test.bits) || r.Outcome != test.outcome {
+				t.Errorf("got %+v, want a 1 byte %s round trip at %s changing bits %v", r, test.outcome, test.site, test.bits)
+			}
+			if (r.Err != "") != (test.outcome == Rejected) {
+				t.Errorf("%s round trip with error %q", r.Outcome, r.Err)
+			}
+			if r.Corrupted() != (test.bits != nil) {
+				t.Errorf("corrupted %v with bits %v", r.Corrupted(), r.Bits)
+			}
+			if test.outcome != Rejected && out != test.want {
+				t.Errorf("decoded %#x, want %#x", out, test.want)
+			}
+
+			records := ring.Records()
+			if r.Corrupted() && (len(records) != 1 || records[0].ErrorData.Context.String() != injection.Context{"size": 1}.String()) {
+				t.Errorf("flips %+v, want one of an encoding of size 1", records)
+			}
+		})
+	}
+}
+
+// The typed helpers only return what was decoded.
+func TestHeaderRejected(t *testing.T) {
+	inject(t, HeaderSite)
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	out, r, err := Header(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Outcome != Rejected || out != nil {
+		t.Errorf("header %v with outcome %s, want a rejected round trip", out, r.Outcome)
+	}
+}
+
+func TestHeaderUncorrupted(t *testing.T) {
+	inject(t, site)
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+	out, r, err := Header(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Outcome != Unchanged || r.Corrupted() || out == nil || out.Hash() != header.Hash() {
+		t.Errorf("header %v with outcome %s and %d bits changed, want the header unchanged", out, r.Outcome, len(r.Bits))
+	}
+}
+
+func TestChangedBits(t *testing.T) {
+	tests := []struct {
+		a, b []byte
+		want []int
+	}{
+		{[]byte{0x00}, []byte{0x00}, nil},
+		{[]byte{0x00}, []byte{0x80}, []int{0}},
+		{[]byte{0x00}, []byte{0x01}, []int{7}},
+		{[]byte{0x00, 0x00}, []byte{0x41, 0x02}, []int{1, 7, 14}},
+	}
+	for _, test := range tests {
+		if got := changedBits(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("changedBits(%x, %x) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// Only the corrupted round trips count towards the outcomes and the fraction
+// caught.
+func TestSummarize(t *testing.T) {
+	records := []*Record{
+		{Site: HeaderSite, Outcome: Unchanged},
+		{Site: BlockSite, Bits: []int{3}, Outcome: Rejected},
+		{Site: HeaderSite, Bits: []int{1}, Outcome: Rejected},
+		{Site: HeaderSite, Bits: []int{2}, Outcome: Changed},
+		{Site: HeaderSite, Bits: []int{4}, Outcome: Rejected},
+		{Site: HeaderSite, Bits: []int{5}, Outcome: Unchanged},
+	}
+	want := []*Summary{
+		{Site: HeaderSite, RoundTrips: 5, Corrupted: 4, Outcomes: map[Outcome]int{Rejected: 2, Changed: 1, Unchanged: 1}, Caught: 0.5},
+		{Site: BlockSite, RoundTrips: 1, Corrupted: 1, Outcomes: map[Outcome]int{Rejected: 1}, Caught: 1},
+	}
+	if got := Summarize(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package codec
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Summary counts the outcomes of the round trips at a site. Caught is the
+// fraction of the corrupted round trips that were rejected.
+type Summary struct {
+	Site       string          `json:"site"`
+	RoundTrips int             `json:"roundTrips"`
+	Corrupted  int             `json:"corrupted"`
+	Outcomes   map[Outcome]int `json:"outcomes"` // of the corrupted round trips
+	Caught     float64         `json:"caught"`
+}
+
+// Summarize summarizes records by site, in the order the sites first appear.
+func Summarize(records []*Record) []*Summary {
+	var (
+		summaries []*Summary
+		bySite    = make(map[string]*Summary)
+	)
+	for _, r := range records {
+		s := bySite[r.Site]
+		if s == nil {
+			s = &Summary{Site: r.Site, Outcomes: make(map[Outcome]int)}
+			bySite[r.Site] = s
+			summaries = append(summaries, s)
+		}
+		s.RoundTrips++
+		if r.Corrupted() {
+			s.Corrupted++
+			s.Outcomes[r.Outcome]++
+		}
+	}
+	for _, s := range summaries {
+		if s.Corrupted > 0 {
+			s.Caught = float64(s.Outcomes[Rejected]) / float64(s.Corrupted)
+		}
+	}
+	return summaries
+}
+
+// WriteTable writes summaries as a table.
+func WriteTable(w io.Writer, summaries []*Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Site\tRound trips\tCorrupted\tunchanged\tchanged\trejected\tCaught\t")
+	for _, s := range summaries {
+		caught := "-"
+		if s.Corrupted > 0 {
+			caught = fmt.Sprintf("%.3f", s.Caught)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t\n", s.Site, s.RoundTrips, s.Corrupted,
+			s.Outcomes[Unchanged], s.Outcomes[Changed], s.Outcomes[Rejected], caught)
+	}
+	return tw.Flush()
+}
+
+var (
+	blocksFlag = cli.IntFlag{
+		Name:  "blocks",
+		Usage: "Number of blocks in the chain to round trip",
+		Value: 16,
+	}
+	runsFlag = cli.IntFlag{
+		Name:  "runs",
+		Usage: "Number of times to round trip the chain",
+		Value: 10,
+	}
+	configFlag = cli.StringFlag{
+		Name:  "config",
+		Usage: "Configuration to inject with (default: the current configuration)",
+	}
+	recordsFlag = cli.StringFlag{
+		Name:  "records",
+		Usage: "File to write every round trip to as JSON lines",
+	}
+	formatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: table or json",
+		Value: "table",
+	}
+
+	// RLPCommand is the 'flipcfg rlp' subcommand.
+	RLPCommand = cli.Command{
+		Action: roundTrip,
+		Name:   "rlp",
+		Usage:  "Round trip a chain through corrupted RLP encodings",
+		Flags:  []cli.Flag{blocksFlag, runsFlag, configFlag, recordsFlag, formatFlag},
+		Description: `
+Generates the chain of 'flipcfg compare --workload chain' and round trips every
+block, header, transaction and receipt of it through RLP --runs times with the
+configuration: each value is encoded, the encoding is run through BitFlip at
+the rlp.block, rlp.header, rlp.transaction or rlp.receipt site, and decoded
+again. A corrupted round trip is unchanged, changed if it decoded to a
+different value, or rejected if it failed to decode.
+
+The outcomes are summarized per site, with the fraction of corrupted round
+trips RLP caught by rejecting them.`,
+	}
+)
+
+func roundTrip(ctx *cli.Context) error {
+	exp, err := harness.NewExperiment(ctx, configFlag.Name, recordsFlag.Name, formatFlag.Name)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	var (
+		chain   *harness.ChainWorkload
+		records []*Record
+	)
+	build := func() error {
+		chain = harness.NewChainWorkload(ctx.Int(blocksFlag.Name))
+		return nil
+	}
+	inject := func(emit func(interface{}) error) error {
+		keep := func(_ interface{}, r *Record, err error) error {
+			if err != nil {
+				return err
+			}
+			records = append(records, r)
+			return emit(r)
+		}
+		for run := 0; run < ctx.Int(runsFlag.Name); run++ {
+			for i, block := range chain.Blocks() {
+				if err := keep(Block(block)); err != nil {
+					return err
+				}
+				if err := keep(Header(block.Header())); err != nil {
+					return err
+				}
+				for _, tx := range block.Transactions() {
+					if err := keep(Transaction(tx)); err != nil {
+						return err
+					}
+				}
+				for _, receipt := range chain.Receipts()[i] {
+					if err := keep(Receipt(receipt)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := exp.Run(build, inject); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	summaries := Summarize(records)
+	return exp.Write(summaries, func(w io.Writer) error { return WriteTable(w, summaries) })
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package collector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+const (
+	// maxBodyBytes bounds a posted batch after decompression.
+	maxBodyBytes = 64 * 1024 * 1024
+
+	// maxSkew is how far an HMAC timestamp may be from the collector's clock.
+	maxSkew = 5 * time.Minute
+)
+
+// Server accepts iteration records and answers queries about them.
+//
+//	POST /             a record or a JSON array of records, optionally gzipped
+//	GET  /records      stored records; filter with campaign, site, rate, from,
+//	                   to (RFC 3339 or unix seconds) and limit
+//	GET  /campaigns    campaign summaries
+//
+// AuthType "bearer" or "hmac" requires requests to be authenticated the same
+// way the injection package's http sink signs its posts. The HMAC signature of
+// a query covers its request URI, path and query string, in place of a body.
+type Server struct {
+	Store    *Store
+	AuthType string
+	Secret   string
+}
+
+// NewServer creates a server storing records in store.
+func NewServer(store *Store) *Server {
+	return &Server{Store: store}
+}
+
+// ServeHTTP routes a request to the matching endpoint.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodPost:
+		srv.receive(w, r)
+	case r.URL.Path == "/records" && r.Method == http.MethodGet:
+		srv.records(w, r)
+	case r.URL.Path == "/campaigns" && r.Method == http.MethodGet:
+		srv.campaigns(w, r)
+	case r.URL.Path == "/" || r.URL.Path == "/records" || r.URL.Path == "/campaigns":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (srv *Server) receive(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := srv.authenticate(r, body); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body, err = io.ReadAll(io.LimitReader(zr, maxBodyBytes+1)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(body) > maxBodyBytes {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	raws, err := splitRecords(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	received := time.Now()
+	records := make([]Record, 0, len(raws))
+	for i, raw := range raws {
+		rec, err := Validate(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("record %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		rec.Received = received
+		records = append(records, rec)
+	}
+	if err := srv.Store.Put(records); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]int{"stored": len(records)})
+}
+
+func (srv *Server) records(w http.ResponseWriter, r *http.Request) {
+	if err := srv.authenticate(r, []byte(r.URL.RequestURI())); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := srv.Store.Query(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, records)
+}
+
+func (srv *Server) campaigns(w http.ResponseWriter, r *http.Request) {
+	if err := srv.authenticate(r, []byte(r.URL.RequestURI())); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	campaigns, err := srv.Store.Campaigns()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, campaigns)
+}
+
+// authenticate checks the bearer token or HMAC signature of a request, signed
+// over body.
+func (srv *Server) authenticate(r *http.Request, body []byte) error {
+	switch srv.AuthType {
+	case "":
+		return nil
+	case "bearer":
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(srv.Secret)) != 1 {
+			return fmt.Errorf("invalid bearer token")
+		}
+		return nil
+	case "hmac":
+		timestamp := r.Header.Get(injection.TimestampHeader)
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("missing or invalid %s header", injection.TimestampHeader)
+		}
+		if skew := time.Since(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+			return fmt.Errorf("request timestamp outside of the accepted window")
+		}
+		want := "sha256=" + injection.Signature([]byte(srv.Secret), timestamp, body)
+		if !hmac.Equal([]byte(r.Header.Get(injection.SignatureHeader)), []byte(want)) {
+			return fmt.Errorf("invalid request signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown authentication type \"%s\"", srv.AuthType)
+}
+
+// splitRecords accepts a single record or an array of them.
+func splitRecords(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, fmt.Errorf("error unmarshaling records: %v", err)
+		}
+		return raws, nil
+	}
+	return []json.RawMessage{body}, nil
+}
+
+func parseFilter(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+	f := Filter{Campaign: query.Get("campaign"), Site: query.Get("site")}
+	var err error
+	if rate := query.Get("rate"); rate != "" {
+		if f.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return f, fmt.Errorf("invalid rate \"%s\"", rate)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if f.Limit, err = strconv.Atoi(limit); err != nil {
+			return f, fmt.Errorf("invalid limit \"%s\"", limit)
+		}
+	}
+	if f.From, err = parseTime(query.Get("from")); err != nil {
+		return f, err
+	}
+	if f.To, err = parseTime(query.Get("to")); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time \"%s\"", value)
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// record encodes an iteration of campaign at site, flipped when.
+func record(t *testing.T, campaign, site, when string) []byte {
+	t.Helper()
+	iter := injection.Iteration{
+		Campaign:     campaign,
+		TestType:     "variable",
+		Rate:         0.5,
+		IterationNum: 1,
+		ErrorData: injection.ErrorData{
+			PreviousValue: uint64(1),
+			PreviousByte:  "0x01",
+			IntBits:       []int{1},
+			ErrorValue:    uint64(3),
+			ErrorByte:     "0x03",
+			DeltaValue:    2,
+			When:          when,
+			Msg:           site,
+		},
+	}
+	data, err := json.Marshal(iter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// signed returns a request carrying an HMAC signature of payload.
+func signed(t *testing.T, method, url string, body, payload []byte, secret string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(injection.TimestampHeader, timestamp)
+	req.Header.Set(injection.SignatureHeader, "sha256="+injection.Signature([]byte(secret), timestamp, payload))
+	return req
+}
+
+func do(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func TestQueryHMAC(t *testing.T) {
+	const secret = "lab secret"
+	srv := NewServer(NewMemoryStore())
+	srv.AuthType, srv.Secret = "hmac", secret
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	body := record(t, "lab", "evm.stack", time.Now().Format(injection.TimeFormat))
+	if res := do(t, signed(t, http.MethodPost, server.URL, body, body, secret)); res.StatusCode != http.StatusOK {
+		t.Fatalf("signed post: status %d", res.StatusCode)
+	}
+
+	for _, uri := range []string{"/records?campaign=lab", "/campaigns"} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+uri, nil)
+		if res := do(t, req); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("unsigned GET %s: status %d, want 401", uri, res.StatusCode)
+		}
+		if res := do(t, signed(t, http.MethodGet, server.URL+uri, nil, []byte("/records?campaign=other"), secret)); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("GET %s signed for another query: status %d, want 401", uri, res.StatusCode)
+		}
+		res := do(t, signed(t, http.MethodGet, server.URL+uri, nil, []byte(uri), secret))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("signed GET %s: status %d", uri, res.StatusCode)
+		}
+		var results []json.RawMessage
+		if err := json.NewDecoder(res.Body).Decode(&results); err != nil || len(results) != 1 {
+			t.Errorf("GET %s: %d results (%v), want 1", uri, len(results), err)
+		}
+	}
+}
+
+func TestQueryBearer(t *testing.T) {
+	srv := NewServer(NewMemoryStore())
+	srv.AuthType, srv.Secret = "bearer", "lab token"
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/campaigns", nil)
+	if res := do(t, req); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET without a token: status %d, want 401", res.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/campaigns", nil)
+	req.Header.Set("Authorization", "Bearer lab token")
+	if res := do(t, req); res.StatusCode != http.StatusOK {
+		t.Errorf("GET with the token: status %d, want 200", res.StatusCode)
+	}
+}
+
+// The time of a record is that of the injecting process, whatever the time
+// zone of the collector.
+func TestValidateWhen(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("collector", -5*3600)
+
+	rec, err := Validate(record(t, "lab", "evm.stack", "10-19-2026 10:00:00.000000000 +0200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC); !rec.When.Equal(want) || rec.When.Location() != time.UTC {
+		t.Errorf("when %v, want %v", rec.When, want)
+	}
+
+	// Records without an offset predate it and are read in local time
+	rec, err = Validate(record(t, "lab", "evm.stack", "10-19-2026 10:00:00.000000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC); !rec.When.Equal(want) {
+		t.Errorf("when %v, want %v", rec.When, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := record(t, "", "evm.stack", time.Now().Format(injection.TimeFormat))
+	rec, err := Validate(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Campaign != DefaultCampaign || rec.Site != "evm.stack" || rec.Rate != 0.5 {
+		t.Errorf("record %+v", rec)
+	}
+	for name, data := range map[string][]byte{
+		"malformed": []byte("{"),
+		"rate":      bytes.Replace(valid, []byte(`"Rate":0.5`), []byte(`"Rate":2`), 1),
+		"time":      record(t, "lab", "evm.stack", "yesterday"),
+		"hex":       bytes.Replace(valid, []byte(`"0x01"`), []byte(`"01"`), 1),
+		"bits":      bytes.Replace(valid, []byte(`"IntBits":[1]`), []byte(`"IntBits":[]`), 1),
+	} {
+		if _, err := Validate(data); err == nil {
+			t.Errorf("%s record accepted", name)
+		}
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	store := NewMemoryStore()
+	base := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	var records []Record
+	for i, site := range []string{"a", "b", "a"} {
+		rec, err := Validate(record(t, "lab", site, base.Add(time.Duration(i)*time.Minute).Format(injection.TimeFormat)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if err := store.Put(records); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		f    Filter
+		want int
+	}{
+		"all":      {Filter{}, 3},
+		"campaign": {Filter{Campaign: "lab"}, 3},
+		"other":    {Filter{Campaign: "other"}, 0},
+		"site":     {Filter{Site: "a"}, 2},
+		"from":     {Filter{Campaign: "lab", From: base.Add(time.Minute)}, 2},
+		"to":       {Filter{Campaign: "lab", To: base.Add(time.Minute)}, 2},
+		"limit":    {Filter{Limit: 1}, 1},
+	} {
+		got, err := store.Query(tt.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%s: %d records, want %d", name, len(got), tt.want)
+		}
+	}
+	campaigns, err := store.Campaigns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(campaigns) != 1 || campaigns[0].Records != 3 || campaigns[0].Sites["a"] != 2 {
+		t.Errorf("campaigns %+v", campaigns)
+	}
+}
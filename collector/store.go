@@ -0,0 +1,314 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package collector receives, stores and serves the iteration records posted
+// by the injection package's http sink.
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// DefaultCampaign files records that carry no campaign name.
+const DefaultCampaign = "default"
+
+// Database layout:
+//
+//	r<campaign>\x00<when, 8 byte big endian unix nanos><sequence, 8 bytes> -> record JSON
+//	c<campaign> -> campaign summary JSON
+//	s -> last sequence number
+var (
+	recordPrefix   = []byte("r")
+	campaignPrefix = []byte("c")
+	sequenceKey    = []byte("s")
+)
+
+// Record is a stored iteration. The original JSON is kept so large values
+// survive unchanged; the decoded fields are used for filtering.
+type Record struct {
+	Campaign string          `json:"campaign"`
+	Site     string          `json:"site"`
+	Rate     float64         `json:"rate"`
+	When     time.Time       `json:"when"`
+	Received time.Time       `json:"received"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Campaign summarises the records stored for a campaign.
+type Campaign struct {
+	Name    string         `json:"name"`
+	Records int            `json:"records"`
+	First   time.Time      `json:"first"`
+	Last    time.Time      `json:"last"`
+	Sites   map[string]int `json:"sites"`
+	Rates   []float64      `json:"rates"`
+}
+
+// Filter selects records. Zero fields match everything.
+type Filter struct {
+	Campaign string
+	Site     string
+	Rate     float64
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+// Store keeps records in a key-value database.
+type Store struct {
+	db  ethdb.KeyValueStore
+	mu  sync.Mutex // serialises writes to the sequence and summaries
+	seq uint64
+}
+
+// OpenStore opens, or creates, a LevelDB backed store in dir.
+func OpenStore(dir string) (*Store, error) {
+	db, err := leveldb.New(dir, 16, 16, "flip/collector/", false)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database in %s: %v", dir, err)
+	}
+	return NewStore(db)
+}
+
+// NewMemoryStore creates a store that is lost on exit, for testing.
+func NewMemoryStore() *Store {
+	store, _ := NewStore(memorydb.New())
+	return store
+}
+
+// NewStore creates a store on top of db.
+func NewStore(db ethdb.KeyValueStore) (*Store, error) {
+	store := &Store{db: db}
+	if enc, err := db.Get(sequenceKey); err == nil && len(enc) == 8 {
+		store.seq = binary.BigEndian.Uint64(enc)
+	}
+	return store, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Validate decodes and checks a posted iteration record.
+func Validate(data []byte) (Record, error) {
+	var iter injection.Iteration
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&iter); err != nil {
+		return Record{}, fmt.Errorf("error unmarshaling record: %v", err)
+	}
+	if iter.Rate <= 0 || iter.Rate > 1 {
+		return Record{}, fmt.Errorf("rate %g outside of (0, 1]", iter.Rate)
+	}
+	if iter.IterationNum < 0 {
+		return Record{}, fmt.Errorf("negative iteration number %d", iter.IterationNum)
+	}
+	when, err := injection.ParseWhen(iter.ErrorData.When)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid time \"%s\"", iter.ErrorData.When)
+	}
+	for _, field := range []string{iter.ErrorData.PreviousByte, iter.ErrorData.ErrorByte} {
+		if _, err := hex.DecodeString(strings.TrimPrefix(field, "0x")); err != nil || !strings.HasPrefix(field, "0x") {
+			return Record{}, fmt.Errorf("invalid hex value \"%s\"", field)
+		}
+	}
+	if len(iter.ErrorData.IntBits) == 0 {
+		return Record{}, fmt.Errorf("record flips no bits")
+	}
+	campaign := iter.Campaign
+	if campaign == "" {
+		campaign = DefaultCampaign
+	}
+	if strings.ContainsRune(campaign, 0) {
+		return Record{}, fmt.Errorf("invalid campaign name")
+	}
+	return Record{
+		Campaign: campaign,
+		Site:     iter.ErrorData.Msg,
+		Rate:     iter.Rate,
+		When:     when.UTC(),
+		Data:     append(json.RawMessage{}, data...),
+	}, nil
+}
+
+// Put stores records atomically.
+func (s *Store) Put(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := s.db.NewBatch()
+	campaigns := make(map[string]*Campaign)
+	seq := s.seq
+	for _, rec := range records {
+		seq++
+		enc, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		batch.Put(recordKey(rec.Campaign, rec.When, seq), enc)
+
+		summary, ok := campaigns[rec.Campaign]
+		if !ok {
+			if summary, err = s.campaign(rec.Campaign); err != nil {
+				return err
+			}
+			campaigns[rec.Campaign] = summary
+		}
+		summary.add(rec)
+	}
+	for name, summary := range campaigns {
+		enc, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+		batch.Put(append(append([]byte{}, campaignPrefix...), name...), enc)
+	}
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, seq)
+	batch.Put(sequenceKey, enc)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.seq = seq
+	return nil
+}
+
+// Query returns the records matching f in time order within each campaign.
+func (s *Store) Query(f Filter) ([]Record, error) {
+	prefix := recordPrefix
+	var start []byte
+	if f.Campaign != "" {
+		prefix = append(append(append([]byte{}, recordPrefix...), f.Campaign...), 0)
+		if !f.From.IsZero() {
+			start = make([]byte, 8)
+			binary.BigEndian.PutUint64(start, uint64(f.From.UnixNano()))
+		}
+	}
+	it := s.db.NewIterator(prefix, start)
+	defer it.Release()
+
+	records := []Record{}
+	for it.Next() {
+		var rec Record
+		if err := json.Unmarshal(it.Value(), &rec); err != nil {
+			return nil, err
+		}
+		if f.Campaign != "" && !f.To.IsZero() && rec.When.After(f.To) {
+			break
+		}
+		if !f.match(rec) {
+			continue
+		}
+		records = append(records, rec)
+		if f.Limit > 0 && len(records) >= f.Limit {
+			break
+		}
+	}
+	return records, it.Error()
+}
+
+// Campaigns lists the campaigns with stored records.
+func (s *Store) Campaigns() ([]Campaign, error) {
+	it := s.db.NewIterator(campaignPrefix, nil)
+	defer it.Release()
+
+	campaigns := []Campaign{}
+	for it.Next() {
+		var summary Campaign
+		if err := json.Unmarshal(it.Value(), &summary); err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, summary)
+	}
+	return campaigns, it.Error()
+}
+
+func (s *Store) campaign(name string) (*Campaign, error) {
+	summary := &Campaign{Name: name, Sites: make(map[string]int)}
+	key := append(append([]byte{}, campaignPrefix...), name...)
+	if has, err := s.db.Has(key); err != nil || !has {
+		return summary, err
+	}
+	enc, err := s.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(enc, summary); err != nil {
+		return nil, err
+	}
+	if summary.Sites == nil {
+		summary.Sites = make(map[string]int)
+	}
+	return summary, nil
+}
+
+func (c *Campaign) add(rec Record) {
+	c.Records++
+	if c.First.IsZero() || rec.When.Before(c.First) {
+		c.First = rec.When
+	}
+	if rec.When.After(c.Last) {
+		c.Last = rec.When
+	}
+	c.Sites[rec.Site]++
+	for _, rate := range c.Rates {
+		if rate == rec.Rate {
+			return
+		}
+	}
+	c.Rates = append(c.Rates, rec.Rate)
+}
+
+func (f Filter) match(rec Record) bool {
+	if f.Campaign != "" && rec.Campaign != f.Campaign {
+		return false
+	}
+	if f.Site != "" && rec.Site != f.Site {
+		return false
+	}
+	if f.Rate != 0 && rec.Rate != f.Rate {
+		return false
+	}
+	if !f.From.IsZero() && rec.When.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && rec.When.After(f.To) {
+		return false
+	}
+	return true
+}
+
+func recordKey(campaign string, when time.Time, seq uint64) []byte {
+	key := make([]byte, len(recordPrefix)+len(campaign)+17)
+	n := copy(key, recordPrefix)
+	n += copy(key[n:], campaign) + 1
+	binary.BigEndian.PutUint64(key[n:], uint64(when.UnixNano()))
+	binary.BigEndian.PutUint64(key[n+8:], seq)
+	return key
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of MERCHANTABILITY
+// or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+// details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package configtest gives the tests of other packages a flip configuration
+// of their own, so they neither read nor overwrite the one a campaign runs
+// with.
+package configtest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// New points the configuration at a file in a temporary directory of the
+// test, restoring the previous path when the test ends, and writes it a
+// started campaign flipping each bit of the values at sites, or anywhere
+// without sites, with probability rate. Flips are seeded and recorded to a
+// memory sink. The configuration is returned for the test to adjust and
+// write again.
+func New(t testing.TB, rate float64, sites ...string) config.Config {
+	t.Helper()
+	previous := config.Path()
+	t.Cleanup(func() { config.SetPath(previous) })
+	config.SetPath(filepath.Join(t.TempDir(), "flipconfig.json"))
+
+	cfg := config.DefaultConfig
+	cfg.Initialized = true
+	cfg.Start = true
+	cfg.Seed = 1
+	if len(sites) > 0 {
+		cfg.Sites = sites
+	}
+	cfg.Sinks = []config.SinkConfig{{Type: "memory"}}
+	cfg.State.TestType = "variable"
+	cfg.State.VariablesChanged = 1 << 30
+	cfg.State.ErrorRates = []float64{rate}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
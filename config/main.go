@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/urfave/cli.v1"
@@ -42,17 +43,181 @@ type state struct {
 	ErrorRates       []float64     `json:"error_rates"`
 }
 
-type server struct {
-	Post bool   `json:"post"`
-	Host string `json:"host"`
+// ServerConfig describes the results server records are posted to. Zero
+// timeouts and backoffs select the injection package defaults. Retries and
+// Backoff apply to asynchronous posting only.
+type ServerConfig struct {
+	Post    bool          `json:"post"`
+	Host    string        `json:"host"`
+	Timeout time.Duration `json:"timeout"`
+	Retries int           `json:"retries"`
+	Backoff time.Duration `json:"backoff"`
+	Async   AsyncConfig   `json:"async"`
+	Auth    AuthConfig    `json:"auth"`
+	TLS     TLSConfig     `json:"tls"`
+}
+
+// AuthConfig authenticates requests to the results server. Type is "",
+// "bearer" or "hmac". The secret, used as the bearer token or HMAC key, is read
+// from the environment variable SecretEnv or the file SecretFile when set, so
+// it need not be stored in the configuration file.
+type AuthConfig struct {
+	Type       string `json:"type"`
+	Secret     string `json:"secret,omitempty"`
+	SecretEnv  string `json:"secret_env,omitempty"`
+	SecretFile string `json:"secret_file,omitempty"`
+}
+
+// TLSConfig secures the connection to the results server. CAFile adds
+// certificate authorities to the system pool, CertFile and KeyFile hold a
+// client certificate and MinVersion is "1.0" through "1.3".
+type TLSConfig struct {
+	CAFile     string `json:"ca_file,omitempty"`
+	CertFile   string `json:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty"`
+	MinVersion string `json:"min_version,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// ResolveSecret returns the secret from, in order of preference, the
+// environment variable, the file or the configuration itself.
+func (auth AuthConfig) ResolveSecret() (string, error) {
+	if auth.SecretEnv != "" {
+		if secret, ok := os.LookupEnv(auth.SecretEnv); ok {
+			return strings.TrimSpace(secret), nil
+		}
+		if auth.SecretFile == "" && auth.Secret == "" {
+			return "", fmt.Errorf("environment variable \"%s\" not set", auth.SecretEnv)
+		}
+	}
+	if auth.SecretFile != "" {
+		bytes, err := os.ReadFile(auth.SecretFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading in secret file from %s", auth.SecretFile)
+		}
+		return strings.TrimSpace(string(bytes)), nil
+	}
+	if auth.Secret == "" {
+		return "", fmt.Errorf("no secret configured for %s authentication", auth.Type)
+	}
+	return auth.Secret, nil
+}
+
+// AsyncConfig moves posting off the injecting goroutine. Records are queued,
+// posted in batches by a background worker and spooled to disk while the
+// server is unreachable. Policy decides what happens when the queue is full:
+// "drop_oldest" (the default), "block" or "sample" (keep one in every
+// SampleEvery records).
+type AsyncConfig struct {
+	Enabled       bool          `json:"enabled"`
+	QueueSize     int           `json:"queue_size"`
+	BatchSize     int           `json:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval"`
+	Gzip          bool          `json:"gzip"`
+	Policy        string        `json:"policy"`
+	SampleEvery   int           `json:"sample_every"`
+	SpoolDir      string        `json:"spool_dir"`
+}
+
+// SinkConfig selects a result sink and its options. Type is one of the sinks
+// built into the injection package (stdout, jsonl, csv, http, memory) or a
+// type registered by the application. An http sink without a server posts to
+// the top-level server.
+type SinkConfig struct {
+	Type     string            `json:"type"`
+	Name     string            `json:"name,omitempty"`
+	Path     string            `json:"path,omitempty"`
+	MaxBytes int64             `json:"max_bytes,omitempty"`
+	MaxFiles int               `json:"max_files,omitempty"`
+	Capacity int               `json:"capacity,omitempty"`
+	Server   *ServerConfig     `json:"server,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+// CrashConfig keeps the most recent Flips flips in the crash file at Path, so
+// they survive the process dying from a panic a flip caused.
+type CrashConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	Flips   int    `json:"flips"`
+}
+
+// EVMConfig restricts the EVM tracer injector to the given opcodes, contract
+// addresses, call depths and program counter ranges. An empty filter matches
+// everything; MaxDepth 0 is unlimited. The outermost call is at depth 1.
+type EVMConfig struct {
+	Opcodes   []string  `json:"opcodes"`
+	Addresses []string  `json:"addresses"`
+	MinDepth  int       `json:"min_depth"`
+	MaxDepth  int       `json:"max_depth"`
+	PCRanges  []PCRange `json:"pc_ranges"`
+}
+
+// DatabaseConfig restricts the key-value database wrapper to keys of the given
+// categories (headers, bodies, receipts, trie, snapshot, code or other) or
+// starting with one of the hex encoded prefixes. With neither, every key is
+// injected into.
+type DatabaseConfig struct {
+	Categories []string `json:"categories"`
+	Prefixes   []string `json:"prefixes"`
+}
+
+// StateDBConfig restricts the StateDB hooks to the given accounts and, for
+// storage, slots, both hex encoded. An empty filter matches everything.
+type StateDBConfig struct {
+	Addresses []string `json:"addresses"`
+	Slots     []string `json:"slots"`
+}
+
+// P2PConfig restricts the p2p message wrapper to the given protocols, by name
+// ("eth") or name and version ("eth/66"), and message codes. An empty filter
+// matches everything.
+type P2PConfig struct {
+	Protocols []string `json:"protocols"`
+	Codes     []uint64 `json:"codes"`
+}
+
+// HashConfig enables the digest hook of a patched go-ethereum and restricts it
+// to hashes computed by callers of the given categories (trie, types, state,
+// consensus, p2p or other). Hashing is hot, so the hook is opt-in even when no
+// sites are configured. With no categories, every digest is injected into.
+type HashConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Categories []string `json:"categories"`
+}
+
+// PCRange is an inclusive range of program counters.
+type PCRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// control configures the HTTP control server. It refuses to start without a
+// token unless unauthenticated is set.
+type control struct {
+	Enabled         bool   `json:"enabled"`
+	Address         string `json:"address"`
+	Token           string `json:"token"`
+	Unauthenticated bool   `json:"unauthenticated"`
 }
 
 type Config struct {
-	Initialized bool   `json:"initialized"`
-	Start       bool   `json:"start"`
-	Restart     bool   `json:"restart"`
-	State       state  `json:"state_variables"`
-	Server      server `json:"server"`
+	Initialized bool           `json:"initialized"`
+	Campaign    string         `json:"campaign"`
+	Seed        int64          `json:"seed"`
+	Start       bool           `json:"start"`
+	Restart     bool           `json:"restart"`
+	Sites       []string       `json:"sites"`
+	State       state          `json:"state_variables"`
+	Server      ServerConfig   `json:"server"`
+	Sinks       []SinkConfig   `json:"sinks"`
+	Control     control        `json:"control"`
+	Crash       CrashConfig    `json:"crash"`
+	EVM         EVMConfig      `json:"evm"`
+	Database    DatabaseConfig `json:"database"`
+	StateDB     StateDBConfig  `json:"statedb"`
+	P2P         P2PConfig      `json:"p2p"`
+	Hash        HashConfig     `json:"hash"`
 }
 
 var (
@@ -74,10 +239,15 @@ var (
 	reader = bufio.NewReader(os.Stdin)
 	file   = filepath.Join(os.Getenv("HOME"), ".flipconfig", "flipconfig.json")
 
+	pathLock sync.RWMutex // guards file
+
 	DefaultConfig = Config{
 		Initialized: false,
+		Campaign:    "",
+		Seed:        0,
 		Start:       false,
 		Restart:     false,
+		Sites:       []string{},
 		State: state{
 			TestType:         "bit",
 			TestCounter:      0,
@@ -88,20 +258,74 @@ var (
 			RateIndex:        0,
 			ErrorRates:       []float64{0.1},
 		},
-		Server: server{
-			Post: false,
-			Host: "http://localhost:5000",
+		Server: ServerConfig{
+			Post:    false,
+			Host:    "http://localhost:5000",
+			Timeout: 10 * time.Second,
+			Retries: 3,
+			Backoff: 500 * time.Millisecond,
+			Async: AsyncConfig{
+				Enabled:       false,
+				QueueSize:     4096,
+				BatchSize:     100,
+				FlushInterval: time.Second,
+				Gzip:          false,
+				Policy:        "drop_oldest",
+				SampleEvery:   10,
+				SpoolDir:      filepath.Join(filepath.Dir(file), "spool"),
+			},
+		},
+		Sinks: []SinkConfig{
+			{Type: "stdout"},
+		},
+		Control: control{
+			Enabled:         false,
+			Address:         "localhost:5050",
+			Token:           "",
+			Unauthenticated: false,
+		},
+		Crash: CrashConfig{
+			Enabled: false,
+			Path:    filepath.Join(filepath.Dir(file), "crash.json"),
+			Flips:   32,
+		},
+		EVM: EVMConfig{
+			Opcodes:   []string{},
+			Addresses: []string{},
+			MinDepth:  0,
+			MaxDepth:  0,
+			PCRanges:  []PCRange{},
+		},
+		Database: DatabaseConfig{
+			Categories: []string{},
+			Prefixes:   []string{},
+		},
+		StateDB: StateDBConfig{
+			Addresses: []string{},
+			Slots:     []string{},
+		},
+		P2P: P2PConfig{
+			Protocols: []string{},
+			Codes:     []uint64{},
+		},
+		Hash: HashConfig{
+			Enabled:    false,
+			Categories: []string{},
 		},
 	}
 )
 
-func RunConfig() {
+// RunConfig runs the flipcfg tool. Without a subcommand it starts the
+// configuration wizard; commands adds the subcommands provided by other
+// packages.
+func RunConfig(commands ...cli.Command) {
 	app := cli.NewApp()
 	app.Name = "flipcfg"
 	app.Usage = "Set up a soft error test environment for go-ethereum"
 	app.Flags = []cli.Flag{
 		// TODO: Add flags from utils
 	}
+	app.Commands = commands
 	app.Action = flipWizard
 	app.Run(os.Args)
 }
@@ -122,7 +346,7 @@ func flipWizard(ctx *cli.Context) {
 	fmt.Println(" ----------------------------------------------------------- ")
 	fmt.Println()
 
-	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+	if _, err := os.Stat(Path()); errors.Is(err, os.ErrNotExist) {
 		cfg.newWizard()
 	} else {
 		for {
@@ -205,7 +429,25 @@ func promptIntCB(prompt string, callback func(input int) (int, error)) int {
 	}
 }
 
+// Path returns the file the configuration is read from and written to,
+// $HOME/.flipconfig/flipconfig.json unless SetPath changed it.
+func Path() string {
+	pathLock.RLock()
+	defer pathLock.RUnlock()
+	return file
+}
+
+// SetPath makes path the file the configuration is read from and written to
+// by this process, so that tools can inject with a configuration of their own
+// without touching the one a campaign runs with.
+func SetPath(path string) {
+	pathLock.Lock()
+	defer pathLock.Unlock()
+	file = path
+}
+
 func (cfg *Config) WriteConfig() error {
+	file := Path()
 	bytCfg, err := json.MarshalIndent(cfg, "", "\t")
 	if err == nil {
 		if dErr := os.MkdirAll(filepath.Dir(file), os.ModePerm); dErr != nil {
@@ -219,8 +461,30 @@ func (cfg *Config) WriteConfig() error {
 	return fmt.Errorf("error marshaling config")
 }
 
+// ValidateRates checks that every error rate lies within (0, 1].
+func ValidateRates(rates []float64) error {
+	if len(rates) == 0 {
+		return fmt.Errorf("must list error rates")
+	}
+	for _, rate := range rates {
+		if rate <= 0 {
+			return fmt.Errorf("error rate cannot be negative or 0")
+		}
+		if rate > 1 {
+			return fmt.Errorf("error rate cannot be more than 1")
+		}
+	}
+	return nil
+}
+
 func ReadConfig() (Config, error) {
-	if bytes, fErr := os.ReadFile(file); fErr == nil {
+	return ReadConfigFile(Path())
+}
+
+// ReadConfigFile reads the configuration stored at path, such as a copy kept
+// with the results of a campaign.
+func ReadConfigFile(path string) (Config, error) {
+	if bytes, fErr := os.ReadFile(path); fErr == nil {
 		var cfg Config
 		if err := json.Unmarshal(bytes, &cfg); err != nil {
 			return Config{}, fmt.Errorf("error unmarshaling file data into config")
@@ -228,5 +492,5 @@ func ReadConfig() (Config, error) {
 		return cfg, nil
 	}
 
-	return Config{}, fmt.Errorf("error reading in config file from %s", file)
+	return Config{}, fmt.Errorf("error reading in config file from %s", path)
 }
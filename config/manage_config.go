@@ -45,7 +45,15 @@ func (cfg *Config) manageWizard() {
 		} else {
 			fmt.Println("not posting)")
 		}
-		fmt.Println("5 - Save & Quit")
+		fmt.Print("5 - Result sinks (")
+		for i, sink := range cfg.Sinks {
+			if i > 0 {
+				fmt.Print(", ")
+			}
+			fmt.Print(sink.Type)
+		}
+		fmt.Println(")")
+		fmt.Println("6 - Save & Quit")
 		fmt.Println()
 
 		choice := readInt()
@@ -62,13 +70,16 @@ func (cfg *Config) manageWizard() {
 		case 4:
 			cfg.promptServer()
 			continue
+		case 5:
+			cfg.promptSinks()
+			continue
 		}
 
-		if choice == 5 {
+		if choice == 6 {
 			break
 		}
 
-		log.Printf("WARNING: choice must be within range 1-5. entered choice: \"%d\"", choice)
+		log.Printf("WARNING: choice must be within range 1-6. entered choice: \"%d\"", choice)
 	}
 
 	cfg.State.TestCounter = 0
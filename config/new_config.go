@@ -31,8 +31,11 @@ func (cfg *Config) newWizard() error {
 	cfg.promptTestCount()
 	cfg.promptErrorRates()
 	cfg.promptServer()
+	cfg.promptSinks()
 
 	cfg.Initialized = true
+	cfg.Campaign = time.Now().Format("20060102-150405")
+	cfg.Seed = time.Now().UnixNano()
 	if err := cfg.WriteConfig(); err != nil {
 		log.Fatalf("ERROR: %v", err)
 	} else {
@@ -166,3 +169,45 @@ func (cfg *Config) promptServer() {
 			})
 	}
 }
+
+func (cfg *Config) promptSinks() {
+	for {
+		strSinks := promptStringCB("Where should results be written? Enter a comma separated list, no\nspaces, of stdout, jsonl, csv or memory. Posting to an API is set above.",
+			func(input string) (string, error) {
+				if strings.Contains(input, " ") {
+					return "", fmt.Errorf("cannot use spaces")
+				}
+				if strings.Compare(input, "") == 0 {
+					return "", fmt.Errorf("must list at least one sink")
+				}
+				return input, nil
+			})
+		var status int = 0
+		var tmpSinks []SinkConfig
+		for _, sinkType := range strings.Split(strings.ToLower(strSinks), ",") {
+			switch sinkType {
+			case "stdout", "memory":
+				tmpSinks = append(tmpSinks, SinkConfig{Type: sinkType})
+			case "jsonl", "csv":
+				path := promptStringCB(fmt.Sprintf("What file should the %s results be written to?", sinkType),
+					func(input string) (string, error) {
+						if strings.Compare(input, "") == 0 {
+							return "", fmt.Errorf("must include a path for %s results", sinkType)
+						}
+						return input, nil
+					})
+				tmpSinks = append(tmpSinks, SinkConfig{Type: sinkType, Path: path})
+			default:
+				log.Printf("WARNING: invalid sink \"%s\" in list", sinkType)
+				status = -1
+			}
+			if status != 0 {
+				break
+			}
+		}
+		if status == 0 {
+			cfg.Sinks = tmpSinks
+			break
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package database injects faults into the values passing through an
+// ethdb.KeyValueStore, modelling soft errors in the disk cache and in the
+// buffers on their way to and from the database.
+package database
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the wrapper, to be named in the configured sites.
+const (
+	GetSite   = "db.get"   // values read with Get
+	PutSite   = "db.put"   // values written with Put
+	BatchSite = "db.batch" // values written in a batch
+)
+
+// Key categories, as laid out by go-ethereum's rawdb schema.
+const (
+	Headers  = "headers"
+	Bodies   = "bodies"
+	Receipts = "receipts"
+	Trie     = "trie"
+	Snapshot = "snapshot"
+	Code     = "code"
+	Other    = "other"
+)
+
+// Category returns the category of a database key. Header keys are the
+// header itself; the total difficulty and canonical hash kept under the same
+// prefix are other.
+func Category(key []byte) string {
+	const numHash = 8 + common.HashLength
+	switch {
+	case len(key) == common.HashLength:
+		return Trie
+	case len(key) == 1+numHash && key[0] == 'h':
+		return Headers
+	case len(key) == 1+numHash && key[0] == 'b':
+		return Bodies
+	case len(key) == 1+numHash && key[0] == 'r':
+		return Receipts
+	case len(key) == 1+common.HashLength && bytes.HasPrefix(key, rawdb.SnapshotAccountPrefix),
+		len(key) == 1+2*common.HashLength && bytes.HasPrefix(key, rawdb.SnapshotStoragePrefix):
+		return Snapshot
+	case len(key) == 1+common.HashLength && bytes.HasPrefix(key, rawdb.CodePrefix):
+		return Code
+	}
+	return Other
+}
+
+// Store wraps an ethdb.KeyValueStore, running the values of the selected keys
+// through BitFlip on Get, Put and batch writes. Values read are corrupted as
+// returned to the caller and values written as stored, leaving the caller's
+// buffer intact. Every record carries the key and its category in its context.
+//
+// The key filters are read from the configuration when the store is wrapped.
+type Store struct {
+	ethdb.KeyValueStore
+	filtered   bool // a filter is configured, if only of invalid prefixes
+	categories map[string]bool
+	prefixes   [][]byte
+}
+
+// Wrap wraps db with the configured key filters.
+func Wrap(db ethdb.KeyValueStore) *Store {
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		cfg = config.DefaultConfig
+	}
+	s := &Store{KeyValueStore: db, filtered: len(cfg.Database.Categories) > 0 || len(cfg.Database.Prefixes) > 0}
+	if len(cfg.Database.Categories) > 0 {
+		s.categories = make(map[string]bool)
+		for _, category := range cfg.Database.Categories {
+			s.categories[strings.ToLower(category)] = true
+		}
+	}
+	for _, prefix := range cfg.Database.Prefixes {
+		if b, err := hex.DecodeString(strings.TrimPrefix(prefix, "0x")); err == nil {
+			s.prefixes = append(s.prefixes, b)
+		} else {
+			log.Printf("WARNING: invalid key prefix \"%s\" in database filter", prefix)
+		}
+	}
+	return s
+}
+
+// NewMemoryDatabase is rawdb.NewMemoryDatabase with injection, for offline
+// campaigns.
+func NewMemoryDatabase() ethdb.Database {
+	return rawdb.NewDatabase(Wrap(memorydb.New()))
+}
+
+// Get retrieves and corrupts the value of key.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	value, err := s.KeyValueStore.Get(key)
+	if err != nil || !s.match(key) {
+		return value, err
+	}
+	// Stores return a copy, so the stored value is left alone.
+	return s.flip(value, key, GetSite), nil
+}
+
+// Put corrupts value and writes it under key.
+func (s *Store) Put(key []byte, value []byte) error {
+	if s.match(key) {
+		value = s.flip(common.CopyBytes(value), key, PutSite)
+	}
+	return s.KeyValueStore.Put(key, value)
+}
+
+// NewBatch creates a batch whose writes are corrupted.
+func (s *Store) NewBatch() ethdb.Batch {
+	return &batch{Batch: s.KeyValueStore.NewBatch(), store: s}
+}
+
+// match reports whether key passes the configured filters. Filters whose
+// entries are all invalid match nothing.
+func (s *Store) match(key []byte) bool {
+	if !s.filtered {
+		return true
+	}
+	if s.categories[Category(key)] {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) flip(value []byte, key []byte, site string) []byte {
+	if len(value) == 0 {
+		return value
+	}
+	return injection.BitFlip(value, site, injection.Context{
+		"key":      "0x" + hex.EncodeToString(key),
+		"category": Category(key),
+	}).([]byte)
+}
+
+// batch corrupts the values put in it before they reach the wrapped batch.
+type batch struct {
+	ethdb.Batch
+	store *Store
+}
+
+func (b *batch) Put(key []byte, value []byte) error {
+	if b.store.match(key) {
+		value = b.store.flip(common.CopyBytes(value), key, BatchSite)
+	}
+	return b.Batch.Put(key, value)
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+var (
+	hash  = common.HexToHash("0x01")
+	value = []byte{0x0f, 0xf0}
+)
+
+// key joins the parts of a database key.
+func key(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// inverse returns b with every bit flipped.
+func inverse(b []byte) []byte {
+	inv := make([]byte, len(b))
+	for i := range b {
+		inv[i] = ^b[i]
+	}
+	return inv
+}
+
+// inject writes a configuration inverting every bit at site with the key
+// filters of filter and returns a sink recording the flips.
+func inject(t *testing.T, site string, filter config.DatabaseConfig) *injection.RingSink {
+	t.Helper()
+	cfg := configtest.New(t, 1, site)
+	cfg.Database = filter
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ring := injection.NewRingSink(16)
+	injection.AddSink(ring)
+	t.Cleanup(func() { injection.RemoveSink(ring) })
+	return ring
+}
+
+func TestCategory(t *testing.T) {
+	num := common.LeftPadBytes([]byte{7}, 8)
+	tests := []struct {
+		key  []byte
+		want string
+	}{
+		{hash[:], Trie},
+		{key([]byte("h"), num, hash[:]), Headers},
+		{key([]byte("h"), num, hash[:], []byte("t")), Other}, // total difficulty
+		{key([]byte("h"), num, []byte("n")), Other},          // canonical hash
+		{key([]byte("b"), num, hash[:]), Bodies},
+		{key([]byte("r"), num, hash[:]), Receipts},
+		{key(rawdb.SnapshotAccountPrefix, hash[:]), Snapshot},
+		{key(rawdb.SnapshotStoragePrefix, hash[:], hash[:]), Snapshot},
+		{key(rawdb.CodePrefix, hash[:]), Code},
+		{[]byte("LastBlock"), Other},
+	}
+	for _, test := range tests {
+		if got := Category(test.key); got != test.want {
+			t.Errorf("Category(%x) = %s, want %s", test.key, got, test.want)
+		}
+	}
+}
+
+// The keys rawdb writes fall in the categories of the data written.
+func TestCategoryRawdb(t *testing.T) {
+	header := &types.Header{Number: big.NewInt(7), Difficulty: big.NewInt(1)}
+	tests := []struct {
+		category string
+		write    func(db *memorydb.Database)
+	}{
+		{Headers, func(db *memorydb.Database) { rawdb.WriteHeader(db, header) }},
+		{Bodies, func(db *memorydb.Database) { rawdb.WriteBody(db, hash, 7, &types.Body{}) }},
+		{Receipts, func(db *memorydb.Database) { rawdb.WriteReceipts(db, hash, 7, nil) }},
+		{Code, func(db *memorydb.Database) { rawdb.WriteCode(db, hash, value) }},
+		{Snapshot, func(db *memorydb.Database) { rawdb.WriteAccountSnapshot(db, hash, value) }},
+	}
+	for _, test := range tests {
+		db := memorydb.New()
+		test.write(db)
+		found := false
+		it := db.NewIterator(nil, nil)
+		for it.Next() {
+			found = found || Category(it.Key()) == test.category
+		}
+		it.Release()
+		if !found {
+			t.Errorf("no %s key written", test.category)
+		}
+	}
+}
+
+// Values are corrupted as they are read, written or written in a batch,
+// leaving the stored value or the caller's buffer intact.
+func TestStore(t *testing.T) {
+	k := key(rawdb.CodePrefix, hash[:])
+	for _, site := range []string{GetSite, PutSite, BatchSite} {
+		t.Run(site, func(t *testing.T) {
+			ring := inject(t, site, config.DatabaseConfig{})
+			db := memorydb.New()
+			store := Wrap(db)
+			buf := common.CopyBytes(value)
+
+			switch site {
+			case GetSite:
+				if err := db.Put(k, buf); err != nil {
+					t.Fatal(err)
+				}
+				got, err := store.Get(k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, inverse(value)) {
+					t.Errorf("read %x, want %x", got, inverse(value))
+				}
+			case PutSite:
+				if err := store.Put(k, buf); err != nil {
+					t.Fatal(err)
+				}
+			case BatchSite:
+				b := store.NewBatch()
+				if err := b.Put(k, buf); err != nil {
+					t.Fatal(err)
+				}
+				if err := b.Write(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stored, err := db.Get(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := inverse(value)
+			if site == GetSite {
+				want = value
+			}
+			if !bytes.Equal(stored, want) {
+				t.Errorf("stored %x, want %x", stored, want)
+			}
+			if !bytes.Equal(buf, value) {
+				t.Errorf("caller's buffer changed to %x", buf)
+			}
+
+			records := ring.Records()
+			if len(records) != 1 {
+				t.Fatalf("%d flips, want 1", len(records))
+			}
+			wantContext := injection.Context{"key": "0x" + common.Bytes2Hex(k), "category": Code}
+			if data := records[0].ErrorData; data.Msg != site || data.Context.String() != wantContext.String() {
+				t.Errorf("flip at %s with %v, want %s with %v", data.Msg, data.Context, site, wantContext)
+			}
+		})
+	}
+}
+
+func TestStoreFilters(t *testing.T) {
+	code := key(rawdb.CodePrefix, hash[:])
+	tests := []struct {
+		name   string
+		filter config.DatabaseConfig
+		key    []byte
+		flip   bool
+	}{
+		{"none", config.DatabaseConfig{}, []byte("LastBlock"), true},
+		{"category", config.DatabaseConfig{Categories: []string{"Code"}}, code, true},
+		{"other category", config.DatabaseConfig{Categories: []string{Trie}}, code, false},
+		{"prefix", config.DatabaseConfig{Prefixes: []string{"0x4c617374"}}, []byte("LastBlock"), true},
+		{"other prefix", config.DatabaseConfig{Prefixes: []string{"4c6173"}}, code, false},
+		{"invalid prefix", config.DatabaseConfig{Prefixes: []string{"0xzz"}}, code, false},
+		{"category or prefix", config.DatabaseConfig{Categories: []string{Trie}, Prefixes: []string{"63"}}, code, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring := inject(t, PutSite, test.filter)
+			db := memorydb.New()
+			if err := Wrap(db).Put(test.key, value); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := db.Get(test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if flipped := !bytes.Equal(stored, value); flipped != test.flip || (len(ring.Records()) == 1) != test.flip {
+				t.Errorf("stored %x with %d flips, flip %v", stored, len(ring.Records()), test.flip)
+			}
+		})
+	}
+}
+
+// Empty values have nothing to corrupt.
+func TestStoreEmpty(t *testing.T) {
+	ring := inject(t, PutSite, config.DatabaseConfig{})
+	db := memorydb.New()
+	if err := Wrap(db).Put(hash[:], nil); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := db.Get(hash[:]); err != nil || len(stored) != 0 {
+		t.Errorf("stored %x, %v, want an empty value", stored, err)
+	}
+	if records := ring.Records(); len(records) != 0 {
+		t.Errorf("%d flips of an empty value", len(records))
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the trie node layer, to be named in the configured sites.
+const (
+	ResolveSite = "trie.resolve" // nodes read from the database
+	CommitSite  = "trie.commit"  // nodes written to the database
+)
+
+// Node types recorded for trie node flips.
+const (
+	ShortNode = "short" // the key or child reference of a short node
+	FullNode  = "full"  // a child reference of a full node
+	ValueNode = "value" // a value held in a short or full node
+)
+
+// maxPaths bounds the number of node paths remembered.
+const maxPaths = 1 << 20
+
+// TrieStore wraps an ethdb.KeyValueStore, corrupting Merkle-Patricia trie
+// nodes as the trie database resolves them from it and commits them to it.
+// go-ethereum 1.10.13 does not check a resolved node against its hash, so a
+// corrupted node is either used as it is or fails to decode.
+//
+// Each element of a node, a child reference, a key or a value, is run through
+// BitFlip on its own, leaving the RLP framing intact so the node still
+// decodes. Every record carries the node hash, its path in the trie as hex
+// nibbles and the type of the element in its context. A path is learned from
+// the parent node, so nodes are assumed to be roots, at path "", until their
+// parent has been seen. Storage tries have paths of their own.
+//
+// With Verify set every node read is checked against its hash after it has
+// been corrupted, as go-ethereum does not, so a corrupted node is reported
+// missing rather than used.
+type TrieStore struct {
+	ethdb.KeyValueStore
+	Verify bool
+
+	lock  sync.Mutex
+	paths map[common.Hash]string
+}
+
+// WrapTrie wraps db with the trie node layer.
+func WrapTrie(db ethdb.KeyValueStore) *TrieStore {
+	return &TrieStore{KeyValueStore: db, paths: make(map[common.Hash]string)}
+}
+
+// Get retrieves and corrupts the trie node under key. Other values are
+// returned as they are.
+func (t *TrieStore) Get(key []byte) ([]byte, error) {
+	value, err := t.KeyValueStore.Get(key)
+	if err != nil || len(key) != common.HashLength {
+		return value, err
+	}
+	hash := common.BytesToHash(key)
+	n, err := parseNode(value, t.path(hash))
+	if err != nil {
+		return value, nil
+	}
+	t.learn(n)
+	n.flip(value, hash, ResolveSite)
+	if t.Verify && crypto.Keccak256Hash(value) != hash {
+		return nil, fmt.Errorf("trie node %x does not match its hash", hash)
+	}
+	return value, nil
+}
+
+// Put corrupts the trie node value and writes it under key.
+func (t *TrieStore) Put(key []byte, value []byte) error {
+	if len(key) == common.HashLength {
+		hash := common.BytesToHash(key)
+		if n, err := parseNode(value, t.path(hash)); err == nil {
+			t.learn(n)
+			value = common.CopyBytes(value)
+			n.flip(value, hash, CommitSite)
+		}
+	}
+	return t.KeyValueStore.Put(key, value)
+}
+
+// NewBatch creates a batch whose trie nodes are corrupted when it is written.
+func (t *TrieStore) NewBatch() ethdb.Batch {
+	return &trieBatch{Batch: t.KeyValueStore.NewBatch(), store: t}
+}
+
+func (t *TrieStore) path(hash common.Hash) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.paths[hash]
+}
+
+// learn remembers the paths of the children of n.
+func (t *TrieStore) learn(n *node) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.paths)+len(n.children) > maxPaths {
+		t.paths = make(map[common.Hash]string)
+	}
+	for _, c := range n.children {
+		t.paths[c.hash] = n.path + c.path
+	}
+}
+
+// trieBatch holds back the trie nodes put in it until it is written. The trie
+// database commits children before their parents, so the paths of a batch's
+// nodes are only known once the whole batch is.
+type trieBatch struct {
+	ethdb.Batch
+	store *TrieStore
+	nodes []keyValue
+	size  int
+}
+
+type keyValue struct {
+	key, value []byte
+}
+
+func (b *trieBatch) Put(key []byte, value []byte) error {
+	if len(key) != common.HashLength {
+		return b.Batch.Put(key, value)
+	}
+	b.nodes = append(b.nodes, keyValue{common.CopyBytes(key), common.CopyBytes(value)})
+	b.size += len(value)
+	return nil
+}
+
+func (b *trieBatch) ValueSize() int {
+	return b.Batch.ValueSize() + b.size
+}
+
+// Write works out the paths of the held back nodes from the roots of the
+// batch down, corrupts them and writes them with the rest of the batch.
+func (b *trieBatch) Write() error {
+	var (
+		children = make(map[common.Hash][]child)
+		isChild  = make(map[common.Hash]bool)
+	)
+	for _, kv := range b.nodes {
+		if n, err := parseNode(kv.value, ""); err == nil {
+			hash := common.BytesToHash(kv.key)
+			children[hash] = n.children
+			for _, c := range n.children {
+				isChild[c.hash] = true
+			}
+		}
+	}
+	paths := make(map[common.Hash]string)
+	var walk func(hash common.Hash, path string)
+	walk = func(hash common.Hash, path string) {
+		if _, ok := paths[hash]; ok {
+			return
+		}
+		paths[hash] = path
+		for _, c := range children[hash] {
+			walk(c.hash, path+c.path)
+		}
+	}
+	for hash := range children {
+		if !isChild[hash] {
+			walk(hash, b.store.path(hash))
+		}
+	}
+
+	for _, kv := range b.nodes {
+		hash := common.BytesToHash(kv.key)
+		if n, err := parseNode(kv.value, paths[hash]); err == nil {
+			b.store.learn(n)
+			n.flip(kv.value, hash, CommitSite)
+		}
+		if err := b.Batch.Put(kv.key, kv.value); err != nil {
+			return err
+		}
+	}
+	b.nodes, b.size = nil, 0
+	return b.Batch.Write()
+}
+
+func (b *trieBatch) Reset() {
+	b.Batch.Reset()
+	b.nodes, b.size = nil, 0
+}
+
+// child is a node referenced by hash from its parent, at a path relative to
+// the parent's.
+type child struct {
+	hash common.Hash
+	path string
+}
+
+// element is a part of a node blob that can be corrupted without breaking the
+// blob's RLP framing.
+type element struct {
+	start, end int
+	kind       string
+}
+
+// node is a parsed trie node blob, with nodes embedded in it flattened.
+type node struct {
+	path     string
+	elements []element
+	children []child
+}
+
+// parseNode parses the trie node blob at path, in hex nibbles. It fails for
+// anything that is not a short or full node.
+func parseNode(blob []byte, path string) (*node, error) {
+	n := &node{path: path}
+	if err := n.parse(blob, blob, path); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *node) parse(blob, buf []byte, path string) error {
+	content, _, err := rlp.SplitList(buf)
+	if err != nil {
+		return err
+	}
+	count, err := rlp.CountValues(content)
+	if err != nil {
+		return err
+	}
+	switch count {
+	case 2:
+		_, key, rest, err := rlp.Split(content)
+		if err != nil || len(key) == 0 {
+			return fmt.Errorf("invalid short node key")
+		}
+		nibbles, leaf := compactToHex(key)
+		n.add(blob, key, ShortNode)
+		kind, val, _, err := rlp.Split(rest)
+		if err != nil {
+			return err
+		}
+		if leaf {
+			n.add(blob, val, ValueNode)
+			return nil
+		}
+		return n.ref(blob, rest, kind, val, path+nibbles, ShortNode)
+	case 17:
+		for i := 0; i < 16; i++ {
+			kind, val, rest, err := rlp.Split(content)
+			if err != nil {
+				return err
+			}
+			if err := n.ref(blob, content, kind, val, path+fmt.Sprintf("%x", i), FullNode); err != nil {
+				return err
+			}
+			content = rest
+		}
+		_, val, _, err := rlp.Split(content)
+		if err != nil {
+			return err
+		}
+		n.add(blob, val, ValueNode)
+		return nil
+	}
+	return fmt.Errorf("invalid number of list elements: %d", count)
+}
+
+// ref handles a child reference, which is a hash, an embedded node or empty.
+// raw is the reference with its RLP header and val its content.
+func (n *node) ref(blob, raw []byte, kind rlp.Kind, val []byte, path, parent string) error {
+	switch {
+	case kind == rlp.List:
+		return n.parse(blob, raw, path)
+	case len(val) == common.HashLength:
+		n.add(blob, val, parent)
+		n.children = append(n.children, child{common.BytesToHash(val), path[len(n.path):]})
+	}
+	return nil
+}
+
+// add records part, a subslice of blob, as an element of the node.
+func (n *node) add(blob, part []byte, kind string) {
+	if len(part) == 0 {
+		return
+	}
+	start := cap(blob) - cap(part)
+	n.elements = append(n.elements, element{start, start + len(part), kind})
+}
+
+// flip runs every element of the node in blob through BitFlip in place.
+func (n *node) flip(blob []byte, hash common.Hash, site string) {
+	for _, e := range n.elements {
+		injection.BitFlip(blob[e.start:e.end], site, injection.Context{
+			"hash": hash.Hex(),
+			"path": n.path,
+			"node": e.kind,
+		})
+	}
+}
+
+// compactToHex decodes a compact encoded key into hex nibbles, reporting
+// whether it is the key of a leaf.
+func compactToHex(compact []byte) (string, bool) {
+	nibbles := hex.EncodeToString(compact)
+	leaf := compact[0]>>4 >= 2
+	if compact[0]>>4&1 == 1 {
+		return nibbles[1:], leaf
+	}
+	return nibbles[2:], leaf
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// writeConfig writes a configuration inverting every bit of the trie nodes
+// resolved, if start is set.
+func writeConfig(t *testing.T, start bool) {
+	t.Helper()
+	cfg := configtest.New(t, 1, ResolveSite)
+	cfg.Start = start
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// buildState commits a state trie of a single account to a TrieStore without
+// injection, so the root node is a leaf holding the account. It returns the
+// database, the root and the leaf value.
+func buildState(t *testing.T, verify bool) (ethdb.Database, common.Hash, []byte) {
+	t.Helper()
+	writeConfig(t, false)
+
+	store := WrapTrie(memorydb.New())
+	store.Verify = verify
+	db := rawdb.NewDatabase(store)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := common.HexToAddress("0x1000")
+	statedb.SetBalance(addr, big.NewInt(1e18))
+	statedb.SetNonce(addr, 7)
+	root, err := statedb.Commit(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := statedb.Database().TrieDB().Commit(root, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	return db, root, leaf(t, db, root)
+}
+
+// leaf returns the value of the first leaf of the trie at root, resolving
+// every node from db. The key is not decoded, as a corrupted one may not be.
+func leaf(t *testing.T, db ethdb.Database, root common.Hash) []byte {
+	t.Helper()
+	tr, err := trie.New(root, trie.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := tr.NodeIterator(nil)
+	for it.Next(true) {
+		if it.Leaf() {
+			return common.CopyBytes(it.LeafBlob())
+		}
+	}
+	t.Fatalf("no leaf in trie %x: %v", root, it.Error())
+	return nil
+}
+
+func TestTrieStoreVerify(t *testing.T) {
+	db, root, _ := buildState(t, true)
+
+	writeConfig(t, true)
+	_, err := state.New(root, state.NewDatabase(db), nil)
+	var missing *trie.MissingNodeError
+	if !errors.As(err, &missing) {
+		t.Fatalf("opening corrupted state: error %v, want a missing trie node", err)
+	}
+	if missing.NodeHash != root {
+		t.Errorf("missing node %x, want the root %x", missing.NodeHash, root)
+	}
+}
+
+func TestTrieStoreSilent(t *testing.T) {
+	db, root, value := buildState(t, false)
+
+	ring := injection.NewRingSink(16)
+	injection.AddSink(ring)
+	defer injection.RemoveSink(ring)
+
+	writeConfig(t, true)
+	if _, err := state.New(root, state.NewDatabase(db), nil); err != nil {
+		t.Fatalf("opening corrupted state: %v", err)
+	}
+	corrupted := leaf(t, db, root)
+	if bytes.Equal(corrupted, value) {
+		t.Fatalf("leaf read back unchanged: %x", value)
+	}
+	for i := range value {
+		if corrupted[i] != ^value[i] {
+			t.Fatalf("leaf %x is not the inverse of %x", corrupted, value)
+		}
+	}
+
+	records := ring.Records()
+	if len(records) == 0 {
+		t.Fatal("no record of the corrupted node")
+	}
+	for _, iter := range records {
+		if iter.ErrorData.Msg != ResolveSite || iter.ErrorData.Context["hash"] != root.Hex() {
+			t.Errorf("record at %s of node %v, want %s of the root", iter.ErrorData.Msg, iter.ErrorData.Context["hash"], ResolveSite)
+		}
+	}
+}
@@ -6,6 +6,7 @@ replace github.com/ethereum/go-ethereum => ..\geth-source
 
 require (
 	github.com/ethereum/go-ethereum v1.10.13
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	gopkg.in/urfave/cli.v1 v1.20.0
 )
 
@@ -53,7 +54,6 @@ require (
 	github.com/tklauser/go-sysconf v0.3.9 // indirect
 	github.com/tklauser/numcpus v0.3.0 // indirect
 	github.com/tyler-smith/go-bip39 v1.0.1-0.20181017060643-dbb3b84ba2ef // indirect
-	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
 	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf // indirect
@@ -0,0 +1,253 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	// chainKey funds and signs every transaction of the chain workload.
+	chainKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	chainAddr   = crypto.PubkeyToAddress(chainKey.PublicKey)
+
+	// counterCode deploys a contract that adds its 32 byte argument to
+	// storage slot 0 and returns the new total.
+	counterCode = hexutil.MustDecode("0x6013600c60003960136000f3600035600054018060005560005260206000f3")
+)
+
+// ChainWorkload processes a chain built with core.GenerateChain, one
+// checkpoint per block. Every block pays a new account and calls a counter
+// contract deployed in the first block, so both the account and storage tries
+// change.
+//
+// The blocks are generated once, by NewChainWorkload. With Validate, the
+// default, the results are checked against the block header like an importing
+// node does, and a mismatch rejects the block. Without it they are processed
+// like a miner processes its own block: a corrupted state or receipts root
+// shows up as a divergence rather than stopping the run. A Tracer, such as a
+// tracer.Injector, is attached to the EVM for every transaction.
+//
+// Each run starts from a database made by NewDB, or an in-memory one, and
+// commits the tries of every block to it like an archive node, so the state
+// of one block is read back from the database by the next.
+type ChainWorkload struct {
+	Validate bool
+	Tracer   vm.EVMLogger
+	NewDB    func() ethdb.Database
+
+	config   *params.ChainConfig
+	engine   consensus.Engine
+	genesis  *core.Genesis
+	blocks   []*types.Block
+	receipts []types.Receipts
+}
+
+// NewChainWorkload generates a chain of n blocks. Call it while injection is
+// disabled so the blocks themselves are fault free.
+func NewChainWorkload(n int) *ChainWorkload {
+	w := &ChainWorkload{
+		Validate: true,
+		config:   params.TestChainConfig,
+		engine:   ethash.NewFaker(),
+		genesis: &core.Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   core.GenesisAlloc{chainAddr: {Balance: new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		},
+	}
+	db := rawdb.NewMemoryDatabase()
+	genesis := w.genesis.MustCommit(db)
+	signer := types.LatestSigner(w.config)
+	counter := crypto.CreateAddress(chainAddr, 0)
+
+	w.blocks, w.receipts = core.GenerateChain(w.config, genesis, w.engine, db, n, func(i int, gen *core.BlockGen) {
+		gasPrice := new(big.Int).Mul(gen.BaseFee(), big.NewInt(2))
+		if i == 0 {
+			gen.AddTx(types.MustSignNewTx(chainKey, signer, &types.LegacyTx{
+				Nonce: gen.TxNonce(chainAddr), Gas: 100000, GasPrice: gasPrice, Data: counterCode,
+			}))
+		}
+		to := common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		gen.AddTx(types.MustSignNewTx(chainKey, signer, &types.LegacyTx{
+			Nonce: gen.TxNonce(chainAddr), To: &to, Value: big.NewInt(int64(i+1) * params.GWei), Gas: params.TxGas, GasPrice: gasPrice,
+		}))
+		gen.AddTx(types.MustSignNewTx(chainKey, signer, &types.LegacyTx{
+			Nonce: gen.TxNonce(chainAddr), To: &counter, Gas: 100000, GasPrice: gasPrice, Data: common.LeftPadBytes(big.NewInt(int64(i+1)).Bytes(), 32),
+		}))
+	})
+	return w
+}
+
+// Name describes the workload.
+func (w *ChainWorkload) Name() string {
+	return fmt.Sprintf("chain/%d", len(w.blocks))
+}
+
+// Blocks returns the generated blocks.
+func (w *ChainWorkload) Blocks() []*types.Block {
+	return w.blocks
+}
+
+// Receipts returns the receipts of the generated blocks.
+func (w *ChainWorkload) Receipts() []types.Receipts {
+	return w.receipts
+}
+
+// Run processes the blocks on top of a fresh genesis state.
+func (w *ChainWorkload) Run(record func(Checkpoint)) error {
+	newDB := w.NewDB
+	if newDB == nil {
+		newDB = rawdb.NewMemoryDatabase
+	}
+	db := newDB()
+	genesis := w.genesis.MustCommit(db)
+	sdb := state.NewDatabase(db)
+	chain := newChainReader(w.config, w.engine, genesis, w.blocks)
+
+	root := genesis.Root()
+	for _, block := range w.blocks {
+		cp := Checkpoint{Label: fmt.Sprintf("block %d", block.NumberU64())}
+		statedb, err := state.New(root, sdb, nil)
+		if err != nil {
+			return err
+		}
+		receipts, gasUsed, err := w.process(chain, block, statedb)
+		if err != nil {
+			cp.Err = err.Error()
+			record(cp)
+			return err
+		}
+		if root, err = statedb.Commit(w.config.IsEIP158(block.Number())); err != nil {
+			return err
+		}
+		if err := sdb.TrieDB().Commit(root, false, nil); err != nil {
+			return err
+		}
+		cp.StateRoot = root
+		cp.ReceiptsRoot = types.DeriveSha(receipts, trie.NewStackTrie(nil))
+		cp.GasUsed = gasUsed
+		if w.Validate {
+			if err := validate(block, cp); err != nil {
+				cp.Err = err.Error()
+				record(cp)
+				return fmt.Errorf("block %d rejected: %v", block.NumberU64(), err)
+			}
+		}
+		record(cp)
+	}
+	return nil
+}
+
+// validate checks the processing results against the header, as
+// core.BlockValidator.ValidateState does.
+func validate(block *types.Block, cp Checkpoint) error {
+	if block.GasUsed() != cp.GasUsed {
+		return fmt.Errorf("invalid gas used (remote: %d local: %d)", block.GasUsed(), cp.GasUsed)
+	}
+	if block.ReceiptHash() != cp.ReceiptsRoot {
+		return fmt.Errorf("invalid receipt root hash (remote: %x local: %x)", block.ReceiptHash(), cp.ReceiptsRoot)
+	}
+	if block.Root() != cp.StateRoot {
+		return fmt.Errorf("invalid merkle root (remote: %x local: %x)", block.Root(), cp.StateRoot)
+	}
+	return nil
+}
+
+// process applies the transactions and rewards of block like
+// core.StateProcessor, which needs a full blockchain to read headers from.
+func (w *ChainWorkload) process(chain *chainReader, block *types.Block, statedb *state.StateDB) (types.Receipts, uint64, error) {
+	var (
+		receipts types.Receipts
+		usedGas  = new(uint64)
+		header   = block.Header()
+		gp       = new(core.GasPool).AddGas(block.GasLimit())
+	)
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), i)
+		receipt, err := core.ApplyTransaction(w.config, chain, nil, gp, statedb, header, tx, usedGas, w.vmConfig())
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not apply tx %d [%v]: %w", i, tx.Hash().Hex(), err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	w.engine.Finalize(chain, header, statedb, block.Transactions(), block.Uncles())
+	return receipts, *usedGas, nil
+}
+
+// vmConfig attaches the tracer, if any, to the EVM.
+func (w *ChainWorkload) vmConfig() vm.Config {
+	return vm.Config{Debug: w.Tracer != nil, Tracer: w.Tracer}
+}
+
+// chainReader serves the headers of the generated chain to the EVM and the
+// consensus engine.
+type chainReader struct {
+	config  *params.ChainConfig
+	engine  consensus.Engine
+	headers []*types.Header
+	byHash  map[common.Hash]*types.Header
+}
+
+func newChainReader(config *params.ChainConfig, engine consensus.Engine, genesis *types.Block, blocks []*types.Block) *chainReader {
+	chain := &chainReader{config: config, engine: engine, byHash: make(map[common.Hash]*types.Header)}
+	for _, block := range append([]*types.Block{genesis}, blocks...) {
+		chain.headers = append(chain.headers, block.Header())
+		chain.byHash[block.Hash()] = block.Header()
+	}
+	return chain
+}
+
+func (c *chainReader) Config() *params.ChainConfig { return c.config }
+func (c *chainReader) Engine() consensus.Engine    { return c.engine }
+
+func (c *chainReader) CurrentHeader() *types.Header {
+	return c.headers[len(c.headers)-1]
+}
+
+func (c *chainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.byHash[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c *chainReader) GetHeaderByNumber(number uint64) *types.Header {
+	if number < uint64(len(c.headers)) {
+		return c.headers[number]
+	}
+	return nil
+}
+
+func (c *chainReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.byHash[hash]
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"fmt"
+
+	"github.com/griffindavis02/eth-bit-flip/database"
+	"github.com/griffindavis02/eth-bit-flip/tracer"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	workloadFlag = cli.StringFlag{
+		Name:  "workload",
+		Usage: "Workload to run: chain, corpus, trie, state or p2p",
+		Value: "chain",
+	}
+	blocksFlag = cli.IntFlag{
+		Name:  "blocks",
+		Usage: "Number of blocks in the chain and p2p workloads",
+		Value: 16,
+	}
+	accountsFlag = cli.IntFlag{
+		Name:  "accounts",
+		Usage: "Number of accounts in the trie and state workloads",
+		Value: 64,
+	}
+	roundsFlag = cli.IntFlag{
+		Name:  "rounds",
+		Usage: "Number of rounds of transfers in the state workload",
+		Value: 8,
+	}
+	validateFlag = cli.BoolFlag{
+		Name:  "validate",
+		Usage: "Check each node of the trie workload against its hash",
+	}
+	noValidateFlag = cli.BoolFlag{
+		Name:  "no-validate",
+		Usage: "Process the blocks of the chain workload like a miner, without checking them against their headers",
+	}
+	timeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Time after which the faulty run is declared hung (default: ten times the golden run)",
+	}
+	corpusFlag = cli.StringFlag{
+		Name:  "corpus",
+		Usage: "Directory of .hex programs for the corpus workload (default: built in programs)",
+	}
+	tracerFlag = cli.BoolFlag{
+		Name:  "tracer",
+		Usage: "Inject through an EVM tracer rather than BitFlip calls compiled into go-ethereum",
+	}
+	databaseFlag = cli.BoolFlag{
+		Name:  "database",
+		Usage: "Inject into the values read from and written to the chain workload's database",
+	}
+	runsFlag = cli.IntFlag{
+		Name:  "runs",
+		Usage: "Number of faulty runs, each seeded with the configured seed plus its index",
+		Value: 1,
+	}
+	configFlag = cli.StringFlag{
+		Name:  "config",
+		Usage: "Configuration to run the faulty run with (default: the current configuration)",
+	}
+	outputFlag = cli.StringFlag{
+		Name:  "output, o",
+		Usage: "File to write the result to (default: standard output)",
+	}
+
+	// CompareCommand is the 'flipcfg compare' subcommand.
+	CompareCommand = cli.Command{
+		Action: compare,
+		Name:   "compare",
+		Usage:  "Compare a golden and a faulty run of a deterministic workload",
+		Flags:  []cli.Flag{workloadFlag, blocksFlag, accountsFlag, roundsFlag, validateFlag, noValidateFlag, corpusFlag, timeoutFlag, tracerFlag, databaseFlag, runsFlag, configFlag, outputFlag},
+		Description: `
+Runs a workload once with injection disabled and once with the configuration,
+recording the state root, receipts root, gas used and return data after every
+block or program. The result, written as JSON, names the first checkpoint at
+which the runs differ and the flips made up to it, and classifies the faulty
+run as masked, sdc (silent data corruption), detected, crash or hang.
+
+With --runs greater than one the workload is run that many times with faults
+against a single golden run and the results are written as a JSON array, the
+input 'flipcfg avf' estimates vulnerability factors from.
+
+Injection happens at the BitFlip calls compiled into go-ethereum, so the tool
+must be built against the patched source for the faulty run to differ, unless
+--tracer injects into the EVM's stack, memory and return data instead, or
+--database into the chain workload's database.`,
+	}
+)
+
+func compare(ctx *cli.Context) error {
+	e, err := NewExperiment(ctx, configFlag.Name, "", "")
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	e.Output = ctx.String("output")
+
+	// Build the workload before anything is injected
+	session, err := NewSession(e.Config)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer session.Close()
+	var w Workload
+	switch ctx.String(workloadFlag.Name) {
+	case "chain":
+		chain := NewChainWorkload(ctx.Int(blocksFlag.Name))
+		chain.Validate = !ctx.Bool(noValidateFlag.Name)
+		if ctx.Bool(tracerFlag.Name) {
+			chain.Tracer = tracer.NewInjector()
+		}
+		if ctx.Bool(databaseFlag.Name) {
+			chain.NewDB = database.NewMemoryDatabase
+		}
+		w = chain
+	case "corpus":
+		var programs []Program
+		if dir := ctx.String(corpusFlag.Name); dir != "" {
+			if programs, err = ReadCorpus(dir); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		corpus := NewCorpusWorkload(programs)
+		if ctx.Bool(tracerFlag.Name) {
+			corpus.Tracer = tracer.NewInjector()
+		}
+		w = corpus
+	case "trie":
+		trie := NewTrieWorkload(ctx.Int(accountsFlag.Name))
+		trie.Validate = ctx.Bool(validateFlag.Name)
+		w = trie
+	case "state":
+		w = NewStateWorkload(ctx.Int(accountsFlag.Name), ctx.Int(roundsFlag.Name))
+	case "p2p":
+		if w, err = NewP2PWorkload(ctx.Int(blocksFlag.Name)); err != nil {
+			return cli.NewExitError(err, 1)
+		}
+	default:
+		return cli.NewExitError(fmt.Sprintf("unknown workload \"%s\"", ctx.String(workloadFlag.Name)), 1)
+	}
+
+	runs := ctx.Int(runsFlag.Name)
+	if runs < 1 {
+		return cli.NewExitError("--runs must be at least 1", 1)
+	}
+	results, err := CompareRuns(w, e.Config, ctx.Duration(timeoutFlag.Name), runs)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	var result interface{} = results
+	if runs == 1 {
+		result = results[0]
+	}
+	if err := e.Write(result, nil); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
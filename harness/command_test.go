@@ -0,0 +1,136 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/codec"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"github.com/griffindavis02/eth-bit-flip/header"
+	"github.com/griffindavis02/eth-bit-flip/signature"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// run runs flipcfg with args and returns what it wrote to standard output.
+func run(t *testing.T, args ...string) []byte {
+	t.Helper()
+	app := cli.NewApp()
+	app.Commands = []cli.Command{harness.CompareCommand, codec.RLPCommand, signature.SignatureCommand, header.HeaderCommand}
+	app.Writer = os.Stderr
+
+	stdout := filepath.Join(t.TempDir(), "stdout")
+	file, err := os.Create(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := os.Stdout
+	os.Stdout = file
+	err = app.Run(append([]string{"flipcfg"}, args...))
+	os.Stdout = previous
+	file.Close()
+	if err != nil {
+		t.Fatalf("flipcfg %v: %v", args, err)
+	}
+	out, err := os.ReadFile(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// campaign writes a campaign printing its flips to standard output, which the
+// commands must keep out of theirs, and returns its file.
+func campaign(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "campaign.json")
+	cfg := configtest.New(t, 1.0/64)
+	cfg.Campaign = name
+	cfg.Sinks = []config.SinkConfig{{Type: "stdout"}}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(config.Path(), path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// compare reads the configuration named by --config and writes the result to
+// the file named by -o.
+func TestCompareOutput(t *testing.T) {
+	campaign := campaign(t, "compare")
+
+	output := filepath.Join(t.TempDir(), "result.json")
+	if out := run(t, "compare", "--workload", "trie", "--accounts", "8", "--config", campaign, "-o", output); len(out) != 0 {
+		t.Errorf("compare wrote %q to standard output", out)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result harness.Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("result %s: %v", data, err)
+	}
+	if result.Campaign != "compare" || result.Workload != "trie/8" {
+		t.Errorf("result of campaign %q workload %q, want compare trie/8", result.Campaign, result.Workload)
+	}
+}
+
+// The experiments write their summary, and nothing else, to standard output.
+func TestExperimentOutput(t *testing.T) {
+	tests := []struct {
+		args    []string
+		summary interface{}
+	}{
+		{[]string{"rlp", "--blocks", "2", "--runs", "1"}, &[]*codec.Summary{}},
+		{[]string{"signature", "--keys", "2", "--runs", "1"}, &[]*signature.Summary{}},
+		{[]string{"header", "--blocks", "2", "--runs", "1"}, &header.Matrix{}},
+	}
+	for _, test := range tests {
+		t.Run(test.args[0], func(t *testing.T) {
+			args := append(test.args, "--config", campaign(t, test.args[0]))
+
+			out := run(t, append(args, "--format", "json")...)
+			dec := json.NewDecoder(bytes.NewReader(out))
+			if err := dec.Decode(test.summary); err != nil {
+				t.Fatalf("json output %s: %v", out, err)
+			}
+			var extra json.RawMessage
+			if err := dec.Decode(&extra); err != io.EOF {
+				t.Errorf("json output followed by %s", extra)
+			}
+
+			out = run(t, append(args, "--format", "table")...)
+			if !bytes.HasPrefix(out, []byte("Site")) || bytes.Contains(out, []byte("ErrorData")) {
+				t.Errorf("table output %s", out)
+			}
+			if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) < 2 {
+				t.Errorf("table output without rows: %s", out)
+			}
+		})
+	}
+}
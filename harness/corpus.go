@@ -0,0 +1,151 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Program is EVM bytecode run with the given call data.
+type Program struct {
+	Name  string
+	Code  []byte
+	Input []byte
+}
+
+// DefaultCorpus exercises arithmetic, memory, storage and hashing.
+var DefaultCorpus = []Program{
+	{
+		// Adds the argument to slot 0 and returns the total
+		Name:  "counter",
+		Code:  hexutil.MustDecode("0x600035600054018060005560005260206000f3"),
+		Input: common.LeftPadBytes([]byte{42}, 32),
+	},
+	{
+		// Sums 1..255 in a loop and returns the sum
+		Name: "loop",
+		Code: hexutil.MustDecode("0x600060ff5b801560155780910190600190036004565b5060005260206000f3"),
+	},
+	{
+		// Hashes 64 bytes of memory and stores the hash in slot 1
+		Name:  "keccak",
+		Code:  hexutil.MustDecode("0x60003560005260203560205260406000208060015560005260206000f3"),
+		Input: common.LeftPadBytes([]byte{1, 2, 3}, 64),
+	},
+}
+
+// CorpusWorkload runs each program in turn against a shared state, one
+// checkpoint per program. A Tracer, such as a tracer.Injector, is attached to
+// the EVM for every program.
+type CorpusWorkload struct {
+	Programs []Program
+	Tracer   vm.EVMLogger
+}
+
+// NewCorpusWorkload creates a workload of programs, or of DefaultCorpus if
+// there are none.
+func NewCorpusWorkload(programs []Program) *CorpusWorkload {
+	if len(programs) == 0 {
+		programs = DefaultCorpus
+	}
+	return &CorpusWorkload{Programs: programs}
+}
+
+// ReadCorpus loads every .hex file in dir as a program. The file holds the
+// hex encoded code, optionally followed by whitespace and hex call data.
+func ReadCorpus(dir string) ([]Program, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.hex"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	programs := make([]Program, 0, len(paths))
+	for _, path := range paths {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading in program from %s", path)
+		}
+		fields := strings.Fields(string(bytes))
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid program in %s", path)
+		}
+		program := Program{Name: strings.TrimSuffix(filepath.Base(path), ".hex")}
+		if program.Code, err = decodeHex(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid code in %s: %v", path, err)
+		}
+		if len(fields) == 2 {
+			if program.Input, err = decodeHex(fields[1]); err != nil {
+				return nil, fmt.Errorf("invalid input in %s: %v", path, err)
+			}
+		}
+		programs = append(programs, program)
+	}
+	return programs, nil
+}
+
+func decodeHex(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") {
+		s = "0x" + s
+	}
+	return hexutil.Decode(s)
+}
+
+// Name describes the workload.
+func (w *CorpusWorkload) Name() string {
+	return fmt.Sprintf("corpus/%d", len(w.Programs))
+}
+
+// Run executes the programs. An error returned by a program is recorded in
+// its checkpoint and does not stop the run.
+func (w *CorpusWorkload) Run(record func(Checkpoint)) error {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		return err
+	}
+	for i, program := range w.Programs {
+		cfg := &runtime.Config{
+			ChainConfig: params.AllEthashProtocolChanges,
+			BlockNumber: big.NewInt(int64(i + 1)),
+			Time:        big.NewInt(int64(i + 1)),
+			GasLimit:    10000000,
+			State:       statedb,
+			EVMConfig:   vm.Config{Debug: w.Tracer != nil, Tracer: w.Tracer},
+		}
+		ret, _, err := runtime.Execute(program.Code, program.Input, cfg)
+
+		cp := Checkpoint{Label: program.Name, ReturnData: ret}
+		if err != nil {
+			cp.Err = err.Error()
+		}
+		cp.StateRoot = statedb.IntermediateRoot(true)
+		record(cp)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Experiment is the skeleton of the commands that build a fixture without
+// injection, corrupt it with a configuration and summarize the records the
+// corruption produced, such as flipcfg rlp, signature and header.
+type Experiment struct {
+	Config  config.Config // configuration to inject with
+	Records string        // file to write every record to as JSON lines, if set
+	Format  string        // table or json
+	Output  string        // file to write the summary to (default: standard output)
+}
+
+// NewExperiment reads the experiment of a command from the flags named
+// configFlag, the configuration file (default: the current configuration),
+// recordsFlag and formatFlag. Commands without a format flag, given as "",
+// write JSON.
+func NewExperiment(ctx *cli.Context, configFlag, recordsFlag, formatFlag string) (*Experiment, error) {
+	var (
+		cfg config.Config
+		err error
+	)
+	if path := ctx.String(configFlag); path != "" {
+		cfg, err = config.ReadConfigFile(path)
+	} else {
+		cfg, err = config.ReadConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	format := "json"
+	if formatFlag != "" {
+		format = ctx.String(formatFlag)
+	}
+	if format != "table" && format != "json" {
+		return nil, fmt.Errorf("unknown format \"%s\"", format)
+	}
+	e := &Experiment{Config: cfg, Format: format}
+	if recordsFlag != "" {
+		e.Records = ctx.String(recordsFlag)
+	}
+	return e, nil
+}
+
+// Run calls build with injection disabled and inject with it enabled, writing
+// every record inject emits to the records file. Both run in a Session, so the
+// configuration in place is left alone.
+func (e *Experiment) Run(build func() error, inject func(emit func(record interface{}) error) error) error {
+	session, err := NewSession(e.Config)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := build(); err != nil {
+		return err
+	}
+	emit := func(interface{}) error { return nil }
+	if e.Records != "" {
+		file, err := os.Create(e.Records)
+		if err != nil {
+			return fmt.Errorf("error creating file \"%s\"", e.Records)
+		}
+		defer file.Close()
+		emit = json.NewEncoder(file).Encode
+	}
+	if err := session.Inject(0); err != nil {
+		return err
+	}
+	return inject(emit)
+}
+
+// Write writes summary to the output of the experiment in its format, as a
+// table by table or as indented JSON.
+func (e *Experiment) Write(summary interface{}, table func(w io.Writer) error) error {
+	var out io.Writer = os.Stdout
+	if e.Output != "" {
+		file, err := os.Create(e.Output)
+		if err != nil {
+			return fmt.Errorf("error creating file \"%s\"", e.Output)
+		}
+		defer file.Close()
+		out = file
+	}
+	if e.Format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "    ")
+		return enc.Encode(summary)
+	}
+	return table(out)
+}
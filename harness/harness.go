@@ -0,0 +1,337 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package harness runs a deterministic workload once without injection and
+// once with it, and reports where the faulty run first diverged from the
+// golden one.
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Checkpoint is a value recorded after each step of a workload, a block or a
+// program, that a fault may have changed.
+type Checkpoint struct {
+	Index        int           `json:"index"`
+	Label        string        `json:"label"`
+	StateRoot    common.Hash   `json:"stateRoot"`
+	ReceiptsRoot common.Hash   `json:"receiptsRoot"`
+	GasUsed      uint64        `json:"gasUsed"`
+	ReturnData   hexutil.Bytes `json:"returnData,omitempty"`
+	Err          string        `json:"error,omitempty"`
+}
+
+// Workload is a deterministic sequence of steps. Run calls record after each
+// step and must produce the same checkpoints every time it is run without
+// injection. An error stops the run.
+type Workload interface {
+	Name() string
+	Run(record func(Checkpoint)) error
+}
+
+// Flip is a flip made during a run and the checkpoint it preceded.
+type Flip struct {
+	Checkpoint int                 `json:"checkpoint"`
+	Iteration  injection.Iteration `json:"iteration"`
+}
+
+// Run is the outcome of running a workload once.
+type Run struct {
+	Checkpoints []Checkpoint  `json:"checkpoints"`
+	Flips       []Flip        `json:"flips"`
+	Err         string        `json:"error,omitempty"`
+	Panic       string        `json:"panic,omitempty"`
+	Stack       string        `json:"stack,omitempty"`
+	Hung        bool          `json:"hung,omitempty"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Outcome classifies the effect of the faults injected into a run.
+type Outcome string
+
+const (
+	// Masked runs are indistinguishable from the golden run.
+	Masked Outcome = "masked"
+	// SDC, silent data corruption, runs reach a different result without
+	// anything complaining.
+	SDC Outcome = "sdc"
+	// Detected runs return an error or reject a block the golden run
+	// accepted.
+	Detected Outcome = "detected"
+	// Crash runs panic.
+	Crash Outcome = "crash"
+	// Hang runs are stopped by the watchdog.
+	Hang Outcome = "hang"
+)
+
+// Classify decides the outcome of faulty compared with golden.
+func Classify(golden, faulty Run, div *Divergence) Outcome {
+	switch {
+	case faulty.Panic != "":
+		return Crash
+	case faulty.Hung:
+		return Hang
+	case faulty.Err != "":
+		return Detected
+	}
+	for i, cp := range faulty.Checkpoints {
+		if cp.Err != "" && (i >= len(golden.Checkpoints) || golden.Checkpoints[i].Err != cp.Err) {
+			return Detected
+		}
+	}
+	if div != nil {
+		return SDC
+	}
+	return Masked
+}
+
+// Divergence is the first checkpoint at which the faulty run differed from
+// the golden run.
+type Divergence struct {
+	Checkpoint int         `json:"checkpoint"`
+	Label      string      `json:"label"`
+	Fields     []string    `json:"fields"`
+	Golden     *Checkpoint `json:"golden,omitempty"`
+	Faulty     *Checkpoint `json:"faulty,omitempty"`
+	Flips      []Flip      `json:"flips"` // flips made up to the divergence
+}
+
+// Result compares a golden and a faulty run of a workload.
+type Result struct {
+	Workload   string      `json:"workload"`
+	Campaign   string      `json:"campaign"`
+	Seed       int64       `json:"seed"`
+	Outcome    Outcome     `json:"outcome"`
+	Golden     Run         `json:"golden"`
+	Faulty     Run         `json:"faulty"`
+	Divergence *Divergence `json:"divergence,omitempty"`
+}
+
+// MinTimeout is the least time the faulty run is given before the watchdog
+// declares it hung.
+const MinTimeout = 10 * time.Second
+
+// Compare runs w without injection and then with cfg. Both runs read their
+// configuration from a Session, so the configuration in place is left alone.
+//
+// The faulty run is declared hung if it takes longer than timeout, or, if
+// timeout is 0, ten times as long as the golden run. A hung run cannot be
+// stopped: its goroutine is left running in the background, and may go on
+// flipping with whatever configuration the process reads once the session is
+// closed, until the process exits. Run workloads that may hang in a process
+// of their own, as flipcfg compare does by exiting after the comparison.
+func Compare(w Workload, cfg config.Config, timeout time.Duration) (*Result, error) {
+	results, err := CompareRuns(w, cfg, timeout, 1)
+	if len(results) == 0 {
+		return nil, err
+	}
+	return results[0], err
+}
+
+// CompareRuns is Compare with runs faulty runs against a single golden run.
+// Run i is seeded with the configured seed plus i, so a campaign is
+// reproducible as a whole. Since a hung run is left running, and would go on
+// injecting into the runs after it, the campaign stops after a hang.
+func CompareRuns(w Workload, cfg config.Config, timeout time.Duration, runs int) ([]*Result, error) {
+	session, err := NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	goldenRun := run(w, 0)
+	if goldenRun.Err != "" || goldenRun.Panic != "" {
+		result := &Result{Workload: w.Name(), Campaign: cfg.Campaign, Seed: cfg.Seed, Golden: goldenRun}
+		return []*Result{result}, fmt.Errorf("golden run failed: %s%s", goldenRun.Err, goldenRun.Panic)
+	}
+	if timeout == 0 {
+		if timeout = 10 * goldenRun.Duration; timeout < MinTimeout {
+			timeout = MinTimeout
+		}
+	}
+
+	results := make([]*Result, 0, runs)
+	for i := 0; i < runs; i++ {
+		var seed int64
+		if cfg.Seed != 0 {
+			seed = cfg.Seed + int64(i)
+		}
+		if err := session.Inject(seed); err != nil {
+			return results, err
+		}
+		result := &Result{Workload: w.Name(), Campaign: cfg.Campaign, Seed: seed, Golden: goldenRun}
+		result.Faulty = run(w, timeout)
+		result.Divergence = diverge(result.Golden, result.Faulty)
+		result.Outcome = Classify(result.Golden, result.Faulty, result.Divergence)
+		results = append(results, result)
+		if result.Outcome == Hang {
+			break
+		}
+	}
+	return results, nil
+}
+
+// run runs w once, attributing every flip to the checkpoint it preceded. A
+// panic in the workload is recovered and a run taking longer than a non-zero
+// timeout is abandoned.
+func run(w Workload, timeout time.Duration) Run {
+	var (
+		r        Run
+		recorder = &flipRecorder{}
+		done     = make(chan struct{})
+		start    = time.Now()
+	)
+	injection.AddSink(recorder)
+	defer injection.RemoveSink(recorder)
+
+	go func() {
+		defer close(done)
+		defer func() {
+			if p := recover(); p != nil {
+				recorder.fail(fmt.Sprint(p), string(debug.Stack()))
+			}
+		}()
+		if err := w.Run(recorder.checkpoint); err != nil {
+			recorder.fail(err.Error(), "")
+		}
+	}()
+
+	var watchdog <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		watchdog = timer.C
+	}
+	select {
+	case <-done:
+	case <-watchdog:
+		r.Hung = true
+	}
+	r.Duration = time.Since(start)
+	r.Checkpoints, r.Flips, r.Err, r.Panic, r.Stack = recorder.recorded()
+	return r
+}
+
+// diverge finds the first checkpoint at which faulty differs from golden.
+func diverge(golden, faulty Run) *Divergence {
+	for i := range golden.Checkpoints {
+		g := &golden.Checkpoints[i]
+		if i >= len(faulty.Checkpoints) {
+			// The faulty run stopped early
+			return &Divergence{Checkpoint: i, Label: g.Label, Fields: []string{"missing"}, Golden: g, Flips: faulty.Flips}
+		}
+		f := &faulty.Checkpoints[i]
+		if fields := differences(g, f); len(fields) > 0 {
+			return &Divergence{Checkpoint: i, Label: g.Label, Fields: fields, Golden: g, Faulty: f, Flips: flipsBefore(faulty.Flips, i)}
+		}
+	}
+	if faulty.Err != "" || faulty.Panic != "" || faulty.Hung {
+		n := len(faulty.Checkpoints)
+		return &Divergence{Checkpoint: n, Label: "end", Fields: []string{"error"}, Flips: faulty.Flips}
+	}
+	return nil
+}
+
+func differences(g, f *Checkpoint) []string {
+	var fields []string
+	if g.StateRoot != f.StateRoot {
+		fields = append(fields, "stateRoot")
+	}
+	if g.ReceiptsRoot != f.ReceiptsRoot {
+		fields = append(fields, "receiptsRoot")
+	}
+	if g.GasUsed != f.GasUsed {
+		fields = append(fields, "gasUsed")
+	}
+	if !bytes.Equal(g.ReturnData, f.ReturnData) {
+		fields = append(fields, "returnData")
+	}
+	if g.Err != f.Err {
+		fields = append(fields, "error")
+	}
+	return fields
+}
+
+// flipsBefore returns the flips made up to and including checkpoint n.
+func flipsBefore(flips []Flip, n int) []Flip {
+	var before []Flip
+	for _, flip := range flips {
+		if flip.Checkpoint <= n {
+			before = append(before, flip)
+		}
+	}
+	return before
+}
+
+// flipRecorder collects the checkpoints of a run and is the sink tagging each
+// flip with the checkpoint it precedes. It is shared with the workload
+// goroutine, which may outlive the run if it hangs.
+type flipRecorder struct {
+	mu          sync.Mutex
+	checkpoints []Checkpoint
+	flips       []Flip
+	err         string
+	panic       string
+	stack       string
+}
+
+func (r *flipRecorder) Write(iter injection.Iteration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.flips = append(r.flips, Flip{Checkpoint: len(r.checkpoints), Iteration: iter.Copy()})
+	return nil
+}
+
+func (r *flipRecorder) checkpoint(cp Checkpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cp.Index = len(r.checkpoints)
+	r.checkpoints = append(r.checkpoints, cp)
+}
+
+// fail records an error returned by the workload, or a panic if stack is set.
+func (r *flipRecorder) fail(msg, stack string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stack != "" {
+		r.panic, r.stack = msg, stack
+	} else {
+		r.err = msg
+	}
+}
+
+func (r *flipRecorder) recorded() ([]Checkpoint, []Flip, string, string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Checkpoint{}, r.checkpoints...), append([]Flip{}, r.flips...), r.err, r.panic, r.stack
+}
+
+func (r *flipRecorder) Flush() error { return nil }
+func (r *flipRecorder) Close() error { return nil }
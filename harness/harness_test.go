@@ -0,0 +1,76 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// The recorder keeps its own copy of a flipped buffer, which the EVM goes on
+// writing to.
+func TestFlipRecorderCopies(t *testing.T) {
+	buf := []byte{0x0f, 0xf0}
+	var iter injection.Iteration
+	iter.ErrorData.PreviousValue = []byte{0xf0, 0x0f}
+	iter.ErrorData.ErrorValue = buf
+
+	var r flipRecorder
+	if err := r.Write(iter); err != nil {
+		t.Fatal(err)
+	}
+	buf[0], buf[1] = 0, 0
+
+	_, flips, _, _, _ := r.recorded()
+	if len(flips) != 1 {
+		t.Fatalf("%d flips recorded, want 1", len(flips))
+	}
+	if got := flips[0].Iteration.ErrorData.ErrorValue.([]byte); !bytes.Equal(got, []byte{0x0f, 0xf0}) {
+		t.Errorf("recorded value %x changed with the buffer, want 0ff0", got)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	var (
+		golden = Run{Checkpoints: []Checkpoint{{Index: 0}, {Index: 1, Err: "invalid block"}}}
+		div    = &Divergence{Checkpoint: 0}
+	)
+	tests := []struct {
+		name   string
+		faulty Run
+		div    *Divergence
+		want   Outcome
+	}{
+		{"masked", golden, nil, Masked},
+		{"sdc", golden, div, SDC},
+		{"error", Run{Err: "bad block"}, nil, Detected},
+		{"error after divergence", Run{Err: "bad block"}, div, Detected},
+		{"rejected block", Run{Checkpoints: []Checkpoint{{Index: 0, Err: "invalid gas used"}}}, div, Detected},
+		{"other rejection", Run{Checkpoints: []Checkpoint{{Index: 0}, {Index: 1, Err: "invalid merkle root"}}}, div, Detected},
+		{"rejection beyond golden", Run{Checkpoints: []Checkpoint{{}, {Err: "invalid block"}, {Err: "unknown ancestor"}}}, div, Detected},
+		{"golden rejection", Run{Checkpoints: []Checkpoint{{Index: 0}, {Index: 1, Err: "invalid block"}}}, div, SDC},
+		{"crash", Run{Panic: "runtime error", Err: "bad block", Hung: true}, div, Crash},
+		{"hang", Run{Hung: true, Err: "bad block"}, div, Hang},
+	}
+	for _, test := range tests {
+		if got := Classify(golden, test.faulty, test.div); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
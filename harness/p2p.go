@@ -0,0 +1,316 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/forkid"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/protocols/eth"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/griffindavis02/eth-bit-flip/network"
+)
+
+const (
+	// p2pNetwork is the network ID the peers of the p2p workload agree on.
+	p2pNetwork = 1337
+
+	// p2pTimeout is how long the client waits for a response before giving
+	// up on the server, as the downloader drops a peer that stops answering.
+	p2pTimeout = 5 * time.Second
+)
+
+var (
+	// Node IDs of the two peers of the p2p workload.
+	serverID = enode.ID(crypto.Keccak256Hash([]byte("server")))
+	clientID = enode.ID(crypto.Keccak256Hash([]byte("client")))
+
+	errUnexpectedPacket = errors.New("unexpected packet")
+)
+
+// P2PWorkload syncs the chain of a ChainWorkload between two in-process peers
+// running the eth protocol over a p2p.MsgPipe. Both ends of the pipe are
+// wrapped by network.Wrap, so a record's peer is the remote end of the
+// connection that read or wrote the message.
+//
+// The server serves the chain with the eth protocol handler of go-ethereum.
+// The client starts from the genesis block and, like the downloader, checks
+// what it is sent before importing the blocks into a core.BlockChain. It has
+// a checkpoint per exchange:
+//
+//   - handshake: the head and total difficulty the server announced
+//   - headers: the hashes of the headers, which must link up
+//   - bodies: a hash of the bodies, which must match the headers
+//   - receipts: a hash of the receipts, which must match the headers
+//   - import: the state root of the imported head block
+//
+// A peer whose handler fails, or a response that does not arrive in time,
+// fails the run like a dropped peer.
+type P2PWorkload struct {
+	chain  *ChainWorkload
+	server *core.BlockChain
+}
+
+// NewP2PWorkload generates a chain of n blocks and imports it into the
+// server. Call it while injection is disabled so the server is fault free.
+func NewP2PWorkload(n int) (*P2PWorkload, error) {
+	w := &P2PWorkload{chain: NewChainWorkload(n)}
+	server, err := w.newBlockChain()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := server.InsertChain(w.chain.blocks); err != nil {
+		server.Stop()
+		return nil, err
+	}
+	w.server = server
+	return w, nil
+}
+
+// Name describes the workload.
+func (w *P2PWorkload) Name() string {
+	return fmt.Sprintf("p2p/%d", len(w.chain.blocks))
+}
+
+// Run connects a fresh client to the server and syncs it.
+func (w *P2PWorkload) Run(record func(Checkpoint)) error {
+	client, err := w.newBlockChain()
+	if err != nil {
+		return err
+	}
+	defer client.Stop()
+
+	var (
+		serverRW, clientRW = p2p.MsgPipe()
+		serverPeer         = newP2PPeer(network.Wrap(serverRW, eth.ProtocolName, eth.ETH66, clientID), clientID)
+		clientPeer         = newP2PPeer(network.Wrap(clientRW, eth.ProtocolName, eth.ETH66, serverID), serverID)
+		backend            = &clientBackend{serverBackend{client}, make(chan eth.Packet), make(chan struct{})}
+		errc               = make(chan error, 4)
+	)
+	defer serverPeer.Close()
+	defer clientPeer.Close()
+	defer serverRW.Close()
+	defer close(backend.done)
+
+	cp := Checkpoint{Label: "handshake"}
+	go func() {
+		errc <- handshake(serverPeer, w.server)
+	}()
+	if err := handshake(clientPeer, client); err != nil {
+		return fail(record, cp, fmt.Errorf("client handshake failed: %v", err))
+	}
+	if err := <-errc; err != nil {
+		return fail(record, cp, fmt.Errorf("server handshake failed: %v", err))
+	}
+	head, td := clientPeer.Head()
+	cp.ReturnData = append(head.Bytes(), td.Bytes()...)
+	record(cp)
+
+	go func() {
+		errc <- fmt.Errorf("server dropped client: %v", eth.Handle(&serverBackend{chain: w.server}, serverPeer))
+	}()
+	go func() {
+		errc <- fmt.Errorf("client dropped server: %v", eth.Handle(backend, clientPeer))
+	}()
+	request := func(send func() error) (eth.Packet, error) {
+		if err := send(); err != nil {
+			return nil, err
+		}
+		timeout := time.NewTimer(p2pTimeout)
+		defer timeout.Stop()
+		select {
+		case packet := <-backend.packets:
+			return packet, nil
+		case err := <-errc:
+			return nil, err
+		case <-timeout.C:
+			return nil, p2p.DiscReadTimeout
+		}
+	}
+
+	// Headers
+	cp = Checkpoint{Label: "headers"}
+	packet, err := request(func() error {
+		return clientPeer.RequestHeadersByNumber(1, len(w.chain.blocks), 0, false)
+	})
+	if err != nil {
+		return fail(record, cp, err)
+	}
+	headers, ok := packet.(*eth.BlockHeadersPacket)
+	if !ok {
+		return fail(record, cp, fmt.Errorf("%w: %s", errUnexpectedPacket, packet.Name()))
+	}
+	hasher := crypto.NewKeccakState()
+	parent := client.Genesis().Header()
+	hashes := make([]common.Hash, len(*headers))
+	for i, header := range *headers {
+		hashes[i] = header.Hash()
+		hasher.Write(hashes[i][:])
+		if header.Number.Uint64() != parent.Number.Uint64()+1 || header.ParentHash != parent.Hash() {
+			return fail(record, cp, fmt.Errorf("non contiguous header %d [%x]", header.Number, hashes[i]))
+		}
+		parent = header
+	}
+	cp.ReturnData = hasher.Sum(nil)
+	if len(hashes) != len(w.chain.blocks) {
+		return fail(record, cp, fmt.Errorf("received %d headers, requested %d", len(hashes), len(w.chain.blocks)))
+	}
+	record(cp)
+
+	// Bodies
+	cp = Checkpoint{Label: "bodies"}
+	if packet, err = request(func() error { return clientPeer.RequestBodies(hashes) }); err != nil {
+		return fail(record, cp, err)
+	}
+	bodies, ok := packet.(*eth.BlockBodiesPacket)
+	if !ok {
+		return fail(record, cp, fmt.Errorf("%w: %s", errUnexpectedPacket, packet.Name()))
+	}
+	cp.ReturnData = rlpHash(bodies)
+	if len(*bodies) != len(hashes) {
+		return fail(record, cp, fmt.Errorf("received %d bodies, requested %d", len(*bodies), len(hashes)))
+	}
+	blocks := make(types.Blocks, len(hashes))
+	for i, body := range *bodies {
+		header := (*headers)[i]
+		if types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)) != header.TxHash ||
+			types.CalcUncleHash(body.Uncles) != header.UncleHash {
+			return fail(record, cp, fmt.Errorf("invalid body for block %d [%x]", header.Number, hashes[i]))
+		}
+		blocks[i] = types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles)
+	}
+	record(cp)
+
+	// Receipts
+	cp = Checkpoint{Label: "receipts"}
+	if packet, err = request(func() error { return clientPeer.RequestReceipts(hashes) }); err != nil {
+		return fail(record, cp, err)
+	}
+	receipts, ok := packet.(*eth.ReceiptsPacket)
+	if !ok {
+		return fail(record, cp, fmt.Errorf("%w: %s", errUnexpectedPacket, packet.Name()))
+	}
+	cp.ReturnData = rlpHash(receipts)
+	if len(*receipts) != len(hashes) {
+		return fail(record, cp, fmt.Errorf("received %d receipts, requested %d", len(*receipts), len(hashes)))
+	}
+	for i, r := range *receipts {
+		header := (*headers)[i]
+		if types.DeriveSha(types.Receipts(r), trie.NewStackTrie(nil)) != header.ReceiptHash {
+			return fail(record, cp, fmt.Errorf("invalid receipts for block %d [%x]", header.Number, hashes[i]))
+		}
+	}
+	record(cp)
+
+	// Import
+	cp = Checkpoint{Label: "import"}
+	if n, err := client.InsertChain(blocks); err != nil {
+		return fail(record, cp, fmt.Errorf("block %d rejected: %v", n+1, err))
+	}
+	cp.StateRoot = client.CurrentBlock().Root()
+	cp.GasUsed = client.CurrentBlock().GasUsed()
+	record(cp)
+	return nil
+}
+
+// newBlockChain creates a blockchain holding the genesis block of the chain.
+func (w *P2PWorkload) newBlockChain() (*core.BlockChain, error) {
+	db := rawdb.NewMemoryDatabase()
+	w.chain.genesis.MustCommit(db)
+	return core.NewBlockChain(db, nil, w.chain.config, w.chain.engine, vm.Config{}, nil, nil)
+}
+
+// newP2PPeer creates an eth peer running on rw with the remote node id.
+func newP2PPeer(rw p2p.MsgReadWriter, id enode.ID) *eth.Peer {
+	caps := []p2p.Cap{{Name: eth.ProtocolName, Version: eth.ETH66}}
+	return eth.NewPeer(eth.ETH66, p2p.NewPeer(id, id.TerminalString(), caps), rw, emptyTxPool{})
+}
+
+// handshake runs the eth handshake of peer with the head of chain.
+func handshake(peer *eth.Peer, chain *core.BlockChain) error {
+	head := chain.CurrentHeader()
+	genesis := chain.Genesis().Hash()
+	return peer.Handshake(p2pNetwork, chain.GetTd(head.Hash(), head.Number.Uint64()), head.Hash(), genesis,
+		forkid.NewID(chain.Config(), genesis, head.Number.Uint64()), forkid.NewFilter(chain))
+}
+
+// fail records cp with err and returns err.
+func fail(record func(Checkpoint), cp Checkpoint, err error) error {
+	cp.Err = err.Error()
+	record(cp)
+	return err
+}
+
+// rlpHash hashes the RLP encoding of v.
+func rlpHash(v interface{}) []byte {
+	enc, _ := rlp.EncodeToBytes(v)
+	return crypto.Keccak256(enc)
+}
+
+// serverBackend serves a chain to the client. It makes no requests, so any
+// packet handed to it is unexpected.
+type serverBackend struct {
+	chain *core.BlockChain
+}
+
+func (b *serverBackend) Chain() *core.BlockChain                           { return b.chain }
+func (b *serverBackend) StateBloom() *trie.SyncBloom                       { return nil }
+func (b *serverBackend) TxPool() eth.TxPool                                { return emptyTxPool{} }
+func (b *serverBackend) AcceptTxs() bool                                   { return false }
+func (b *serverBackend) PeerInfo(id enode.ID) interface{}                  { return nil }
+func (b *serverBackend) RunPeer(peer *eth.Peer, handler eth.Handler) error { return handler(peer) }
+
+func (b *serverBackend) Handle(peer *eth.Peer, packet eth.Packet) error {
+	return fmt.Errorf("%w: %s", errUnexpectedPacket, packet.Name())
+}
+
+// clientBackend hands the responses the client receives to the workload
+// until it is done.
+type clientBackend struct {
+	serverBackend
+	packets chan eth.Packet
+	done    chan struct{}
+}
+
+func (b *clientBackend) Handle(peer *eth.Peer, packet eth.Packet) error {
+	switch packet.(type) {
+	case *eth.BlockHeadersPacket, *eth.BlockBodiesPacket, *eth.ReceiptsPacket:
+		select {
+		case b.packets <- packet:
+			return nil
+		case <-b.done:
+			return p2p.DiscQuitting
+		}
+	}
+	return fmt.Errorf("%w: %s", errUnexpectedPacket, packet.Name())
+}
+
+// emptyTxPool is a transaction pool without transactions.
+type emptyTxPool struct{}
+
+func (emptyTxPool) Get(hash common.Hash) *types.Transaction { return nil }
@@ -0,0 +1,83 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// Session gives the process a configuration file of its own, in a temporary
+// directory, so that a tool can switch injection on and off without touching
+// the configuration of the campaign the user has in place. An interrupted
+// session leaves that configuration as it was.
+//
+// Sessions nest: closing one makes the process use the file it used before.
+type Session struct {
+	cfg      config.Config
+	dir      string
+	previous string
+}
+
+// NewSession starts a session injecting with cfg, disabled until Inject is
+// called. The sinks and posting of cfg are dropped: the flips of a session
+// belong to the tool, which records them with injection.AddSink, and not on
+// standard output, in the campaign's files or at its results server.
+func NewSession(cfg config.Config) (*Session, error) {
+	dir, err := os.MkdirTemp("", "flipconfig")
+	if err != nil {
+		return nil, err
+	}
+	cfg.Sinks = []config.SinkConfig{}
+	cfg.Server.Post = false
+	s := &Session{cfg: cfg, dir: dir, previous: config.Path()}
+	config.SetPath(filepath.Join(dir, "flipconfig.json"))
+	if err := s.Disable(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Disable turns injection off.
+func (s *Session) Disable() error {
+	disabled := s.cfg
+	disabled.Start = false
+	return disabled.WriteConfig()
+}
+
+// Inject turns injection on, restarting the campaign from its first error
+// rate. A non-zero seed replaces the configured one.
+func (s *Session) Inject(seed int64) error {
+	faulty := s.cfg
+	faulty.Initialized = true
+	faulty.Start = true
+	faulty.Restart = true
+	if seed != 0 {
+		faulty.Seed = seed
+	}
+	return faulty.WriteConfig()
+}
+
+// Close ends the session, switching the process back to the configuration
+// file it used before.
+func (s *Session) Close() error {
+	config.SetPath(s.previous)
+	return os.RemoveAll(s.dir)
+}
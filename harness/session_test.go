@@ -0,0 +1,71 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// A session injects with a file of its own, without the configured sinks or
+// posting, and gives the previous file back when closed.
+func TestSession(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.Sinks = []config.SinkConfig{{Type: "stdout"}, {Type: "jsonl", Path: "flips.jsonl"}}
+	cfg.Server.Post = true
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	previous := config.Path()
+
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Path() == previous {
+		t.Fatal("session reads the configuration in place")
+	}
+	if err := s.Inject(7); err != nil {
+		t.Fatal(err)
+	}
+	faulty, err := config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !faulty.Start || faulty.Seed != 7 {
+		t.Errorf("injecting session: start %v seed %d, want true 7", faulty.Start, faulty.Seed)
+	}
+	if faulty.Sinks == nil || len(faulty.Sinks) != 0 || faulty.Server.Post {
+		t.Errorf("session sinks %v posting %v, want none", faulty.Sinks, faulty.Server.Post)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Path() != previous {
+		t.Errorf("closed session left the path at %s, want %s", config.Path(), previous)
+	}
+	kept, err := config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept.Sinks) != 2 || !kept.Server.Post {
+		t.Errorf("configuration in place changed: sinks %v posting %v", kept.Sinks, kept.Server.Post)
+	}
+}
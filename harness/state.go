@@ -0,0 +1,102 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/griffindavis02/eth-bit-flip/statedb"
+)
+
+// stateSlot is the storage slot every account of the state workload counts
+// its transfers in.
+var stateSlot = common.Hash{}
+
+// StateWorkload moves value around a ring of accounts on an in-memory StateDB
+// wrapped by statedb.New, one checkpoint per round. In every round each
+// account sends half of its balance to the next, bumps its nonce, counts the
+// transfer in storage and has its code read. The transfer is checked and made
+// with core.CanTransfer and core.Transfer, which read the balance again; a
+// transfer larger than the balance fails the run. The checkpoint holds the
+// intermediate state root and a hash of the values read.
+type StateWorkload struct {
+	Accounts int
+	Rounds   int
+}
+
+// NewStateWorkload creates a workload of n accounts and rounds rounds.
+func NewStateWorkload(n, rounds int) *StateWorkload {
+	return &StateWorkload{Accounts: n, Rounds: rounds}
+}
+
+// Name describes the workload.
+func (w *StateWorkload) Name() string {
+	return fmt.Sprintf("state/%dx%d", w.Accounts, w.Rounds)
+}
+
+// Run sets the accounts up and runs the rounds.
+func (w *StateWorkload) Run(record func(Checkpoint)) error {
+	db, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		return err
+	}
+	// Only the rounds are injected into
+	for i := 0; i < w.Accounts; i++ {
+		addr := stateAccount(i)
+		db.SetBalance(addr, new(big.Int).Mul(big.NewInt(int64(i+1)), big.NewInt(1e18)))
+		db.SetCode(addr, counterCode)
+	}
+	s := statedb.New(db)
+
+	for round := 0; round < w.Rounds; round++ {
+		cp := Checkpoint{Label: fmt.Sprintf("round %d", round)}
+		hasher := crypto.NewKeccakState()
+		for i := 0; i < w.Accounts; i++ {
+			from, to := stateAccount(i), stateAccount((i+1)%w.Accounts)
+			balance := s.GetBalance(from)
+			hasher.Write(balance.Bytes())
+			amount := new(big.Int).Div(balance, big.NewInt(2))
+			if !core.CanTransfer(s, from, amount) {
+				err := fmt.Errorf("insufficient funds for transfer: address %v", from)
+				cp.Err = err.Error()
+				record(cp)
+				return err
+			}
+			core.Transfer(s, from, to, amount)
+			s.SetNonce(from, s.GetNonce(from)+1)
+			count := s.GetState(from, stateSlot)
+			s.SetState(from, stateSlot, common.BigToHash(new(big.Int).Add(count.Big(), common.Big1)))
+			hasher.Write(count[:])
+			hasher.Write(s.GetCode(from))
+		}
+		cp.ReturnData = hasher.Sum(nil)
+		cp.StateRoot = s.IntermediateRoot(true)
+		record(cp)
+	}
+	return nil
+}
+
+// stateAccount returns the address of account i.
+func stateAccount(i int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(0x20000 + i)))
+}
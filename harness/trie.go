@@ -0,0 +1,146 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package harness
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/griffindavis02/eth-bit-flip/database"
+)
+
+// trieSlots is the number of storage slots of every account with storage.
+const trieSlots = 4
+
+// TrieWorkload builds a state trie on a database.TrieStore and resolves it
+// again, to see whether corrupted trie nodes are detected or silently used. It
+// has three checkpoints:
+//
+//   - commit: the state root of the accounts committed to the database
+//   - resolve: a hash of every account and storage value, read back through a
+//     trie database with no caches so every node is resolved from the store
+//   - update: the state root after changing every account, which rehashes the
+//     resolved nodes along the way
+//
+// With Validate every resolved node is checked against its hash, so a
+// corrupted node is reported missing and the run fails. go-ethereum 1.10.13
+// keeps errors resolving a storage trie in the state object rather than the
+// StateDB, so a storage node failing the check reads as zero and the run is
+// still sdc.
+type TrieWorkload struct {
+	Validate bool
+	Accounts int
+}
+
+// NewTrieWorkload creates a workload of n accounts, every fourth of which has
+// storage.
+func NewTrieWorkload(n int) *TrieWorkload {
+	return &TrieWorkload{Accounts: n}
+}
+
+// Name describes the workload.
+func (w *TrieWorkload) Name() string {
+	return fmt.Sprintf("trie/%d", w.Accounts)
+}
+
+// Run builds the trie in a fresh database and resolves it.
+func (w *TrieWorkload) Run(record func(Checkpoint)) error {
+	store := database.WrapTrie(memorydb.New())
+	store.Verify = w.Validate
+	db := rawdb.NewDatabase(store)
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(db), nil)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < w.Accounts; i++ {
+		addr, slots := trieAccount(i)
+		statedb.SetBalance(addr, big.NewInt(int64(i+1)*1e9))
+		statedb.SetNonce(addr, uint64(i))
+		for _, slot := range slots {
+			statedb.SetState(addr, slot, crypto.Keccak256Hash(addr[:], slot[:]))
+		}
+	}
+	root, err := statedb.Commit(true)
+	if err != nil {
+		return err
+	}
+	if err := statedb.Database().TrieDB().Commit(root, false, nil); err != nil {
+		return err
+	}
+	record(Checkpoint{Label: "commit", StateRoot: root})
+
+	// Resolve every node from the store again
+	cp := Checkpoint{Label: "resolve"}
+	if statedb, err = state.New(root, state.NewDatabase(db), nil); err != nil {
+		cp.Err = err.Error()
+		record(cp)
+		return err
+	}
+	hasher := crypto.NewKeccakState()
+	for i := 0; i < w.Accounts; i++ {
+		addr, slots := trieAccount(i)
+		hasher.Write(statedb.GetBalance(addr).Bytes())
+		hasher.Write(new(big.Int).SetUint64(statedb.GetNonce(addr)).Bytes())
+		for _, slot := range slots {
+			value := statedb.GetState(addr, slot)
+			hasher.Write(value[:])
+		}
+	}
+	cp.ReturnData = hasher.Sum(nil)
+	if err := statedb.Error(); err != nil {
+		cp.Err = err.Error()
+		record(cp)
+		return err
+	}
+	record(cp)
+
+	cp = Checkpoint{Label: "update"}
+	for i := 0; i < w.Accounts; i++ {
+		addr, slots := trieAccount(i)
+		statedb.SetNonce(addr, statedb.GetNonce(addr)+1)
+		for _, slot := range slots {
+			statedb.SetState(addr, slot, common.Hash{})
+		}
+	}
+	cp.StateRoot = statedb.IntermediateRoot(true)
+	if err := statedb.Error(); err != nil {
+		cp.Err = err.Error()
+		record(cp)
+		return err
+	}
+	record(cp)
+	return nil
+}
+
+// trieAccount returns the address of account i and its storage slots.
+func trieAccount(i int) (common.Address, []common.Hash) {
+	addr := common.BigToAddress(big.NewInt(int64(0x10000 + i)))
+	if i%4 != 0 {
+		return addr, nil
+	}
+	slots := make([]common.Hash, trieSlots)
+	for j := range slots {
+		slots[j] = common.BigToHash(big.NewInt(int64(j)))
+	}
+	return addr, slots
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package hashing corrupts Keccak256 digests as they leave the hashing helpers
+// of go-ethereum, where a single flipped bit changes the identity of a block,
+// transaction, account or trie node.
+//
+// go-ethereum's crypto package cannot import this one, so patch.py adds a
+// crypto.DigestHook variable to it, calls the hook from crypto.Keccak256,
+// crypto.Keccak256Hash, the trie hasher and the rlpHash of core/types, and
+// has geth install a Hasher's Corrupt as the hook at startup.
+package hashing
+
+import (
+	"fmt"
+	"hash"
+	"runtime"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+	"golang.org/x/crypto/sha3"
+)
+
+// DigestSite is the site of the hook, to be named in the configured sites.
+const DigestSite = "hash.digest"
+
+// Caller categories, by the go-ethereum package that asked for the hash.
+const (
+	Trie      = "trie"      // trie node hashes, trie
+	Types     = "types"     // block, header, transaction and receipt hashes, core/types
+	State     = "state"     // account and storage keys and code hashes, core/state
+	Consensus = "consensus" // seal hashes and signers, consensus
+	P2P       = "p2p"       // node identities and handshakes, p2p
+	Other     = "other"
+)
+
+// packages maps go-ethereum package paths to their category. A path also
+// matches its subpackages.
+var packages = []struct {
+	path     string
+	category string
+}{
+	{"github.com/ethereum/go-ethereum/trie", Trie},
+	{"github.com/ethereum/go-ethereum/core/types", Types},
+	{"github.com/ethereum/go-ethereum/core/state", State},
+	{"github.com/ethereum/go-ethereum/consensus", Consensus},
+	{"github.com/ethereum/go-ethereum/p2p", P2P},
+}
+
+// helpers are the functions, by prefix, that compute a digest for somebody
+// else. They are skipped when looking for the call site.
+var helpers = []string{
+	"github.com/griffindavis02/eth-bit-flip/hashing.",
+	"github.com/ethereum/go-ethereum/crypto.",
+	"github.com/ethereum/go-ethereum/trie.(*hasher).",
+	"github.com/ethereum/go-ethereum/core/types.rlpHash",
+	"github.com/ethereum/go-ethereum/core/types.prefixedRlpHash",
+}
+
+func init() {
+	// Attribute digest flips in the crash file to the code that asked for
+	// the hash, as the records do
+	injection.SkipCallers(helpers...)
+}
+
+// CallSite is the function that asked for a hash and where it did so.
+type CallSite struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Caller returns the call site of the hash being computed by the calling
+// helper, skipping the helpers on the stack.
+func Caller() CallSite {
+	var stack [32]uintptr
+	frames := runtime.CallersFrames(stack[:runtime.Callers(2, stack[:])])
+	for {
+		frame, more := frames.Next()
+		if !isHelper(frame.Function) {
+			return CallSite{Function: frame.Function, File: frame.File, Line: frame.Line}
+		}
+		if !more {
+			return CallSite{Function: "unknown"}
+		}
+	}
+}
+
+func isHelper(function string) bool {
+	for _, prefix := range helpers {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Category returns the category of the call site.
+func (c CallSite) Category() string {
+	path := packagePath(c.Function)
+	for _, p := range packages {
+		if path == p.path || strings.HasPrefix(path, p.path+"/") {
+			return p.category
+		}
+	}
+	return Other
+}
+
+// String returns the call site as function (file:line).
+func (c CallSite) String() string {
+	return fmt.Sprintf("%s (%s:%d)", c.Function, c.File, c.Line)
+}
+
+// packagePath strips the receiver and function names from the fully qualified
+// name of a function.
+func packagePath(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
+
+// Hasher runs digests through BitFlip at DigestSite, if their call site is of
+// a selected category. Every record carries the call site, its category and
+// the length of the hashed input in its context.
+//
+// The categories are read from the configuration when the hasher is created.
+// A nil Hasher hashes without corrupting.
+type Hasher struct {
+	categories map[string]bool
+}
+
+// New creates a hasher with the configured categories, or returns nil if hash
+// injection is not enabled.
+func New() *Hasher {
+	cfg, err := config.ReadConfig()
+	if err != nil || !cfg.Hash.Enabled {
+		return nil
+	}
+	h := &Hasher{}
+	if len(cfg.Hash.Categories) > 0 {
+		h.categories = make(map[string]bool)
+		for _, category := range cfg.Hash.Categories {
+			h.categories[strings.ToLower(category)] = true
+		}
+	}
+	return h
+}
+
+// Corrupt corrupts digest in place, a hash of length bytes of input. Its
+// signature is that of crypto.DigestHook.
+func (h *Hasher) Corrupt(digest []byte, length int) {
+	if h == nil || len(digest) == 0 {
+		return
+	}
+	caller := Caller()
+	category := caller.Category()
+	if h.categories != nil && !h.categories[category] {
+		return
+	}
+	injection.BitFlip(digest, DigestSite, injection.Context{
+		"call_site":    caller.String(),
+		"category":     category,
+		"input_length": length,
+	})
+}
+
+// Keccak256 is crypto.Keccak256 with its digest corrupted, for callers that
+// are not patched. It hashes with sha3 itself, since in a patched geth
+// crypto.Keccak256 already calls the hook and would corrupt the digest twice.
+func (h *Hasher) Keccak256(data ...[]byte) []byte {
+	digest := keccak256(data).Sum(nil)
+	h.Corrupt(digest, inputLength(data))
+	return digest
+}
+
+// Keccak256Hash is crypto.Keccak256Hash with its digest corrupted, for
+// callers that are not patched.
+func (h *Hasher) Keccak256Hash(data ...[]byte) common.Hash {
+	var digest common.Hash
+	keccak256(data).Sum(digest[:0])
+	h.Corrupt(digest[:], inputLength(data))
+	return digest
+}
+
+func keccak256(data [][]byte) hash.Hash {
+	d := sha3.NewLegacyKeccak256()
+	for _, b := range data {
+		d.Write(b)
+	}
+	return d
+}
+
+func inputLength(data [][]byte) int {
+	length := 0
+	for _, b := range data {
+		length += len(b)
+	}
+	return length
+}
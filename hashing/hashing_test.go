@@ -0,0 +1,76 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package hashing
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// testHasher writes a config flipping every digest and returns its hasher.
+func testHasher(t *testing.T) *Hasher {
+	t.Helper()
+	cfg := configtest.New(t, 1)
+	cfg.Hash.Enabled = true
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	h := New()
+	if h == nil {
+		t.Fatal("hasher disabled")
+	}
+	return h
+}
+
+// flipped counts the bits that differ between a and b.
+func flipped(a, b []byte) int {
+	count := 0
+	for i := range a {
+		count += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return count
+}
+
+// TestKeccak256 checks the digest is corrupted once: at rate 1 every bit is
+// inverted, and corrupting it again would give back the real digest.
+func TestKeccak256(t *testing.T) {
+	h := testHasher(t)
+	data := [][]byte{[]byte("eth"), []byte("-bit-flip")}
+
+	want := crypto.Keccak256(data...)
+	if got := h.Keccak256(data...); flipped(got, want) != 256 {
+		t.Errorf("Keccak256 flipped %d bits of %x, want 256: %x", flipped(got, want), want, got)
+	}
+	wantHash := crypto.Keccak256Hash(data...)
+	if got := h.Keccak256Hash(data...); flipped(got[:], wantHash[:]) != 256 {
+		t.Errorf("Keccak256Hash flipped %d bits of %x, want 256: %x", flipped(got[:], wantHash[:]), wantHash, got)
+	}
+}
+
+func TestKeccak256Disabled(t *testing.T) {
+	var h *Hasher
+	data := []byte("eth-bit-flip")
+	if got, want := h.Keccak256(data), crypto.Keccak256(data); flipped(got, want) != 0 {
+		t.Errorf("nil hasher corrupted %x to %x", want, got)
+	}
+	if got, want := h.Keccak256Hash(data), crypto.Keccak256Hash(data); got != want {
+		t.Errorf("nil hasher corrupted %x to %x", want, got)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package header
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Consensus engines a chain can be built for.
+const (
+	Ethash = "ethash" // ethash in fake mode, which accepts any seal
+	Clique = "clique" // clique with a single signer
+)
+
+// Engines lists the engines a chain can be built for.
+var Engines = []string{Ethash, Clique}
+
+var (
+	// signerKey funds the transactions of the chains and signs the clique
+	// blocks.
+	signerKey, _ = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+	signerAddr   = crypto.PubkeyToAddress(signerKey.PublicKey)
+
+	// diffInTurn is the difficulty of a block sealed by the in-turn signer.
+	diffInTurn = big.NewInt(2)
+)
+
+// Chain is a chain of blocks imported into a core.BlockChain, whose headers
+// corrupted headers are verified against.
+type Chain struct {
+	Name   string
+	Engine consensus.Engine
+	Chain  *core.BlockChain
+	Blocks []*types.Block
+}
+
+// NewChain builds a chain of n blocks for the engine called name, every block
+// holding a transfer. Call it while injection is disabled so the chain is
+// fault free.
+func NewChain(name string, n int) (*Chain, error) {
+	var (
+		config  *params.ChainConfig
+		engine  consensus.Engine
+		db      = rawdb.NewMemoryDatabase()
+		genesis = &core.Genesis{
+			Alloc:   core.GenesisAlloc{signerAddr: {Balance: new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+	)
+	switch name {
+	case Ethash:
+		config = params.TestChainConfig
+		engine = ethash.NewFaker()
+	case Clique:
+		config = params.AllCliqueProtocolChanges
+		engine = clique.New(config.Clique, db)
+		// Vanity, the only signer and an empty seal
+		genesis.ExtraData = make([]byte, 32+common.AddressLength+crypto.SignatureLength)
+		copy(genesis.ExtraData[32:], signerAddr[:])
+	default:
+		return nil, fmt.Errorf("unknown consensus engine \"%s\"", name)
+	}
+	genesis.Config = config
+	signer := types.LatestSigner(config)
+
+	blocks, _ := core.GenerateChain(config, genesis.MustCommit(db), engine, db, n, func(i int, gen *core.BlockGen) {
+		if name == Clique {
+			gen.SetDifficulty(diffInTurn)
+		}
+		// No tip, as clique pays it to the signer of the block, which is
+		// only known once the block is sealed
+		to := common.BigToAddress(big.NewInt(int64(0x40000 + i)))
+		gen.AddTx(types.MustSignNewTx(signerKey, signer, &types.DynamicFeeTx{
+			ChainID: config.ChainID, Nonce: gen.TxNonce(signerAddr), GasTipCap: common.Big0,
+			GasFeeCap: new(big.Int).Mul(gen.BaseFee(), big.NewInt(2)), Gas: params.TxGas, To: &to, Value: big.NewInt(params.GWei),
+		}))
+	})
+	if name == Clique {
+		// Seal the blocks, which changes their hashes and so their children
+		for i, block := range blocks {
+			header := block.Header()
+			if i > 0 {
+				header.ParentHash = blocks[i-1].Hash()
+			}
+			header.Extra = make([]byte, 32+crypto.SignatureLength)
+			header.Difficulty = diffInTurn
+			sig, err := crypto.Sign(clique.SealHash(header).Bytes(), signerKey)
+			if err != nil {
+				return nil, err
+			}
+			copy(header.Extra[32:], sig)
+			blocks[i] = block.WithSeal(header)
+		}
+	}
+
+	chaindb := rawdb.NewMemoryDatabase()
+	genesis.MustCommit(chaindb)
+	if name == Clique {
+		engine = clique.New(config.Clique, chaindb)
+	}
+	chain, err := core.NewBlockChain(chaindb, nil, config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		chain.Stop()
+		return nil, fmt.Errorf("block %d rejected: %v", n+1, err)
+	}
+	return &Chain{Name: name, Engine: engine, Chain: chain, Blocks: blocks}, nil
+}
+
+// Verify corrupts the field of site in the header of every block of the chain
+// and verifies it, returning the records of the fields that changed.
+func (c *Chain) Verify(site string) []*Record {
+	var records []*Record
+	for _, block := range c.Blocks {
+		if r := Verify(c.Name, c.Engine, c.Chain, block.Header(), site); r != nil {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Stop stops the blockchain.
+func (c *Chain) Stop() {
+	c.Chain.Stop()
+}
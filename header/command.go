@@ -0,0 +1,201 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package header
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Cell counts the corrupted headers of a field and engine, and the fraction
+// of them verification detected.
+type Cell struct {
+	Corrupted int     `json:"corrupted"`
+	Detected  int     `json:"detected"`
+	Rate      float64 `json:"rate"`
+}
+
+// Row holds the cells of a field, by engine.
+type Row struct {
+	Site  string           `json:"site"`
+	Cells map[string]*Cell `json:"cells"`
+}
+
+// Matrix is the detection matrix of header fields against consensus engines.
+type Matrix struct {
+	Engines []string `json:"engines"`
+	Rows    []*Row   `json:"rows"`
+}
+
+// NewMatrix tabulates records for engines, with a row for every field in
+// Fields that was corrupted.
+func NewMatrix(engines []string, records []*Record) *Matrix {
+	rows := make(map[string]*Row)
+	for _, r := range records {
+		row := rows[r.Site]
+		if row == nil {
+			row = &Row{Site: r.Site, Cells: make(map[string]*Cell)}
+			rows[r.Site] = row
+		}
+		cell := row.Cells[r.Engine]
+		if cell == nil {
+			cell = &Cell{}
+			row.Cells[r.Engine] = cell
+		}
+		cell.Corrupted++
+		if r.Outcome == Detected {
+			cell.Detected++
+		}
+	}
+	m := &Matrix{Engines: engines}
+	for _, site := range Fields {
+		if row := rows[site]; row != nil {
+			for _, cell := range row.Cells {
+				cell.Rate = float64(cell.Detected) / float64(cell.Corrupted)
+			}
+			m.Rows = append(m.Rows, row)
+		}
+	}
+	return m
+}
+
+// WriteTable writes m as a table of detected over corrupted headers per field
+// and engine.
+func (m *Matrix) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "Site\t")
+	for _, engine := range m.Engines {
+		fmt.Fprintf(tw, "%s\t\t", engine)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range m.Rows {
+		fmt.Fprintf(tw, "%s\t", row.Site)
+		for _, engine := range m.Engines {
+			if cell := row.Cells[engine]; cell != nil {
+				fmt.Fprintf(tw, "%d/%d\t%.3f\t", cell.Detected, cell.Corrupted, cell.Rate)
+			} else {
+				fmt.Fprint(tw, "-\t-\t")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+var (
+	enginesFlag = cli.StringFlag{
+		Name:  "engines",
+		Usage: "Comma separated consensus engines to verify with: ethash, clique",
+		Value: strings.Join(Engines, ","),
+	}
+	blocksFlag = cli.IntFlag{
+		Name:  "blocks",
+		Usage: "Number of blocks in the chain of each engine",
+		Value: 16,
+	}
+	runsFlag = cli.IntFlag{
+		Name:  "runs",
+		Usage: "Number of times to corrupt every field of every header",
+		Value: 10,
+	}
+	configFlag = cli.StringFlag{
+		Name:  "config",
+		Usage: "Configuration to inject with (default: the current configuration)",
+	}
+	recordsFlag = cli.StringFlag{
+		Name:  "records",
+		Usage: "File to write every corrupted header to as JSON lines",
+	}
+	formatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: table or json",
+		Value: "table",
+	}
+
+	// HeaderCommand is the 'flipcfg header' subcommand.
+	HeaderCommand = cli.Command{
+		Action: detect,
+		Name:   "header",
+		Usage:  "Corrupt block header fields and verify them with consensus engines",
+		Flags:  []cli.Flag{enginesFlag, blocksFlag, runsFlag, configFlag, recordsFlag, formatFlag},
+		Description: `
+Builds a chain of --blocks blocks for each engine, ethash in fake mode and
+clique with a single signer, and --runs times corrupts the header of every
+block one field at a time with the configuration. Every corrupted header is
+run through the engine's VerifyHeader, seal included, against the imported
+chain and counts as detected if it fails verification.
+
+The configured sites select the fields, header.parent_hash to header.base_fee;
+with none every field is corrupted. The result is a matrix of the fraction of
+corrupted headers detected per field and engine.`,
+	}
+)
+
+func detect(ctx *cli.Context) error {
+	exp, err := harness.NewExperiment(ctx, configFlag.Name, recordsFlag.Name, formatFlag.Name)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	var (
+		chains  []*Chain
+		records []*Record
+	)
+	defer func() {
+		for _, chain := range chains {
+			chain.Stop()
+		}
+	}()
+	build := func() error {
+		for _, engine := range strings.Split(ctx.String(enginesFlag.Name), ",") {
+			chain, err := NewChain(strings.TrimSpace(engine), ctx.Int(blocksFlag.Name))
+			if err != nil {
+				return err
+			}
+			chains = append(chains, chain)
+		}
+		return nil
+	}
+	inject := func(emit func(interface{}) error) error {
+		for run := 0; run < ctx.Int(runsFlag.Name); run++ {
+			for _, chain := range chains {
+				for _, site := range Fields {
+					for _, r := range chain.Verify(site) {
+						records = append(records, r)
+						if err := emit(r); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := exp.Run(build, inject); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	names := make([]string, len(chains))
+	for i, chain := range chains {
+		names[i] = chain.Name
+	}
+	matrix := NewMatrix(names, records)
+	return exp.Write(matrix, matrix.WriteTable)
+}
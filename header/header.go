@@ -0,0 +1,157 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package header corrupts single fields of block headers and runs them
+// through the VerifyHeader of a consensus engine, to see which faults header
+// verification alone detects.
+package header
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the header fields, to be named in the configured sites.
+const (
+	ParentHashSite  = "header.parent_hash"
+	UncleHashSite   = "header.uncle_hash"
+	CoinbaseSite    = "header.coinbase"
+	RootSite        = "header.root"
+	TxHashSite      = "header.tx_hash"
+	ReceiptHashSite = "header.receipt_hash"
+	BloomSite       = "header.bloom"
+	DifficultySite  = "header.difficulty"
+	NumberSite      = "header.number"
+	GasLimitSite    = "header.gas_limit"
+	GasUsedSite     = "header.gas_used"
+	TimeSite        = "header.time"
+	ExtraSite       = "header.extra"
+	MixDigestSite   = "header.mix_digest"
+	NonceSite       = "header.nonce"
+	BaseFeeSite     = "header.base_fee"
+)
+
+// Fields lists the sites of every header field, in the order of the header.
+var Fields = []string{
+	ParentHashSite, UncleHashSite, CoinbaseSite, RootSite, TxHashSite, ReceiptHashSite, BloomSite,
+	DifficultySite, NumberSite, GasLimitSite, GasUsedSite, TimeSite, ExtraSite, MixDigestSite,
+	NonceSite, BaseFeeSite,
+}
+
+// Outcome classifies a corrupted header.
+type Outcome string
+
+const (
+	// Detected headers fail verification.
+	Detected Outcome = "detected"
+	// Undetected headers pass verification.
+	Undetected Outcome = "undetected"
+)
+
+// Record is the outcome of verifying a header corrupted at a single site.
+type Record struct {
+	Engine  string      `json:"engine"`
+	Number  uint64      `json:"number"`
+	Hash    common.Hash `json:"hash"` // of the header before it was corrupted
+	Site    string      `json:"site"`
+	Outcome Outcome     `json:"outcome"`
+	Err     string      `json:"error,omitempty"`
+}
+
+// Corrupt returns a copy of header with the field of site run through
+// BitFlip, and whether the field changed. Numbers are corrupted as they are
+// encoded, without leading zeros. Every flip carries the block number and
+// hash in its context.
+func Corrupt(header *types.Header, site string) (*types.Header, bool) {
+	var (
+		h       = types.CopyHeader(header)
+		ctx     = injection.Context{"number": header.Number.Uint64(), "hash": header.Hash().Hex()}
+		changed bool
+	)
+	flipBytes := func(b []byte) {
+		before := string(b)
+		injection.BitFlip(b, site, ctx)
+		changed = string(b) != before
+	}
+	flipUint64 := func(v *uint64) {
+		flipped := injection.BitFlip(*v, site, ctx).(uint64)
+		changed, *v = flipped != *v, flipped
+	}
+	flipBig := func(v **big.Int) {
+		if *v == nil {
+			return
+		}
+		flipped := injection.BitFlip(new(big.Int).Set(*v), site, ctx).(*big.Int)
+		changed, *v = flipped.Cmp(*v) != 0, flipped
+	}
+
+	switch site {
+	case ParentHashSite:
+		flipBytes(h.ParentHash[:])
+	case UncleHashSite:
+		flipBytes(h.UncleHash[:])
+	case CoinbaseSite:
+		flipBytes(h.Coinbase[:])
+	case RootSite:
+		flipBytes(h.Root[:])
+	case TxHashSite:
+		flipBytes(h.TxHash[:])
+	case ReceiptHashSite:
+		flipBytes(h.ReceiptHash[:])
+	case BloomSite:
+		flipBytes(h.Bloom[:])
+	case DifficultySite:
+		flipBig(&h.Difficulty)
+	case NumberSite:
+		flipBig(&h.Number)
+	case GasLimitSite:
+		flipUint64(&h.GasLimit)
+	case GasUsedSite:
+		flipUint64(&h.GasUsed)
+	case TimeSite:
+		flipUint64(&h.Time)
+	case ExtraSite:
+		if len(h.Extra) > 0 {
+			flipBytes(h.Extra)
+		}
+	case MixDigestSite:
+		flipBytes(h.MixDigest[:])
+	case NonceSite:
+		flipBytes(h.Nonce[:])
+	case BaseFeeSite:
+		flipBig(&h.BaseFee)
+	}
+	return h, changed
+}
+
+// Verify corrupts the field of site in header and verifies the result, seal
+// included, with engine against the headers of chain. It returns nil if the
+// field was left unchanged.
+func Verify(name string, engine consensus.Engine, chain consensus.ChainHeaderReader, header *types.Header, site string) *Record {
+	corrupted, changed := Corrupt(header, site)
+	if !changed {
+		return nil
+	}
+	r := &Record{Engine: name, Number: header.Number.Uint64(), Hash: header.Hash(), Site: site, Outcome: Undetected}
+	if err := engine.VerifyHeader(chain, corrupted, true); err != nil {
+		r.Outcome, r.Err = Detected, err.Error()
+	}
+	return r
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package header
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// inject writes a configuration inverting every bit of the header fields and
+// returns a sink recording the flips.
+func inject(t *testing.T) *injection.RingSink {
+	t.Helper()
+	configtest.New(t, 1, Fields...)
+	ring := injection.NewRingSink(64)
+	injection.AddSink(ring)
+	t.Cleanup(func() { injection.RemoveSink(ring) })
+	return ring
+}
+
+// Each site corrupts its own field of a copy of the header.
+func TestCorrupt(t *testing.T) {
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x0f"),
+		Number:     big.NewInt(5),
+		Difficulty: big.NewInt(1),
+		GasLimit:   8000000,
+		Time:       10,
+	}
+	tests := []struct {
+		site    string
+		changed bool
+		check   func(h *types.Header) bool
+	}{
+		{ParentHashSite, true, func(h *types.Header) bool {
+			return h.ParentHash == common.HexToHash("0xfffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff0")
+		}},
+		{GasLimitSite, true, func(h *types.Header) bool { return h.GasLimit == ^uint64(8000000) }},
+		{TimeSite, true, func(h *types.Header) bool { return h.Time == ^uint64(10) }},
+		{NumberSite, true, func(h *types.Header) bool { return h.Number.Cmp(header.Number) != 0 }},
+		{ExtraSite, false, func(h *types.Header) bool { return len(h.Extra) == 0 }},  // nothing to corrupt
+		{BaseFeeSite, false, func(h *types.Header) bool { return h.BaseFee == nil }}, // before London
+	}
+	hash := header.Hash()
+	for _, test := range tests {
+		t.Run(test.site, func(t *testing.T) {
+			ring := inject(t)
+			corrupted, changed := Corrupt(header, test.site)
+			if changed != test.changed || !test.check(corrupted) {
+				t.Errorf("changed %v, want %v, to %+v", changed, test.changed, corrupted)
+			}
+			if header.Hash() != hash {
+				t.Error("header corrupted in place")
+			}
+			if test.changed && corrupted.Hash() == hash {
+				t.Error("corrupted header has the hash of the header")
+			}
+			records := ring.Records()
+			if !test.changed {
+				if len(records) != 0 {
+					t.Errorf("%d flips of an empty field", len(records))
+				}
+				return
+			}
+			want := injection.Context{"number": uint64(5), "hash": hash.Hex()}
+			if len(records) != 1 || records[0].ErrorData.Msg != test.site || records[0].ErrorData.Context.String() != want.String() {
+				t.Errorf("flips %+v, want one at %s with %v", records, test.site, want)
+			}
+		})
+	}
+}
+
+// The engines detect corrupted links to the parent, while ethash in fake mode
+// accepts any seal and clique requires an empty mix digest.
+func TestChainVerify(t *testing.T) {
+	tests := []struct {
+		engine string
+		site   string
+		want   Outcome
+	}{
+		{Ethash, ParentHashSite, Detected},
+		{Ethash, NumberSite, Detected},
+		{Ethash, GasLimitSite, Detected},
+		{Ethash, MixDigestSite, Undetected},
+		{Ethash, NonceSite, Undetected},
+		{Clique, ParentHashSite, Detected},
+		{Clique, MixDigestSite, Detected},
+		{Clique, ExtraSite, Detected},
+	}
+	chains := make(map[string]*Chain)
+	for _, engine := range Engines {
+		cfg := configtest.New(t, 1, Fields...)
+		cfg.Start = false
+		if err := cfg.WriteConfig(); err != nil {
+			t.Fatal(err)
+		}
+		chain, err := NewChain(engine, 2)
+		if err != nil {
+			t.Fatalf("%s: %v", engine, err)
+		}
+		defer chain.Stop()
+		chains[engine] = chain
+	}
+	for _, test := range tests {
+		t.Run(test.engine+"/"+test.site, func(t *testing.T) {
+			inject(t)
+			chain := chains[test.engine]
+			records := chain.Verify(test.site)
+			if len(records) != len(chain.Blocks) {
+				t.Fatalf("%d records, want one for each of the %d blocks", len(records), len(chain.Blocks))
+			}
+			for i, r := range records {
+				block := chain.Blocks[i]
+				if r.Engine != test.engine || r.Site != test.site || r.Number != block.NumberU64() || r.Hash != block.Hash() {
+					t.Errorf("record %+v of block %d", r, block.NumberU64())
+				}
+				if r.Outcome != test.want || (r.Err != "") != (test.want == Detected) {
+					t.Errorf("block %d %s with error %q, want %s", block.NumberU64(), r.Outcome, r.Err, test.want)
+				}
+			}
+		})
+	}
+}
+
+// Uncorrupted headers are neither verified nor recorded.
+func TestChainVerifyUnchanged(t *testing.T) {
+	cfg := configtest.New(t, 1, Fields...)
+	cfg.Start = false
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := NewChain(Ethash, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Stop()
+	if records := chain.Verify(ExtraSite); len(records) != 0 {
+		t.Errorf("%d records of empty extra data", len(records))
+	}
+}
+
+func TestNewChainUnknown(t *testing.T) {
+	if _, err := NewChain("pow", 1); err == nil {
+		t.Error("chain built for an unknown engine")
+	}
+}
+
+func TestNewMatrix(t *testing.T) {
+	records := []*Record{
+		{Engine: Ethash, Site: NonceSite, Outcome: Undetected},
+		{Engine: Ethash, Site: ParentHashSite, Outcome: Detected},
+		{Engine: Clique, Site: ParentHashSite, Outcome: Detected},
+		{Engine: Ethash, Site: ParentHashSite, Outcome: Undetected},
+		{Engine: Clique, Site: NonceSite, Outcome: Detected},
+	}
+	want := &Matrix{
+		Engines: Engines,
+		Rows: []*Row{ // in the order of Fields
+			{Site: ParentHashSite, Cells: map[string]*Cell{
+				Ethash: {Corrupted: 2, Detected: 1, Rate: 0.5},
+				Clique: {Corrupted: 1, Detected: 1, Rate: 1},
+			}},
+			{Site: NonceSite, Cells: map[string]*Cell{
+				Ethash: {Corrupted: 1, Detected: 0, Rate: 0},
+				Clique: {Corrupted: 1, Detected: 1, Rate: 1},
+			}},
+		},
+	}
+	if got := NewMatrix(Engines, records); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
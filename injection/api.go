@@ -0,0 +1,166 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// APIs returns the flip_ RPC namespace. Register it on a node with
+// stack.RegisterAPIs(injection.APIs()).
+func APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "flip",
+			Version:   "1.0",
+			Service:   NewPrivateFlipAPI(),
+			Public:    false,
+		},
+	}
+}
+
+// PrivateFlipAPI steers the soft error simulation of a running node. It edits
+// the same configuration file as 'geth flip', so both can be used together.
+type PrivateFlipAPI struct{}
+
+// NewPrivateFlipAPI creates the flip_ RPC service.
+func NewPrivateFlipAPI() *PrivateFlipAPI {
+	return &PrivateFlipAPI{}
+}
+
+// StartArgs optionally replaces the error rates and sites when starting.
+type StartArgs struct {
+	Rates []float64 `json:"rates"`
+	Sites []string  `json:"sites"`
+}
+
+// Start begins (or resumes) the simulation. A time campaign that was stopped
+// runs for its full duration from now.
+func (api *PrivateFlipAPI) Start(args *StartArgs) (Progress, error) {
+	return updateConfig(func(cfg *config.Config) error {
+		if args != nil {
+			if err := applyStartArgs(cfg, args); err != nil {
+				return err
+			}
+		}
+		if !cfg.Start {
+			cfg.State.StartTime = time.Now().Unix()
+		}
+		cfg.Start = true
+		cfg.Restart = false
+		return nil
+	})
+}
+
+// Stop halts the simulation, keeping the current counters.
+func (api *PrivateFlipAPI) Stop() (Progress, error) {
+	return updateConfig(func(cfg *config.Config) error {
+		cfg.Start = false
+		cfg.Restart = false
+		return nil
+	})
+}
+
+// Restart starts the simulation over, discarding the current counters on the
+// next flip.
+func (api *PrivateFlipAPI) Restart(args *StartArgs) (Progress, error) {
+	return updateConfig(func(cfg *config.Config) error {
+		if args != nil {
+			if err := applyStartArgs(cfg, args); err != nil {
+				return err
+			}
+		}
+		cfg.State.StartTime = time.Now().Unix()
+		cfg.Start = true
+		cfg.Restart = true
+		return nil
+	})
+}
+
+// Status reports the campaign progress.
+func (api *PrivateFlipAPI) Status() (Progress, error) {
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return Progress{}, err
+	}
+	return stats.progress(&cfg), nil
+}
+
+// SetRates replaces the error rates and rewinds to the first of them, which a
+// time campaign runs for its full duration from now.
+func (api *PrivateFlipAPI) SetRates(rates []float64) (Progress, error) {
+	return updateConfig(func(cfg *config.Config) error {
+		return applyStartArgs(cfg, &StartArgs{Rates: rates})
+	})
+}
+
+// SetSites limits flipping to the named sites. An empty list enables every
+// site.
+func (api *PrivateFlipAPI) SetSites(sites []string) (Progress, error) {
+	return updateConfig(func(cfg *config.Config) error {
+		cfg.Sites = append([]string{}, sites...)
+		return nil
+	})
+}
+
+// Recent returns the last n flips made by this node, or all of the ones kept
+// in memory if n is omitted.
+func (api *PrivateFlipAPI) Recent(n *int) []Iteration {
+	if n == nil {
+		return stats.last(0)
+	}
+	return stats.last(*n)
+}
+
+func applyStartArgs(cfg *config.Config, args *StartArgs) error {
+	if args.Rates != nil {
+		if err := config.ValidateRates(args.Rates); err != nil {
+			return err
+		}
+		cfg.State.ErrorRates = append([]float64{}, args.Rates...)
+		cfg.State.RateIndex = 0
+		cfg.State.TestCounter = 0
+		cfg.State.StartTime = time.Now().Unix()
+	}
+	if args.Sites != nil {
+		cfg.Sites = append([]string{}, args.Sites...)
+	}
+	return nil
+}
+
+// updateConfig applies fn to the stored configuration and writes it back.
+func updateConfig(fn func(cfg *config.Config) error) (Progress, error) {
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return Progress{}, err
+	}
+	if !cfg.Initialized {
+		return Progress{}, fmt.Errorf("soft error simulation not configured, run flipcfg first")
+	}
+	if err := fn(&cfg); err != nil {
+		return Progress{}, err
+	}
+	if err := cfg.WriteConfig(); err != nil {
+		return Progress{}, err
+	}
+	meterProgress(&cfg)
+	return stats.progress(&cfg), nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// dialFlip serves the flip namespace on an in-process RPC server.
+func dialFlip(t *testing.T) *rpc.Client {
+	t.Helper()
+	server := rpc.NewServer()
+	for _, api := range APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := rpc.DialInProc(server)
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+	return client
+}
+
+func TestAPI(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.Start = false
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	stats.reset()
+	client := dialFlip(t)
+
+	var p Progress
+	if err := client.Call(&p, "flip_status"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Running {
+		t.Fatal("status: running before flip_start")
+	}
+	if err := client.Call(&p, "flip_start", StartArgs{Rates: []float64{1, 0.5}, Sites: []string{"api"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Running || len(p.Rates) != 2 || len(p.Sites) != 1 || p.Sites[0] != "api" {
+		t.Fatalf("start: progress %+v, want running at the given rates and sites", p)
+	}
+
+	BitFlip(uint64(1), "api")
+	var recent []Iteration
+	if err := client.Call(&recent, "flip_recent", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 || recent[0].ErrorData.Msg != "api" {
+		t.Fatalf("recent: %+v, want the flip at api", recent)
+	}
+	if err := client.Call(&p, "flip_status"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Counter != 1 || p.Flips["api"] != 1 {
+		t.Fatalf("status: counter %d, flips %v after one flip", p.Counter, p.Flips)
+	}
+
+	if err := client.Call(&p, "flip_setRates", []float64{2}); err == nil {
+		t.Error("setRates accepted a rate above 1")
+	}
+	if err := client.Call(&p, "flip_stop"); err != nil {
+		t.Fatal(err)
+	}
+	if p.Running {
+		t.Fatal("stop: still running")
+	}
+	BitFlip(uint64(1), "api")
+	if err := client.Call(&recent, "flip_recent", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 {
+		t.Fatalf("recent: %d flips, want the one made before flip_stop", len(recent))
+	}
+}
+
+// A stopped time campaign runs its full duration from flip_start.
+func TestAPIStartTime(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.Start = false
+	cfg.State.TestType = "time"
+	cfg.State.StartTime = time.Now().Add(-time.Hour).Unix()
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	client := dialFlip(t)
+
+	before := time.Now().Unix()
+	if err := client.Call(nil, "flip_start", nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.State.StartTime < before {
+		t.Errorf("start time %d, want at least %d", cfg.State.StartTime, before)
+	}
+}
+
+// New rates of a time campaign each run their full duration, the first from
+// flip_setRates.
+func TestAPISetRatesStartTime(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.State.TestType = "time"
+	cfg.State.Duration = time.Minute
+	cfg.State.ErrorRates = []float64{1, 1}
+	cfg.State.RateIndex = 1
+	cfg.State.TestCounter = 5
+	cfg.State.StartTime = time.Now().Add(-time.Hour).Unix()
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	client := dialFlip(t)
+
+	before := time.Now().Unix()
+	if err := client.Call(nil, "flip_setRates", []float64{0.5, 1}); err != nil {
+		t.Fatal(err)
+	}
+	BitFlip(uint64(1), "rates")
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.State.RateIndex != 0 || cfg.State.StartTime < before {
+		t.Errorf("rate %d from %d after setRates at %d, want rate 0 from then", cfg.State.RateIndex, cfg.State.StartTime, before)
+	}
+}
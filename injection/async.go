@@ -0,0 +1,307 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+const (
+	defaultQueueSize     = 4096
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultSampleEvery   = 10
+	defaultPolicy        = "drop_oldest"
+)
+
+// batcher queues records for a background worker that posts them in batches.
+// Batches that cannot be posted are written to the spool directory. While the
+// spool holds batches, new ones are spooled behind them and the worker tries
+// to replay the spool, oldest first, every flush interval, so the server sees
+// them in order once it is reachable again. The next process to start replays
+// what is left. Closing the batcher posts or spools everything still queued,
+// so no accepted record is lost on a clean shutdown.
+type batcher struct {
+	poster  *poster
+	cfg     config.AsyncConfig
+	spooled bool // the spool holds batches, owned by the worker
+
+	lock    sync.RWMutex // held for writing once closed
+	closed  bool
+	queue   chan Iteration
+	full    uint64 // records offered while the queue was full, for sampling
+	flushCh chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newBatcher(p *poster, cfg config.AsyncConfig) *batcher {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.SampleEvery <= 0 {
+		cfg.SampleEvery = defaultSampleEvery
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = defaultPolicy
+	}
+	b := &batcher{
+		poster:  p,
+		cfg:     cfg,
+		queue:   make(chan Iteration, cfg.QueueSize),
+		flushCh: make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// add queues iter, applying the back-pressure policy if the queue is full.
+func (b *batcher) add(iter Iteration) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.closed {
+		return fmt.Errorf("results batcher is closed")
+	}
+	select {
+	case b.queue <- iter:
+		return nil
+	default:
+	}
+	switch b.cfg.Policy {
+	case "drop_oldest":
+		for {
+			select {
+			case b.queue <- iter:
+				return nil
+			case <-b.queue:
+				asyncDropCounter.Inc(1)
+			}
+		}
+	case "sample":
+		if atomic.AddUint64(&b.full, 1)%uint64(b.cfg.SampleEvery) != 0 {
+			asyncDropCounter.Inc(1)
+			return nil
+		}
+	}
+	b.queue <- iter
+	return nil
+}
+
+// flush posts everything queued so far and waits for it to be sent or spooled.
+func (b *batcher) flush() {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.closed {
+		return
+	}
+	done := make(chan struct{})
+	b.flushCh <- done
+	<-done
+}
+
+// close stops accepting records and waits for the queue to be sent or spooled.
+func (b *batcher) close() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	b.lock.Unlock()
+
+	close(b.stop)
+	<-b.done
+}
+
+func (b *batcher) loop() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	b.spooled = !b.replay()
+	batch := make([]Iteration, 0, b.cfg.BatchSize)
+	for {
+		select {
+		case iter := <-b.queue:
+			if batch = append(batch, iter); len(batch) >= b.cfg.BatchSize {
+				batch = b.send(batch)
+			}
+		case <-ticker.C:
+			if b.spooled {
+				b.spooled = !b.replay()
+			}
+			batch = b.send(batch)
+		case done := <-b.flushCh:
+			batch = b.send(b.drain(batch))
+			close(done)
+		case <-b.stop:
+			if b.spooled {
+				b.spooled = !b.replay()
+			}
+			b.send(b.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves every queued record into batch, sending full batches on the
+// way.
+func (b *batcher) drain(batch []Iteration) []Iteration {
+	for {
+		select {
+		case iter := <-b.queue:
+			if batch = append(batch, iter); len(batch) >= b.cfg.BatchSize {
+				batch = b.send(batch)
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// send posts batch, spooling it if the server cannot be reached, and returns
+// the emptied batch for reuse.
+func (b *batcher) send(batch []Iteration) []Iteration {
+	if len(batch) == 0 {
+		return batch
+	}
+	body, err := b.encode(batch)
+	if err != nil {
+		sinkError(err)
+		return batch[:0]
+	}
+	// Keep the server's view in order: while older batches wait in the spool,
+	// new ones queue up behind them.
+	if b.spooled {
+		b.spool(body)
+		return batch[:0]
+	}
+	if err := b.poster.postRetrying(body, b.cfg.Gzip); err != nil {
+		sinkError(err)
+		if sErr, ok := err.(*statusError); !ok || sErr.retryable() {
+			b.spooled = b.spool(body)
+		}
+	}
+	return batch[:0]
+}
+
+func (b *batcher) encode(batch []Iteration) ([]byte, error) {
+	body, err := json.Marshal(batch)
+	if err != nil || !b.cfg.Gzip {
+		return body, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// spool writes an encoded batch to the spool directory and reports whether it
+// did.
+func (b *batcher) spool(body []byte) bool {
+	if b.cfg.SpoolDir == "" {
+		sinkError(fmt.Errorf("no spool directory, dropping batch"))
+		return false
+	}
+	if err := os.MkdirAll(b.cfg.SpoolDir, os.ModePerm); err != nil {
+		sinkError(fmt.Errorf("error creating directory \"%s\"", b.cfg.SpoolDir))
+		return false
+	}
+	name := fmt.Sprintf("%020d.json", time.Now().UnixNano())
+	if b.cfg.Gzip {
+		name += ".gz"
+	}
+	path := filepath.Join(b.cfg.SpoolDir, name)
+	if err := os.WriteFile(path+".tmp", body, 0644); err != nil {
+		sinkError(fmt.Errorf("error writing to file \"%s\"", path))
+		return false
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		sinkError(fmt.Errorf("error writing to file \"%s\"", path))
+		return false
+	}
+	asyncSpoolCounter.Inc(1)
+	return true
+}
+
+// replay posts the spooled batches, oldest first, and reports whether the
+// spool is now empty. Each batch is tried once; those left over wait for the
+// next replay rather than hold up the worker with backoffs.
+func (b *batcher) replay() bool {
+	if b.cfg.SpoolDir == "" {
+		return true
+	}
+	entries, err := os.ReadDir(b.cfg.SpoolDir)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(b.cfg.SpoolDir, name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			sinkError(fmt.Errorf("error reading file \"%s\"", path))
+			return false
+		}
+		if err := b.poster.post(body, strings.HasSuffix(name, ".gz")); err != nil {
+			if sErr, ok := err.(*statusError); ok && !sErr.retryable() {
+				// The server will never take this batch, don't let it hold
+				// up the rest.
+				sinkError(fmt.Errorf("discarding spooled batch \"%s\": %v", path, err))
+				os.Remove(path)
+				continue
+			}
+			return false
+		}
+		os.Remove(path)
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// collector is a results server that can be switched off, keeping the
+// batches it accepts.
+type collector struct {
+	mu       sync.Mutex
+	down     bool
+	requests int
+	batches  [][]Iteration
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.requests++
+	if c.down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	var batch []Iteration
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.batches = append(c.batches, batch)
+}
+
+func (c *collector) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+// asyncSink creates an asynchronous sink posting batches of one record to
+// handler, without retries and with a flush interval long enough not to tick.
+func asyncSink(t *testing.T, handler http.Handler, async config.AsyncConfig) *HTTPSink {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	async.Enabled = true
+	async.BatchSize = 1
+	async.FlushInterval = time.Hour
+	sink, err := NewHTTPSink(config.ServerConfig{Host: server.URL, Async: async})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+// While the spool holds batches, new ones are spooled behind them without a
+// request each, and the spool is replayed in order once the server is back.
+func TestBatcherSpool(t *testing.T) {
+	c := &collector{down: true}
+	sink := asyncSink(t, c, config.AsyncConfig{SpoolDir: t.TempDir()})
+	for i := 0; i < 5; i++ {
+		if err := sink.Write(Iteration{IterationNum: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Flush()
+
+	c.mu.Lock()
+	requests := c.requests
+	c.mu.Unlock()
+	if requests != 1 {
+		t.Errorf("%d requests while the server was down, want 1", requests)
+	}
+
+	c.setDown(false)
+	sink.Close()
+	if len(c.batches) != 5 {
+		t.Fatalf("%d batches delivered, want 5", len(c.batches))
+	}
+	for i, batch := range c.batches {
+		if len(batch) != 1 || batch[0].IterationNum != i {
+			t.Errorf("batch %d: %+v, want iteration %d", i, batch, i)
+		}
+	}
+}
+
+// By default a full queue drops its oldest record rather than block BitFlip.
+func TestBatcherDefaultPolicy(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	sink := asyncSink(t, handler, config.AsyncConfig{QueueSize: 1})
+	defer sink.Close()
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			sink.Write(Iteration{IterationNum: i})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked on a full queue")
+	}
+}
+
+// Queued records do not change with the buffer BitFlip was given.
+func TestBatcherCopiesBytes(t *testing.T) {
+	// The worker waits on the first batch while the second one is queued
+	c := &collector{}
+	release := make(chan struct{})
+	var once sync.Once
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { <-release })
+		c.ServeHTTP(w, r)
+	})
+	sink := asyncSink(t, handler, config.AsyncConfig{})
+	if err := sink.Write(Iteration{}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := []byte{0x0f, 0xf0}
+	previous := append([]byte{}, buf...)
+	if err := sink.Write(Iteration{ErrorData: ErrorData{PreviousValue: previous, ErrorValue: buf}}); err != nil {
+		t.Fatal(err)
+	}
+	buf[0], buf[1] = 0, 0
+	close(release)
+	sink.Close()
+
+	if len(c.batches) != 2 || len(c.batches[1]) != 1 {
+		t.Fatalf("batches %+v, want two of one record", c.batches)
+	}
+	want := base64.StdEncoding.EncodeToString([]byte{0x0f, 0xf0})
+	if got := c.batches[1][0].ErrorData.ErrorValue; got != want {
+		t.Errorf("posted error value %v, want %s", got, want)
+	}
+}
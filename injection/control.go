@@ -0,0 +1,247 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// ControlServer is an HTTP server exposing the same controls as the flip_ RPC
+// namespace, for processes that call BitFlip outside of geth.
+//
+//	GET  /status   campaign progress as JSON
+//	POST /start    start, optionally with a JSON body of StartArgs
+//	POST /stop     stop, keeping the counters
+//	POST /restart  restart, optionally with a JSON body of StartArgs
+//	GET  /recent   recent flips as JSON, ?n= limits the count
+//	GET  /events   flips as they happen, as Server-Sent Events
+//	GET  /metrics  injection and node metrics in the Prometheus text format
+//
+// Requests must carry the token as a bearer token or, for EventSource clients
+// that cannot set headers, as the token query parameter. Anything on the host
+// could otherwise start and stop injection, so a server without a token
+// refuses every request, and to start, unless Unauthenticated is set.
+type ControlServer struct {
+	// Unauthenticated serves requests without a token.
+	Unauthenticated bool
+
+	token    string
+	api      *PrivateFlipAPI
+	listener net.Listener
+	server   *http.Server
+}
+
+var controlOnce sync.Once
+
+// NewControlServer creates a control server requiring token.
+func NewControlServer(token string) *ControlServer {
+	return &ControlServer{token: token, api: NewPrivateFlipAPI()}
+}
+
+// StartControlServer listens on addr and serves the control endpoints, which
+// require token, in the background.
+func StartControlServer(addr, token string) (*ControlServer, error) {
+	srv := NewControlServer(token)
+	if err := srv.Start(addr); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// startConfiguredControl starts the control server described in cfg once per
+// process.
+func startConfiguredControl(cfg *config.Config) {
+	if !cfg.Control.Enabled {
+		return
+	}
+	controlOnce.Do(func() {
+		srv := NewControlServer(cfg.Control.Token)
+		srv.Unauthenticated = cfg.Control.Unauthenticated
+		if err := srv.Start(cfg.Control.Address); err != nil {
+			log.Printf("WARNING: could not start flip control server: %v", err)
+		}
+	})
+}
+
+// Start listens on addr and serves in the background. A server without a
+// token is only started if it is Unauthenticated.
+func (srv *ControlServer) Start(addr string) error {
+	if srv.token == "" && !srv.Unauthenticated {
+		return fmt.Errorf("error starting control server without a token")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on \"%s\": %v", addr, err)
+	}
+	srv.listener = listener
+	srv.server = &http.Server{Handler: srv.Handler()}
+	go srv.server.Serve(listener)
+	return nil
+}
+
+// Addr returns the address the server listens on.
+func (srv *ControlServer) Addr() string {
+	if srv.listener == nil {
+		return ""
+	}
+	return srv.listener.Addr().String()
+}
+
+// Close stops the server and ends any open event streams.
+func (srv *ControlServer) Close() error {
+	if srv.server == nil {
+		return nil
+	}
+	return srv.server.Close()
+}
+
+// Handler returns the control endpoints, for mounting on an existing server.
+func (srv *ControlServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", srv.get(func(r *http.Request) (interface{}, error) {
+		return srv.api.Status()
+	}))
+	mux.HandleFunc("/start", srv.post(func(r *http.Request) (interface{}, error) {
+		args, err := readStartArgs(r)
+		if err != nil {
+			return nil, err
+		}
+		return srv.api.Start(args)
+	}))
+	mux.HandleFunc("/stop", srv.post(func(r *http.Request) (interface{}, error) {
+		return srv.api.Stop()
+	}))
+	mux.HandleFunc("/restart", srv.post(func(r *http.Request) (interface{}, error) {
+		args, err := readStartArgs(r)
+		if err != nil {
+			return nil, err
+		}
+		return srv.api.Restart(args)
+	}))
+	mux.HandleFunc("/recent", srv.get(func(r *http.Request) (interface{}, error) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		return srv.api.Recent(&n), nil
+	}))
+	mux.HandleFunc("/events", srv.events)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !srv.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		MetricsHandler().ServeHTTP(w, r)
+	})
+	return mux
+}
+
+func (srv *ControlServer) get(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return srv.handle(http.MethodGet, fn)
+}
+
+func (srv *ControlServer) post(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return srv.handle(http.MethodPost, fn)
+}
+
+func (srv *ControlServer) handle(method string, fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		res, err := fn(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	}
+}
+
+// events streams every flip tracked by this process as Server-Sent Events
+// until the client disconnects.
+func (srv *ControlServer) events(w http.ResponseWriter, r *http.Request) {
+	if !srv.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	flips, unsubscribe := stats.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case iter := <-flips:
+			bytJSON, err := json.Marshal(iter)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: flip\ndata: %s\n\n", bytJSON)
+			flusher.Flush()
+		}
+	}
+}
+
+func (srv *ControlServer) authorized(r *http.Request) bool {
+	if srv.Unauthenticated {
+		return true
+	}
+	if srv.token == "" {
+		return false
+	}
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) == 1
+}
+
+func readStartArgs(r *http.Request) (*StartArgs, error) {
+	if r.ContentLength == 0 {
+		return nil, nil
+	}
+	var args StartArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		return nil, fmt.Errorf("error unmarshaling start arguments: %v", err)
+	}
+	return &args, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// control serves the control endpoints of srv over httptest.
+func control(t *testing.T, srv *ControlServer) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(srv.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// call sends a request with token, if set, and decodes a JSON response into
+// res. It returns the status code.
+func call(t *testing.T, method, url, token, body string, res interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestControlAuth(t *testing.T) {
+	configtest.New(t, 1)
+	server := control(t, NewControlServer("secret"))
+	tests := []struct {
+		url, token string
+		status     int
+	}{
+		{"/status", "", http.StatusUnauthorized},
+		{"/status", "wrong", http.StatusUnauthorized},
+		{"/status", "secret", http.StatusOK},
+		{"/status?token=secret", "", http.StatusOK},
+		{"/recent", "", http.StatusUnauthorized},
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", "secret", http.StatusOK},
+		{"/events", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		if status := call(t, http.MethodGet, server.URL+test.url, test.token, "", nil); status != test.status {
+			t.Errorf("GET %s with token %q: status %d, want %d", test.url, test.token, status, test.status)
+		}
+	}
+
+	// Without a token nothing is served, unless explicitly unauthenticated
+	open := NewControlServer("")
+	if status := call(t, http.MethodGet, control(t, open).URL+"/status", "", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("server without a token: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	if err := open.Start("127.0.0.1:0"); err == nil {
+		open.Close()
+		t.Error("server without a token started")
+	}
+	open.Unauthenticated = true
+	if status := call(t, http.MethodGet, control(t, open).URL+"/status", "", "", nil); status != http.StatusOK {
+		t.Errorf("unauthenticated server: status %d, want %d", status, http.StatusOK)
+	}
+	if err := open.Start("127.0.0.1:0"); err != nil {
+		t.Errorf("unauthenticated server: %v", err)
+	}
+	open.Close()
+}
+
+func TestControlStartStop(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.Start = false
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	url := control(t, NewControlServer("secret")).URL
+
+	var p Progress
+	if status := call(t, http.MethodGet, url+"/start", "secret", "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET /start: status %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+	if status := call(t, http.MethodPost, url+"/start", "secret", `{"rates": [0.5, 1]}`, &p); status != http.StatusOK {
+		t.Fatalf("POST /start: status %d", status)
+	}
+	if !p.Running || len(p.Rates) != 2 || p.Rate != 0.5 {
+		t.Errorf("started: running %v rates %v rate %g, want running at 0.5 of [0.5 1]", p.Running, p.Rates, p.Rate)
+	}
+	if status := call(t, http.MethodPost, url+"/start", "secret", `{"rates": [2]}`, nil); status != http.StatusBadRequest {
+		t.Errorf("POST /start with a rate above 1: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	if status := call(t, http.MethodPost, url+"/stop", "secret", "", &p); status != http.StatusOK || p.Running {
+		t.Errorf("POST /stop: status %d running %v", status, p.Running)
+	}
+	if status := call(t, http.MethodGet, url+"/status", "secret", "", &p); status != http.StatusOK || p.Running {
+		t.Errorf("GET /status after stopping: status %d running %v", status, p.Running)
+	}
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Start {
+		t.Error("configuration still started after POST /stop")
+	}
+
+	if status := call(t, http.MethodPost, url+"/restart", "secret", "", &p); status != http.StatusOK || !p.Running {
+		t.Errorf("POST /restart: status %d running %v", status, p.Running)
+	}
+}
+
+// The event stream carries the flips made after it was opened.
+func TestControlEvents(t *testing.T) {
+	configtest.New(t, 1)
+	url := control(t, NewControlServer("secret")).URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events?token=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q, want text/event-stream", ct)
+	}
+
+	// The stream is subscribed before its headers are sent
+	BitFlip(uint64(1), "control.events")
+
+	scanner := bufio.NewScanner(resp.Body)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+			continue
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var iter Iteration
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &iter); err != nil {
+			t.Fatal(err)
+		}
+		if event != "flip" || iter.ErrorData.Msg != "control.events" {
+			t.Errorf("event %q of a flip at %s, want flip at control.events", event, iter.ErrorData.Msg)
+		}
+		return
+	}
+	t.Fatalf("stream ended without a flip: %v", scanner.Err())
+}
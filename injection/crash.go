@@ -0,0 +1,284 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// CrashFlip is a flip kept for crash attribution, with the code that called
+// BitFlip.
+type CrashFlip struct {
+	Iteration
+	Caller string `json:"caller"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+}
+
+// CrashReport is the content of the crash file: the most recent flips, oldest
+// first, and the panic that ended the process if it was captured.
+type CrashReport struct {
+	Written time.Time   `json:"written"`
+	Pid     int         `json:"pid"`
+	Panic   string      `json:"panic,omitempty"`
+	Stack   string      `json:"stack,omitempty"`
+	Flips   []CrashFlip `json:"flips"`
+}
+
+// crashLine is a line of the crash file. The file is a journal: a header
+// naming the process and the number of flips kept, one line per flip appended
+// as it is made and, if a panic was captured, a line holding it.
+type crashLine struct {
+	Written time.Time  `json:"written,omitempty"`
+	Pid     int        `json:"pid,omitempty"`
+	Keep    int        `json:"keep,omitempty"`
+	Flip    *CrashFlip `json:"flip,omitempty"`
+	Panic   string     `json:"panic,omitempty"`
+	Stack   string     `json:"stack,omitempty"`
+}
+
+// flight holds the most recent flips of this process and the crash file they
+// are journaled to.
+var flight struct {
+	mu       sync.Mutex
+	flips    []CrashFlip
+	path     string
+	file     *os.File // crash file open for appending, if enabled
+	appended int      // flips appended since the file was last rewritten
+}
+
+// wrappers are the prefixes of the functions that hand values to BitFlip for
+// somebody else, skipped when looking for the code a flip is attributed to.
+var wrappers = []string{"github.com/griffindavis02/eth-bit-flip/"}
+
+// SkipCallers adds function name prefixes to skip, beside this module's, when
+// attributing a flip to its caller, for helpers outside of the module that an
+// injector is called from. Call it from an init function.
+func SkipCallers(prefixes ...string) {
+	wrappers = append(wrappers, prefixes...)
+}
+
+// caller returns the innermost function on the stack that is not a wrapper.
+func caller() (function, file string, line int) {
+	var stack [64]uintptr
+	frames := runtime.CallersFrames(stack[:runtime.Callers(2, stack[:])])
+	for {
+		frame, more := frames.Next()
+		if !isWrapper(frame.Function) {
+			return frame.Function, frame.File, frame.Line
+		}
+		if !more {
+			return "", "", 0
+		}
+	}
+}
+
+func isWrapper(function string) bool {
+	for _, prefix := range wrappers {
+		if strings.HasPrefix(function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFlight keeps iter for crash attribution and, if the crash file is
+// enabled, appends it so the flips survive a panic that is not captured. The
+// file is rewritten with the flips kept once as many have been appended.
+func recordFlight(iter Iteration, cfg *config.Config) {
+	flip := CrashFlip{Iteration: iter.Copy()}
+	flip.Caller, flip.File, flip.Line = caller()
+	limit := cfg.Crash.Flips
+	if limit <= 0 {
+		limit = config.DefaultConfig.Crash.Flips
+	}
+
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+
+	flight.flips = append(flight.flips, flip)
+	if len(flight.flips) > limit {
+		flight.flips = append(flight.flips[:0], flight.flips[len(flight.flips)-limit:]...)
+	}
+	path := CrashPath(cfg)
+	if !cfg.Crash.Enabled {
+		closeCrashFile()
+		flight.path = path
+		return
+	}
+	var err error
+	if flight.file == nil || path != flight.path || flight.appended >= limit {
+		flight.path = path
+		err = rewriteCrashFile(limit, nil)
+	} else {
+		err = appendCrashLine(crashLine{Flip: &flip})
+		flight.appended++
+	}
+	if err != nil {
+		log.Printf("WARNING: crash file: %v", err)
+	}
+}
+
+// CapturePanic writes the crash file, if it is enabled, with the panic and the
+// stack of the panicking goroutine, and flushes every sink if the goroutine it
+// is deferred in panics. The panic then carries on. It only sees panics on the goroutine
+// it is deferred in; patch.py defers it on geth's main goroutine alone, so a
+// panic on any other goroutine, where most injection sites run, ends the
+// process uncaptured. The crash file then holds the flips but not the panic,
+// which flipcfg crash can read from geth's output. Defer it at the top of
+// goroutines that reach injection sites to capture theirs:
+//
+//	defer injection.CapturePanic()
+func CapturePanic() {
+	p := recover()
+	if p == nil {
+		return
+	}
+	stack := string(debug.Stack())
+
+	if cfg, err := config.ReadConfig(); err == nil && cfg.Crash.Enabled {
+		flight.mu.Lock()
+		flight.path = CrashPath(&cfg)
+		if err := rewriteCrashFile(len(flight.flips), &crashLine{Panic: fmt.Sprint(p), Stack: stack}); err != nil {
+			log.Printf("WARNING: crash file: %v", err)
+		} else {
+			log.Printf("WARNING: panic captured, crash file written to %s", flight.path)
+		}
+		closeCrashFile()
+		flight.mu.Unlock()
+	}
+
+	CloseSinks()
+	panic(p)
+}
+
+// ReadCrashReport reads the crash file at path.
+func ReadCrashReport(path string) (*CrashReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading in crash file from %s", path)
+	}
+	defer file.Close()
+
+	report, keep := new(CrashReport), 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var line crashLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			// A line cut short by the process dying ends the journal
+			break
+		}
+		switch {
+		case line.Flip != nil:
+			report.Flips = append(report.Flips, *line.Flip)
+		case line.Panic != "":
+			report.Panic, report.Stack = line.Panic, line.Stack
+		case n == 1:
+			report.Written, report.Pid, keep = line.Written, line.Pid, line.Keep
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading in crash file from %s", path)
+	}
+	if keep > 0 && len(report.Flips) > keep {
+		report.Flips = report.Flips[len(report.Flips)-keep:]
+	}
+	return report, nil
+}
+
+// CrashPath returns the crash file configured in cfg.
+func CrashPath(cfg *config.Config) string {
+	if cfg.Crash.Path != "" {
+		return cfg.Crash.Path
+	}
+	return config.DefaultConfig.Crash.Path
+}
+
+// rewriteCrashFile replaces the crash file at flight.path with the flips kept,
+// of which the report holds keep, and last if set, leaving it open for
+// appending. The caller must hold flight.mu.
+func rewriteCrashFile(keep int, last *crashLine) error {
+	closeCrashFile()
+	var buf []byte
+	lines := []crashLine{{Written: time.Now(), Pid: os.Getpid(), Keep: keep}}
+	for i := range flight.flips {
+		lines = append(lines, crashLine{Flip: &flight.flips[i]})
+	}
+	if last != nil {
+		lines = append(lines, *last)
+	}
+	for _, line := range lines {
+		enc, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, enc...), '\n')
+	}
+	path := flight.path
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory \"%s\"", filepath.Dir(path))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("error writing to file \"%s\"", tmp)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file \"%s\"", path)
+	}
+	flight.file, flight.appended = file, 0
+	return nil
+}
+
+// appendCrashLine appends line to the open crash file in a single write, so
+// it reaches the file even if the process dies right after. The caller must
+// hold flight.mu.
+func appendCrashLine(line crashLine) error {
+	enc, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	if _, err := flight.file.Write(append(enc, '\n')); err != nil {
+		return fmt.Errorf("error writing to file \"%s\"", flight.path)
+	}
+	return nil
+}
+
+// closeCrashFile closes the crash file if it is open. The caller must hold
+// flight.mu.
+func closeCrashFile() {
+	if flight.file != nil {
+		flight.file.Close()
+		flight.file = nil
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// crashConfig enables the crash file, keeping keep flips, and forgets the
+// flips of earlier tests. It returns the path of the crash file.
+func crashConfig(t *testing.T, keep int) string {
+	t.Helper()
+	cfg := configtest.New(t, 1)
+	cfg.Crash.Enabled = true
+	cfg.Crash.Path = filepath.Join(t.TempDir(), "crash.json")
+	cfg.Crash.Flips = keep
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	flight.mu.Lock()
+	flight.flips, flight.path = nil, ""
+	closeCrashFile()
+	flight.mu.Unlock()
+	t.Cleanup(func() {
+		flight.mu.Lock()
+		closeCrashFile()
+		flight.mu.Unlock()
+	})
+	return cfg.Crash.Path
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+// Flips are appended to the crash file, which is rewritten to the flips kept
+// once as many have been appended.
+func TestCrashJournal(t *testing.T) {
+	path := crashConfig(t, 4)
+	for i := 1; i <= 10; i++ {
+		BitFlip(uint64(i), "crash")
+		switch i {
+		case 5:
+			if n := lines(t, path); n != 6 {
+				t.Errorf("after 5 flips: %d lines, want a header and 5 flips", n)
+			}
+		case 6:
+			if n := lines(t, path); n != 5 {
+				t.Errorf("after 6 flips: %d lines, want a header and the 4 flips kept", n)
+			}
+		}
+	}
+
+	report, err := ReadCrashReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pid != os.Getpid() || report.Panic != "" {
+		t.Errorf("report of pid %d with panic %q", report.Pid, report.Panic)
+	}
+	if len(report.Flips) != 4 {
+		t.Fatalf("%d flips, want 4", len(report.Flips))
+	}
+	for i, flip := range report.Flips {
+		if want := fmt.Sprintf("0x%016x", 7+i); flip.ErrorData.PreviousByte != want {
+			t.Errorf("flip %d of %s, want %s", i, flip.ErrorData.PreviousByte, want)
+		}
+	}
+}
+
+// A flip is attributed to the first function outside of this module, not to
+// the wrappers that handed the value to BitFlip.
+func TestCrashCaller(t *testing.T) {
+	crashConfig(t, 4)
+	values := []uint64{2, 1}
+	sort.Slice(values, func(i, j int) bool {
+		BitFlip(values[i], "crash")
+		return values[i] < values[j]
+	})
+
+	flight.mu.Lock()
+	defer flight.mu.Unlock()
+	if len(flight.flips) == 0 {
+		t.Fatal("no flips kept")
+	}
+	flip := flight.flips[len(flight.flips)-1]
+	if !strings.HasPrefix(flip.Caller, "sort.") || flip.Line == 0 {
+		t.Errorf("flip attributed to %s (%s:%d), want the sort package", flip.Caller, flip.File, flip.Line)
+	}
+}
+
+func TestCapturePanic(t *testing.T) {
+	path := crashConfig(t, 4)
+	BitFlip(uint64(1), "crash")
+
+	func() {
+		defer func() {
+			if p := recover(); p != "corrupted" {
+				t.Errorf("panic %v carried on, want corrupted", p)
+			}
+		}()
+		defer CapturePanic()
+		panic("corrupted")
+	}()
+
+	report, err := ReadCrashReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Panic != "corrupted" || !strings.Contains(report.Stack, "TestCapturePanic") {
+		t.Errorf("panic %q with stack %q", report.Panic, report.Stack)
+	}
+	if len(report.Flips) != 1 {
+		t.Errorf("%d flips, want 1", len(report.Flips))
+	}
+}
+
+// Without the crash file enabled, a captured panic carries on without one.
+func TestCapturePanicDisabled(t *testing.T) {
+	path := crashConfig(t, 4)
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Crash.Enabled = false
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	BitFlip(uint64(1), "crash")
+
+	func() {
+		defer func() {
+			if p := recover(); p != "corrupted" {
+				t.Errorf("panic %v carried on, want corrupted", p)
+			}
+		}()
+		defer CapturePanic()
+		panic("corrupted")
+	}()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("crash file written while disabled: %v", err)
+	}
+}
@@ -20,20 +20,40 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
 	"math"
 	"math/big"
 	"math/rand"
-	"net/http"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/griffindavis02/eth-bit-flip/config"
 )
 
-type errorData struct {
+// TimeFormat is the layout of ErrorData.When, in the local time of the
+// injecting process with its offset from UTC.
+const TimeFormat = "01-02-2006 15:04:05.000000000 -0700"
+
+// legacyTimeFormat is the layout of ErrorData.When in records written before
+// the offset was recorded.
+const legacyTimeFormat = "01-02-2006 15:04:05.000000000"
+
+// ParseWhen parses the When of a record. Records written without an offset
+// are read in the local time of the caller, the best guess left for them.
+func ParseWhen(when string) (time.Time, error) {
+	if t, err := time.Parse(TimeFormat, when); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(legacyTimeFormat, when, time.Local)
+}
+
+// ErrorData describes a single changed value.
+type ErrorData struct {
 	PreviousValue interface{}
 	PreviousByte  string
 	IntBits       []int
@@ -41,24 +61,112 @@ type errorData struct {
 	ErrorByte     string
 	DeltaValue    interface{}
 	When          string
-	Msg			string
+	Msg           string
+	Context       Context `json:",omitempty"`
+}
+
+// Context describes where a value was changed, beyond its site, such as the
+// opcode and contract of an EVM flip. It is passed to BitFlip after the site.
+type Context map[string]interface{}
+
+// String lists the fields of c as key=value pairs, sorted by key.
+func (c Context) String() string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", key, c[key])
+	}
+	return strings.Join(pairs, " ")
 }
 
-type iteration struct {
+// Iteration is the record produced for every value changed by BitFlip.
+// GethVersion is the go-ethereum the injecting process was built with.
+type Iteration struct {
+	Campaign     string
+	TestType     string
 	Rate         float64
 	IterationNum int
-	ErrorData    errorData
+	ErrorData    ErrorData
+	GethVersion  string `json:",omitempty"`
+}
+
+// Copy returns iter with its byte slice values copied. The ErrorValue of a
+// []byte flip is the caller's own buffer, so a record kept after it is
+// delivered must be copied before the caller reuses the buffer.
+func (iter Iteration) Copy() Iteration {
+	if b, ok := iter.ErrorData.PreviousValue.([]byte); ok {
+		iter.ErrorData.PreviousValue = append([]byte{}, b...)
+	}
+	if b, ok := iter.ErrorData.ErrorValue.([]byte); ok {
+		iter.ErrorData.ErrorValue = append([]byte{}, b...)
+	}
+	return iter
+}
+
+// gethVersion is the version of go-ethereum linked into this process, with the
+// module version it was built from if known.
+var gethVersion = func() string {
+	version := params.VersionWithMeta
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/ethereum/go-ethereum" {
+				return fmt.Sprintf("%s (%s)", version, dep.Version)
+			}
+		}
+	}
+	return version
+}()
+
+var (
+	rngLock sync.Mutex
+	rng     *rand.Rand // source for the configured seed
+	rngSeed int64
+)
+
+// random returns the source flips are drawn from. A configured seed makes the
+// flips of a process reproducible; without one every call is seeded from the
+// clock. The caller must hold rngLock.
+func random(cfg *config.Config) *rand.Rand {
+	if cfg.Seed == 0 {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if rng == nil || rngSeed != cfg.Seed {
+		rng = rand.New(rand.NewSource(cfg.Seed))
+		rngSeed = cfg.Seed
+	}
+	return rng
 }
 
 // BitFlip will run the odds of flipping a bit within pbigNum based on error
 // rate pdecRate. The iteration count will increment and both the new number
 // and the iteration error data will be returned.
+//
+// The value may be followed by its site, a string, and its context, a Context
+// or a plain map[string]interface{}; a site or context of another type is
+// ignored rather than panicking in the caller.
 func BitFlip(params ...interface{}) interface{} {
 	var msg string = ""
 	var pIFlipee interface{} = params[0]
+	callCounter.Inc(1)
+	var context Context
 	// Test if message is supplied
 	if len(params) > 1 {
-		msg = params[1].(string)
+		msg, _ = params[1].(string)
+	}
+	if len(params) > 2 {
+		switch c := params[2].(type) {
+		case Context:
+			context = c
+		case map[string]interface{}:
+			context = Context(c)
+		case nil:
+		default:
+			log.Printf("WARNING: ignoring BitFlip context of type %T at \"%s\"", c, msg)
+		}
 	}
 	cfg, err := config.ReadConfig()
 	if err != nil {
@@ -66,7 +174,8 @@ func BitFlip(params ...interface{}) interface{} {
 			return pIFlipee
 		}
 	}
-	if !cfg.Start {
+	startConfiguredControl(&cfg)
+	if !cfg.Start || !siteEnabled(&cfg, msg) {
 		return pIFlipee
 	}
 	if cfg.Restart {
@@ -74,7 +183,6 @@ func BitFlip(params ...interface{}) interface{} {
 	}
 
 	// Check for out of bounds or end of error rate
-	rand.Seed(time.Now().UnixNano())
 	switch cfg.State.TestType {
 	case "bit":
 		if cfg.State.TestCounter >= cfg.State.Bits {
@@ -105,7 +213,7 @@ func BitFlip(params ...interface{}) interface{} {
 		}
 	}
 
-	var iteration iteration
+	var iteration Iteration
 	switch pIFlipee.(type) {
 	case []byte:
 		iteration = flipBytes(pIFlipee.([]byte), &cfg)
@@ -227,18 +335,22 @@ func BitFlip(params ...interface{}) interface{} {
 		iteration.ErrorData.PreviousValue = new(big.Int).SetBytes(iteration.ErrorData.PreviousValue.([]byte))
 		iteration.ErrorData.ErrorValue = new(big.Int).SetBytes(iteration.ErrorData.ErrorValue.([]byte))
 		iteration.ErrorData.DeltaValue = iteration.ErrorData.DeltaValue.(*big.Int)
+	default:
+		skippedCounter.Inc(1)
+		return pIFlipee
 	}
 
 	iteration.ErrorData.Msg = msg
+	iteration.ErrorData.Context = context
 
 	printOut(iteration, &cfg)
 	return iteration.ErrorData.ErrorValue
 }
 
-func flipBytes(pbytFlipee []byte, cfg *config.Config) iteration {
+func flipBytes(pbytFlipee []byte, cfg *config.Config) Iteration {
 	decRate := cfg.State.ErrorRates[cfg.State.RateIndex]
 	var arrBits []int
-	var iter iteration
+	var iter Iteration
 
 	// Store previous states
 	lngPrevCounter := cfg.State.TestCounter
@@ -247,9 +359,11 @@ func flipBytes(pbytFlipee []byte, cfg *config.Config) iteration {
 	intLastByte := len(pbytFlipee) - 1
 
 	// Run chance of flipping a bit in byte representation
+	rngLock.Lock()
+	r := random(cfg)
 	for i := range bytPrevFlipee {
 		for j := 0; j < 8; j++ {
-			if math.Floor(rand.Float64()/decRate) == math.Floor(rand.Float64()/decRate) {
+			if math.Floor(r.Float64()/decRate) == math.Floor(r.Float64()/decRate) {
 				if cfg.State.TestType == "bit" {
 					cfg.State.TestCounter++
 				}
@@ -258,6 +372,7 @@ func flipBytes(pbytFlipee []byte, cfg *config.Config) iteration {
 			}
 		}
 	}
+	rngLock.Unlock()
 
 	// Ensure there was a change
 	if !bytes.Equal(pbytFlipee, bytPrevFlipee) {
@@ -265,10 +380,12 @@ func flipBytes(pbytFlipee []byte, cfg *config.Config) iteration {
 			cfg.State.TestCounter++
 		}
 		// Build error data
-		iter = iteration{
+		iter = Iteration{
+			cfg.Campaign,
+			cfg.State.TestType,
 			cfg.State.ErrorRates[cfg.State.RateIndex],
 			int(lngPrevCounter),
-			errorData{
+			ErrorData{
 				bytPrevFlipee,
 				"0x" + hex.EncodeToString(bytPrevFlipee),
 				arrBits,
@@ -276,9 +393,11 @@ func flipBytes(pbytFlipee []byte, cfg *config.Config) iteration {
 				"0x" + hex.EncodeToString(pbytFlipee),
 				big.NewInt(0).Sub(big.NewInt(0).SetBytes(pbytFlipee),
 					big.NewInt(0).SetBytes(bytPrevFlipee)),
-				time.Now().Format("01-02-2006 15:04:05.000000000"),
-				"", // message value attached in parent function
+				time.Now().Format(TimeFormat),
+				"",  // message value attached in parent function
+				nil, // context attached in parent function
 			},
+			gethVersion,
 		}
 
 		cfg.WriteConfig()
@@ -287,39 +406,37 @@ func flipBytes(pbytFlipee []byte, cfg *config.Config) iteration {
 	return iter
 }
 
+// restart starts the campaign of cfg over from its first error rate and
+// reseeds the source flips are drawn from. The restart is written back at
+// once: a flip is what normally persists the configuration, so until the
+// first one every call would restart again and, with a seed, redraw the same
+// numbers, leaving a low rate campaign unable to ever flip.
 func restart(cfg *config.Config) {
 	cfg.State.TestCounter = 0
 	cfg.State.RateIndex = 0
+	cfg.State.StartTime = time.Now().Unix()
 	cfg.Start = true
 	cfg.Restart = false
-}
+	stats.reset()
 
-func printOut(pIteration iteration, cfg *config.Config) {
-	if pIteration.ErrorData.PreviousValue == pIteration.ErrorData.ErrorValue {
-		return
-	}
-	// TODO: Look for logging boolean before printing?
-	bytJSON, _ := json.MarshalIndent(pIteration, "", "    ")
-	fmt.Println(string(bytJSON))
-	if cfg.Server.Post {
-		postAPI(cfg.Server.Host, pIteration)
-	}
-}
+	rngLock.Lock()
+	rng = nil
+	rngLock.Unlock()
 
-func postAPI(url string, jsonOut interface{}) int {
-	client := http.Client{}
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		log.Fatal(err)
+	if err := cfg.WriteConfig(); err != nil {
+		log.Println("WARNING:", err)
 	}
-	query := req.URL.Query()
-	params, _ := json.Marshal(jsonOut.(iteration))
-	query.Add("params", string(params))
-	req.URL.RawQuery = query.Encode()
+}
 
-	res, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
+// printOut hands a changed value to the statistics, metrics and sinks. The
+// values are compared by their hex encodings: comparing PreviousValue with
+// ErrorValue directly panics when they hold byte slices.
+func printOut(pIteration Iteration, cfg *config.Config) {
+	if pIteration.ErrorData.PreviousByte == pIteration.ErrorData.ErrorByte {
+		return
 	}
-	return res.StatusCode
+	stats.track(pIteration, cfg)
+	recordFlight(pIteration, cfg)
+	meterFlip(pIteration, cfg)
+	deliver(pIteration, cfg)
 }
@@ -0,0 +1,65 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// record runs value through BitFlip with params and returns the record of
+// the flip.
+func record(t *testing.T, value interface{}, params ...interface{}) Iteration {
+	t.Helper()
+	ring := NewRingSink(1)
+	AddSink(ring)
+	defer RemoveSink(ring)
+
+	BitFlip(append([]interface{}{value}, params...)...)
+	records := ring.Records()
+	if len(records) != 1 {
+		t.Fatalf("%d records, want 1", len(records))
+	}
+	return records[0]
+}
+
+func TestBitFlipContext(t *testing.T) {
+	configtest.New(t, 1)
+
+	tests := []struct {
+		name    string
+		context interface{}
+		want    Context
+	}{
+		{"context", Context{"opcode": "ADD"}, Context{"opcode": "ADD"}},
+		{"map", map[string]interface{}{"opcode": "ADD"}, Context{"opcode": "ADD"}},
+		{"nil", nil, nil},
+		{"other", []string{"opcode"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iter := record(t, uint64(1), "site", tt.context)
+			if iter.ErrorData.Msg != "site" {
+				t.Errorf("site %q, want %q", iter.ErrorData.Msg, "site")
+			}
+			if len(iter.ErrorData.Context) != len(tt.want) || iter.ErrorData.Context["opcode"] != tt.want["opcode"] {
+				t.Errorf("context %v, want %v", iter.ErrorData.Context, tt.want)
+			}
+		})
+	}
+}
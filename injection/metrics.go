@@ -0,0 +1,109 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// Injection metrics live in go-ethereum's default registry so they show up
+// next to the node's own metrics. They are registered regardless of
+// --metrics, as campaigns outside of geth never set that flag.
+//
+// The names below are stable; dashboards depend on them. Per-site, per-type
+// and per-rate counters are named flip/flips/site/<site>,
+// flip/flips/type/<test type> and flip/flips/rate/<rate index>. BitFlip flips
+// every bit independently, so the fault model of a flip is told by the number
+// of bits it changed: flip/flips/model/single_bit or flip/flips/model/multi_bit.
+var (
+	callCounter      = metrics.NewRegisteredCounterForced("flip/calls", nil)
+	skippedCounter   = metrics.NewRegisteredCounterForced("flip/skipped", nil)
+	flipCounter      = metrics.NewRegisteredCounterForced("flip/flips", nil)
+	bitCounter       = metrics.NewRegisteredCounterForced("flip/bits", nil)
+	singleBitCounter = metrics.NewRegisteredCounterForced("flip/flips/model/single_bit", nil)
+	multiBitCounter  = metrics.NewRegisteredCounterForced("flip/flips/model/multi_bit", nil)
+	sinkErrorCounter = metrics.NewRegisteredCounterForced("flip/sink/errors", nil)
+
+	postRetryCounter   = metrics.NewRegisteredCounterForced("flip/http/retries", nil)
+	postFailureCounter = metrics.NewRegisteredCounterForced("flip/http/failures", nil)
+	asyncDropCounter   = metrics.NewRegisteredCounterForced("flip/http/dropped", nil)
+	asyncSpoolCounter  = metrics.NewRegisteredCounterForced("flip/http/spooled", nil)
+
+	runningGauge   = registerGauge("flip/progress/running")
+	rateIndexGauge = registerGauge("flip/progress/rateindex")
+	counterGauge   = registerGauge("flip/progress/counter")
+	targetGauge    = registerGauge("flip/progress/target")
+)
+
+// registerGauge registers a gauge that, like the counters above, stays live
+// when metrics collection is otherwise disabled.
+func registerGauge(name string) metrics.Gauge {
+	return metrics.GetOrRegister(name, new(metrics.StandardGauge)).(metrics.Gauge)
+}
+
+// meterFlip updates the metrics for a flip that was just made.
+func meterFlip(iter Iteration, cfg *config.Config) {
+	flipCounter.Inc(1)
+	bitCounter.Inc(int64(len(iter.ErrorData.IntBits)))
+	metrics.GetOrRegisterCounterForced("flip/flips/site/"+metricName(iter.ErrorData.Msg), nil).Inc(1)
+	metrics.GetOrRegisterCounterForced("flip/flips/type/"+metricName(cfg.State.TestType), nil).Inc(1)
+	metrics.GetOrRegisterCounterForced("flip/flips/rate/"+strconv.Itoa(cfg.State.RateIndex), nil).Inc(1)
+	if len(iter.ErrorData.IntBits) == 1 {
+		singleBitCounter.Inc(1)
+	} else {
+		multiBitCounter.Inc(1)
+	}
+	meterProgress(cfg)
+}
+
+// meterProgress updates the campaign progress gauges.
+func meterProgress(cfg *config.Config) {
+	p := stats.progress(cfg)
+	if p.Running {
+		runningGauge.Update(1)
+	} else {
+		runningGauge.Update(0)
+	}
+	rateIndexGauge.Update(int64(p.RateIndex))
+	counterGauge.Update(int64(p.Counter))
+	targetGauge.Update(int64(p.Target))
+}
+
+// metricName makes a site or test type usable as a Prometheus name segment.
+func metricName(name string) string {
+	if name == "" {
+		return "unnamed"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// MetricsHandler serves go-ethereum's default registry, including the
+// injection metrics, in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return prometheus.Handler(metrics.DefaultRegistry)
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// scrape returns the metrics served by MetricsHandler.
+func scrape(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(MetricsHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	configtest.New(t, 1)
+	BitFlip(uint64(1), "metrics.site")
+	BitFlip("unsupported", "metrics.site")
+
+	body := scrape(t)
+	for _, name := range []string{
+		"flip_calls",
+		"flip_skipped",
+		"flip_flips",
+		"flip_bits",
+		"flip_flips_site_metrics_site",
+		"flip_flips_type_variable",
+		"flip_flips_rate_0",
+		"flip_flips_model_multi_bit",
+		"flip_sink_errors",
+		"flip_progress_running",
+		"flip_progress_rateindex",
+		"flip_progress_counter",
+		"flip_progress_target",
+	} {
+		if !strings.Contains(body, "\n"+name+" ") {
+			t.Errorf("metric %s not served", name)
+		}
+	}
+	if strings.Contains(body, "\nflip_progress_running 0") {
+		t.Error("campaign reported as stopped")
+	}
+}
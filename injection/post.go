@@ -0,0 +1,250 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultBackoff    = 500 * time.Millisecond
+	maxBackoff        = 30 * time.Second
+	maxErrorBodyBytes = 512
+)
+
+// statusError is returned for responses outside of the 2xx range.
+type statusError struct {
+	code int
+	body string
+}
+
+func (err *statusError) Error() string {
+	if err.body == "" {
+		return fmt.Sprintf("results server responded %d %s", err.code, http.StatusText(err.code))
+	}
+	return fmt.Sprintf("results server responded %d %s: %s", err.code, http.StatusText(err.code), err.body)
+}
+
+// retryable reports whether the request may succeed if sent again. Client
+// errors other than timeouts and rate limiting will not.
+func (err *statusError) retryable() bool {
+	return err.code >= 500 || err.code == http.StatusRequestTimeout || err.code == http.StatusTooManyRequests
+}
+
+// poster sends JSON documents to a results server, retrying with exponential
+// backoff when asked to. It never exits the process; the last error is
+// returned instead.
+type poster struct {
+	client   *http.Client
+	host     string
+	retries  int
+	backoff  time.Duration
+	authType string
+	secret   string
+}
+
+func newPoster(srv config.ServerConfig) (*poster, error) {
+	timeout, backoff := srv.Timeout, srv.Backoff
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+	retries := srv.Retries
+	if retries < 0 {
+		retries = 0
+	}
+	p := &poster{
+		client:   &http.Client{Timeout: timeout},
+		host:     srv.Host,
+		retries:  retries,
+		backoff:  backoff,
+		authType: srv.Auth.Type,
+	}
+	switch srv.Auth.Type {
+	case "":
+	case "bearer", "hmac":
+		secret, err := srv.Auth.ResolveSecret()
+		if err != nil {
+			return nil, err
+		}
+		p.secret = secret
+	default:
+		return nil, fmt.Errorf("unknown authentication type \"%s\"", srv.Auth.Type)
+	}
+	if srv.TLS != (config.TLSConfig{}) {
+		tlsConfig, err := newTLSConfig(srv.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		p.client.Transport = transport
+	}
+	return p, nil
+}
+
+// newTLSConfig builds the client side TLS settings for a results server.
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version \"%s\"", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading in CA file from %s", cfg.CAFile)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// sign authenticates req, whose body is body. HMAC signatures cover the
+// timestamp and the body as sent, so replays are limited to the server's
+// accepted clock skew:
+//
+//	X-Flip-Timestamp: <unix seconds>
+//	X-Flip-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+func (p *poster) sign(req *http.Request, body []byte) {
+	switch p.authType {
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+p.secret)
+	case "hmac":
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Signature([]byte(p.secret), timestamp, body))
+	}
+}
+
+// Headers carrying HMAC request signatures.
+const (
+	TimestampHeader = "X-Flip-Timestamp"
+	SignatureHeader = "X-Flip-Signature"
+)
+
+// Signature computes the hex encoded HMAC-SHA256 of a signed request.
+func Signature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends body once, so the goroutine that called BitFlip waits for at most
+// the request timeout. Gzipped bodies are marked with Content-Encoding.
+func (p *poster) post(body []byte, gzipped bool) error {
+	err := p.send(body, gzipped)
+	if err != nil {
+		postFailureCounter.Inc(1)
+	}
+	return err
+}
+
+// postRetrying sends body like post, trying up to retries more times on
+// network errors and retryable status codes. It sleeps between attempts, so
+// only the asynchronous worker calls it.
+func (p *poster) postRetrying(body []byte, gzipped bool) error {
+	var err error
+	delay := p.backoff
+	for attempt := 0; attempt <= p.retries; attempt++ {
+		if attempt > 0 {
+			postRetryCounter.Inc(1)
+			time.Sleep(delay)
+			if delay *= 2; delay > maxBackoff {
+				delay = maxBackoff
+			}
+		}
+		if err = p.send(body, gzipped); err == nil {
+			return nil
+		}
+		if sErr, ok := err.(*statusError); ok && !sErr.retryable() {
+			break
+		}
+	}
+	postFailureCounter.Inc(1)
+	return err
+}
+
+func (p *poster) send(body []byte, gzipped bool) error {
+	req, err := http.NewRequest(http.MethodPost, p.host, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	p.sign(req, body)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodyBytes))
+		return &statusError{code: res.StatusCode, body: string(bytes.TrimSpace(msg))}
+	}
+	io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// failingServer answers the first failures requests with status and every
+// later one with 200, counting the requests it receives.
+func failingServer(t *testing.T, status int, failures int32) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			http.Error(w, "injected failure", status)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestPostRetry(t *testing.T) {
+	server, requests := failingServer(t, http.StatusServiceUnavailable, 2)
+	backoff := 20 * time.Millisecond
+	p, err := newPoster(config.ServerConfig{Host: server.URL, Retries: 3, Backoff: backoff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := p.postRetrying([]byte("{}"), false); err != nil {
+		t.Fatalf("post failed after the server recovered: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	// Waits of one and two backoffs before the second and third attempts
+	if elapsed := time.Since(start); elapsed < 3*backoff {
+		t.Errorf("retried after %v, want a doubling backoff of at least %v", elapsed, 3*backoff)
+	}
+}
+
+func TestPostRetryExhausted(t *testing.T) {
+	server, requests := failingServer(t, http.StatusInternalServerError, 10)
+	p, err := newPoster(config.ServerConfig{Host: server.URL, Retries: 2, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sErr *statusError
+	if err := p.postRetrying([]byte("{}"), false); !errors.As(err, &sErr) || sErr.code != http.StatusInternalServerError {
+		t.Fatalf("error %v, want the last 500 response", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestPostClientError(t *testing.T) {
+	server, requests := failingServer(t, http.StatusBadRequest, 10)
+	p, err := newPoster(config.ServerConfig{Host: server.URL, Retries: 3, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sErr *statusError
+	if err := p.postRetrying([]byte("{}"), false); !errors.As(err, &sErr) || sErr.code != http.StatusBadRequest {
+		t.Fatalf("error %v, want the 400 response", err)
+	}
+	if sErr.body != "injected failure" {
+		t.Errorf("error body %q, want the server's message", sErr.body)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d requests, want a 400 not to be retried", n)
+	}
+}
+
+func TestPostTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p, err := newPoster(config.ServerConfig{Host: server.URL, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = p.post([]byte("{}"), false)
+	var nErr net.Error
+	if !errors.As(err, &nErr) || !nErr.Timeout() {
+		t.Fatalf("error %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("post returned after %v, want the 50ms timeout", elapsed)
+	}
+}
+
+// The synchronous sink posts once and leaves retrying to the worker, so the
+// goroutine that called BitFlip never sleeps.
+func TestHTTPSinkSync(t *testing.T) {
+	server, requests := failingServer(t, http.StatusServiceUnavailable, 10)
+	sink, err := NewHTTPSink(config.ServerConfig{Host: server.URL, Retries: 3, Backoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(Iteration{}); err == nil {
+		t.Fatal("write succeeded against a failing server")
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
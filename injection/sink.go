@@ -0,0 +1,234 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// Sink receives the record of every value changed by BitFlip. Writes happen on
+// the goroutine that called BitFlip, so sinks must be safe for concurrent use.
+// Sinks keeping a record after Write returns keep its Copy.
+type Sink interface {
+	Write(iter Iteration) error
+	Flush() error
+	Close() error
+}
+
+// SinkFactory builds a sink from its configuration entry.
+type SinkFactory func(cfg config.SinkConfig) (Sink, error)
+
+var (
+	sinkMu        sync.Mutex
+	sinkFactories = map[string]SinkFactory{
+		"stdout": func(cfg config.SinkConfig) (Sink, error) { return NewStdoutSink(), nil },
+		"jsonl":  func(cfg config.SinkConfig) (Sink, error) { return NewJSONLSink(cfg.Path, cfg.MaxBytes, cfg.MaxFiles) },
+		"csv":    func(cfg config.SinkConfig) (Sink, error) { return NewCSVSink(cfg.Path) },
+		"http":   func(cfg config.SinkConfig) (Sink, error) { return NewHTTPSink(*cfg.Server) },
+		"memory": func(cfg config.SinkConfig) (Sink, error) { return namedRing(cfg.Name, cfg.Capacity), nil },
+	}
+
+	// rings holds the memory sinks of the configuration file by name, so that
+	// their records outlive a rebuild and can be read with Ring.
+	rings = make(map[string]*RingSink)
+
+	// configured holds the sinks built from the configuration file, rebuilt
+	// whenever the sink entries change.
+	configured    []Sink
+	configuredFor []config.SinkConfig
+
+	// added holds the sinks registered by the application with AddSink.
+	added []Sink
+)
+
+// RegisterSinkType makes a sink type available to the "sinks" entries of the
+// configuration file.
+func RegisterSinkType(name string, factory SinkFactory) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	sinkFactories[name] = factory
+}
+
+// AddSink delivers every following record to sink, in addition to the sinks
+// in the configuration file.
+func AddSink(sink Sink) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	added = append(added, sink)
+}
+
+// RemoveSink stops delivering records to a sink added with AddSink. The sink
+// is not closed.
+func RemoveSink(sink Sink) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	for i, s := range added {
+		if s == sink {
+			added = append(added[:i:i], added[i+1:]...)
+			return
+		}
+	}
+}
+
+// Ring returns the memory sink of the configuration file with the given name,
+// "" for an entry without one, or nil if no such sink has been built. It keeps
+// its records while the configuration changes, unless its capacity does.
+func Ring(name string) *RingSink {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	return rings[name]
+}
+
+// namedRing returns the memory sink named name, replacing it if its capacity
+// differs. The caller must hold sinkMu.
+func namedRing(name string, capacity int) *RingSink {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	if ring, ok := rings[name]; ok && ring.Cap() == capacity {
+		return ring
+	}
+	ring := NewRingSink(capacity)
+	rings[name] = ring
+	return ring
+}
+
+// ActiveSinks returns the sinks records are currently delivered to.
+func ActiveSinks() []Sink {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	return append(append([]Sink{}, configured...), added...)
+}
+
+// FlushSinks flushes every active sink.
+func FlushSinks() {
+	for _, sink := range ActiveSinks() {
+		if err := sink.Flush(); err != nil {
+			sinkError(err)
+		}
+	}
+}
+
+// CloseSinks flushes and closes every active sink, waiting for queued records
+// to be delivered or spooled. Call it before the process exits; patch.py has
+// geth call it once its node has stopped. Sinks from the configuration file
+// are rebuilt if BitFlip is called again.
+func CloseSinks() {
+	sinkMu.Lock()
+	sinks := append(append([]Sink{}, configured...), added...)
+	configured, configuredFor, added = nil, nil, nil
+	sinkMu.Unlock()
+
+	for _, sink := range sinks {
+		if err := sink.Flush(); err != nil {
+			sinkError(err)
+		}
+		if err := sink.Close(); err != nil {
+			sinkError(err)
+		}
+	}
+}
+
+// deliver writes iter to every active sink, rebuilding the configured sinks
+// first if the configuration changed.
+func deliver(iter Iteration, cfg *config.Config) {
+	sinkMu.Lock()
+	entries := sinkEntries(cfg)
+	var stale []Sink
+	if !reflect.DeepEqual(entries, configuredFor) {
+		stale = rebuildSinks(entries)
+	}
+	sinks := append(append([]Sink{}, configured...), added...)
+	sinkMu.Unlock()
+
+	for _, sink := range stale {
+		if err := sink.Close(); err != nil {
+			sinkError(err)
+		}
+	}
+	for _, sink := range sinks {
+		if err := sink.Write(iter); err != nil {
+			sinkError(err)
+		}
+	}
+}
+
+// sinkEntries lists the sinks called for by cfg. Configurations written before
+// sinks existed print to stdout and post when the server is enabled; an empty
+// list of sinks delivers to none, leaving the records to the sinks added with
+// AddSink.
+func sinkEntries(cfg *config.Config) []config.SinkConfig {
+	entries := make([]config.SinkConfig, 0, len(cfg.Sinks)+1)
+	posting := false
+	for _, entry := range cfg.Sinks {
+		if entry.Type == "http" {
+			posting = true
+			if entry.Server == nil {
+				srv := cfg.Server
+				entry.Server = &srv
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if cfg.Sinks == nil {
+		entries = append(entries, config.SinkConfig{Type: "stdout"})
+	}
+	if cfg.Server.Post && !posting {
+		srv := cfg.Server
+		entries = append(entries, config.SinkConfig{Type: "http", Server: &srv})
+	}
+	return entries
+}
+
+// rebuildSinks replaces the configured sinks with the ones in entries and
+// returns the replaced sinks, which the caller closes once it has released
+// sinkMu: closing an http sink waits for its queue to be posted or spooled,
+// and BitFlip would wait with it. The caller must hold sinkMu.
+func rebuildSinks(entries []config.SinkConfig) []Sink {
+	stale := configured
+	configured = nil
+	for _, entry := range entries {
+		factory, ok := sinkFactories[entry.Type]
+		if !ok {
+			sinkError(fmt.Errorf("unknown sink type \"%s\"", entry.Type))
+			continue
+		}
+		sink, err := factory(entry)
+		if err != nil {
+			sinkError(err)
+			continue
+		}
+		configured = append(configured, sink)
+	}
+	configuredFor = append([]config.SinkConfig{}, entries...)
+	return stale
+}
+
+func sinkError(err error) {
+	sinkErrorCounter.Inc(1)
+	log.Printf("WARNING: result sink: %v", err)
+}
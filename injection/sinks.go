@@ -0,0 +1,359 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+const (
+	defaultMaxBytes = 64 * 1024 * 1024
+	defaultMaxFiles = 5
+	defaultCapacity = 1024
+)
+
+// StdoutSink prints every record as indented JSON.
+type StdoutSink struct {
+	mu sync.Mutex
+}
+
+// NewStdoutSink creates a sink printing to standard output.
+func NewStdoutSink() *StdoutSink {
+	return &StdoutSink{}
+}
+
+func (s *StdoutSink) Write(iter Iteration) error {
+	bytJSON, err := json.MarshalIndent(iter, "", "    ")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Println(string(bytJSON))
+	return nil
+}
+
+func (s *StdoutSink) Flush() error { return nil }
+func (s *StdoutSink) Close() error { return nil }
+
+// JSONLSink appends one JSON record per line to a file. Once the file grows
+// past maxBytes it is rotated to path.1, path.1 to path.2 and so on, keeping
+// at most maxFiles old files.
+type JSONLSink struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+}
+
+// NewJSONLSink opens path for appending, creating its directory if needed.
+// Zero limits select 64 MiB files and 5 rotations.
+func NewJSONLSink(path string, maxBytes int64, maxFiles int) (*JSONLSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("jsonl sink requires a path")
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+	s := &JSONLSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONLSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory \"%s\"", filepath.Dir(s.path))
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file \"%s\"", s.path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.writer, s.size = file, bufio.NewWriter(file), info.Size()
+	return nil
+}
+
+func (s *JSONLSink) Write(iter Iteration) error {
+	line, err := json.Marshal(iter)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("jsonl sink \"%s\" is closed", s.path)
+	}
+	if s.size > 0 && s.size+int64(len(line))+1 > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.writer.Write(append(line, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts the existing files up by one and starts a new file. The caller
+// must hold s.mu.
+func (s *JSONLSink) rotate() error {
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("error rotating file \"%s\"", s.path)
+	}
+	return s.open()
+}
+
+func (s *JSONLSink) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	if cErr := s.file.Close(); err == nil {
+		err = cErr
+	}
+	s.file, s.writer = nil, nil
+	return err
+}
+
+func (s *JSONLSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeFile()
+}
+
+// csvHeader names the columns written by CSVSink.
+var csvHeader = []string{"campaign", "test_type", "rate", "iteration", "when", "msg", "previous", "error", "bits", "delta", "context"}
+
+// CSVSink appends one row per record to a file, writing a header first if the
+// file is empty.
+type CSVSink struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	writer *csv.Writer
+}
+
+// NewCSVSink opens path for appending, creating its directory if needed.
+func NewCSVSink(path string) (*CSVSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("csv sink requires a path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating directory \"%s\"", filepath.Dir(path))
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file \"%s\"", path)
+	}
+	s := &CSVSink{path: path, file: file, writer: csv.NewWriter(file)}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		s.writer.Write(csvHeader)
+	}
+	return s, nil
+}
+
+func (s *CSVSink) Write(iter Iteration) error {
+	bits := make([]string, len(iter.ErrorData.IntBits))
+	for i, bit := range iter.ErrorData.IntBits {
+		bits[i] = strconv.Itoa(bit)
+	}
+	row := []string{
+		iter.Campaign,
+		iter.TestType,
+		strconv.FormatFloat(iter.Rate, 'g', -1, 64),
+		strconv.Itoa(iter.IterationNum),
+		iter.ErrorData.When,
+		iter.ErrorData.Msg,
+		iter.ErrorData.PreviousByte,
+		iter.ErrorData.ErrorByte,
+		strings.Join(bits, " "),
+		fmt.Sprint(iter.ErrorData.DeltaValue),
+		iter.ErrorData.Context.String(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("csv sink \"%s\" is closed", s.path)
+	}
+	return s.writer.Write(row)
+}
+
+func (s *CSVSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *CSVSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	s.writer.Flush()
+	err := s.writer.Error()
+	if cErr := s.file.Close(); err == nil {
+		err = cErr
+	}
+	s.file = nil
+	return err
+}
+
+// HTTPSink posts every record as a JSON body to a results server. With
+// asynchronous delivery enabled, records are instead posted in batches, as
+// JSON arrays, by a background worker.
+type HTTPSink struct {
+	poster  *poster
+	batcher *batcher
+}
+
+// NewHTTPSink creates a sink posting to the server described by srv.
+func NewHTTPSink(srv config.ServerConfig) (*HTTPSink, error) {
+	p, err := newPoster(srv)
+	if err != nil {
+		return nil, err
+	}
+	s := &HTTPSink{poster: p}
+	if srv.Async.Enabled {
+		s.batcher = newBatcher(s.poster, srv.Async)
+	}
+	return s, nil
+}
+
+func (s *HTTPSink) Write(iter Iteration) error {
+	if s.batcher != nil {
+		return s.batcher.add(iter.Copy())
+	}
+	body, err := json.Marshal(iter)
+	if err != nil {
+		return err
+	}
+	return s.poster.post(body, false)
+}
+
+func (s *HTTPSink) Flush() error {
+	if s.batcher != nil {
+		s.batcher.flush()
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	if s.batcher != nil {
+		s.batcher.close()
+	}
+	return nil
+}
+
+// RingSink keeps the most recent records in memory.
+type RingSink struct {
+	mu    sync.Mutex
+	items []Iteration
+	next  int
+	full  bool
+}
+
+// NewRingSink creates a ring holding up to capacity records, 1024 if zero.
+func NewRingSink(capacity int) *RingSink {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	return &RingSink{items: make([]Iteration, capacity)}
+}
+
+func (s *RingSink) Write(iter Iteration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[s.next] = iter.Copy()
+	s.next = (s.next + 1) % len(s.items)
+	s.full = s.full || s.next == 0
+	return nil
+}
+
+// Cap returns the number of records the ring holds at most.
+func (s *RingSink) Cap() int {
+	return len(s.items)
+}
+
+// Records returns the records held, oldest first.
+func (s *RingSink) Records() []Iteration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.full {
+		return append([]Iteration{}, s.items[:s.next]...)
+	}
+	return append(append([]Iteration{}, s.items[s.next:]...), s.items[:s.next]...)
+}
+
+func (s *RingSink) Flush() error { return nil }
+func (s *RingSink) Close() error { return nil }
@@ -0,0 +1,234 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// A named memory sink keeps its records when the sinks are rebuilt.
+func TestRing(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.Sinks = []config.SinkConfig{{Type: "memory", Name: "ring", Capacity: 4}}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// The ring outlives the test, so its records are told apart by site
+	site := fmt.Sprintf("ring.%d", time.Now().UnixNano())
+	BitFlip(uint64(1), site)
+
+	cfg.Sinks = append(cfg.Sinks, config.SinkConfig{Type: "stdout"})
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	BitFlip(uint64(1), site)
+
+	ring := Ring("ring")
+	if ring == nil {
+		t.Fatal("no memory sink named ring")
+	}
+	var n int
+	for _, iter := range ring.Records() {
+		if iter.ErrorData.Msg == site {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("%d records, want 2 across the rebuild", n)
+	}
+	if ring.Cap() != 4 {
+		t.Errorf("capacity %d, want 4", ring.Cap())
+	}
+	if Ring("other") != nil {
+		t.Error("memory sink returned for an unknown name")
+	}
+}
+
+// Configurations without sinks print to stdout and post if the server is
+// enabled; an empty list of sinks delivers to none.
+func TestSinkEntries(t *testing.T) {
+	cfg := config.DefaultConfig
+	cfg.Sinks = nil
+	cfg.Server.Post = true
+	entries := sinkEntries(&cfg)
+	if len(entries) != 2 || entries[0].Type != "stdout" || entries[1].Type != "http" {
+		t.Errorf("legacy configuration: sinks %v, want stdout and http", entries)
+	}
+
+	cfg.Sinks = []config.SinkConfig{}
+	cfg.Server.Post = false
+	if entries := sinkEntries(&cfg); len(entries) != 0 {
+		t.Errorf("empty sinks: sinks %v, want none", entries)
+	}
+}
+
+// closingSink is a sink whose Close waits until it is released, like an http
+// sink draining its queue.
+type closingSink struct {
+	closing chan struct{}
+	release chan struct{}
+}
+
+func (s *closingSink) Write(iter Iteration) error { return nil }
+func (s *closingSink) Flush() error               { return nil }
+func (s *closingSink) Close() error {
+	close(s.closing)
+	<-s.release
+	return nil
+}
+
+// Replaced sinks are closed without holding up the BitFlip calls of others.
+func TestRebuildSinksUnlocked(t *testing.T) {
+	sink := &closingSink{closing: make(chan struct{}), release: make(chan struct{})}
+	sinkMu.Lock()
+	sinkFactories["closing"] = func(config.SinkConfig) (Sink, error) { return sink, nil }
+	sinkMu.Unlock()
+	defer func() {
+		sinkMu.Lock()
+		delete(sinkFactories, "closing")
+		sinkMu.Unlock()
+	}()
+
+	cfg := configtest.New(t, 1)
+	cfg.Sinks = []config.SinkConfig{{Type: "closing"}}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	BitFlip(uint64(1), "rebuild")
+
+	cfg.Sinks = []config.SinkConfig{}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := make(chan struct{})
+	go func() {
+		BitFlip(uint64(1), "rebuild")
+		close(rebuilt)
+	}()
+	<-sink.closing
+
+	flipped := make(chan struct{})
+	go func() {
+		BitFlip(uint64(1), "rebuild")
+		close(flipped)
+	}()
+	select {
+	case <-flipped:
+	case <-time.After(5 * time.Second):
+		t.Error("BitFlip waited for a replaced sink to close")
+	}
+	close(sink.release)
+	<-rebuilt
+}
+
+// CloseSinks writes out what the file sinks buffered.
+func TestCloseSinks(t *testing.T) {
+	dir := t.TempDir()
+	jsonlPath := filepath.Join(dir, "flips.jsonl")
+	csvPath := filepath.Join(dir, "flips.csv")
+	cfg := configtest.New(t, 1)
+	cfg.Sinks = []config.SinkConfig{{Type: "jsonl", Path: jsonlPath}, {Type: "csv", Path: csvPath}}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseSinks()
+
+	for i := 0; i < 3; i++ {
+		BitFlip(uint64(i+1), "close")
+	}
+	if info, err := os.Stat(jsonlPath); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != 0 {
+		t.Fatalf("jsonl sink wrote %d bytes before closing, want them buffered", info.Size())
+	}
+	CloseSinks()
+
+	file, err := os.Open(jsonlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines int
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+		var iter Iteration
+		if err := json.Unmarshal(scanner.Bytes(), &iter); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if iter.ErrorData.Msg != "close" {
+			t.Errorf("line %d: site %s, want close", lines+1, iter.ErrorData.Msg)
+		}
+	}
+	if lines != 3 {
+		t.Errorf("%d jsonl records, want 3", lines)
+	}
+
+	file, err = os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[0][0] != csvHeader[0] || rows[1][5] != "close" {
+		t.Errorf("csv rows %v, want the header and 3 records", rows)
+	}
+}
+
+func TestJSONLSinkRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flips.jsonl")
+	sink, err := NewJSONLSink(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := sink.Write(Iteration{IterationNum: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Every record overflows the limit, so each file holds one
+	for i, name := range []string{path, path + ".1", path + ".2"} {
+		bytes, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var iter Iteration
+		if err := json.Unmarshal(bytes, &iter); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if iter.IterationNum != 3-i {
+			t.Errorf("%s holds iteration %d, want %d", name, iter.IterationNum, 3-i)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 rotated files kept")
+	}
+}
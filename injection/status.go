@@ -0,0 +1,204 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"sync"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// recentLimit is the number of flips kept in memory for status queries.
+const recentLimit = 128
+
+// Progress describes how far the configured campaign has advanced.
+type Progress struct {
+	Running   bool           `json:"running"`
+	TestType  string         `json:"testType"`
+	RateIndex int            `json:"rateIndex"`
+	Rate      float64        `json:"rate"`
+	Rates     []float64      `json:"rates"`
+	Counter   int            `json:"counter"`
+	Target    int            `json:"target"`
+	Sites     []string       `json:"sites"`
+	Flips     map[string]int `json:"flipsPerSite"`
+	Done      bool           `json:"done"`
+	ETA       time.Duration  `json:"eta"`
+}
+
+// tracker holds the in-process view of the flips this process has made. The
+// config file only carries counters, so per-site totals and the recent flips
+// live here.
+type tracker struct {
+	mu        sync.Mutex
+	recent    []Iteration
+	next      int
+	sites     map[string]int
+	rateIndex int
+	rateStart time.Time // first flip this process made at the rate
+	rateCount int       // counter units this process advanced since then
+	subs      map[chan Iteration]struct{}
+}
+
+var stats = &tracker{
+	sites: make(map[string]int),
+	subs:  make(map[chan Iteration]struct{}),
+}
+
+// track records a flip made by this process.
+func (t *tracker) track(iter Iteration, cfg *config.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	iter = iter.Copy()
+	if len(t.recent) < recentLimit {
+		t.recent = append(t.recent, iter)
+	} else {
+		t.recent[t.next] = iter
+	}
+	t.next = (t.next + 1) % recentLimit
+	t.sites[iter.ErrorData.Msg]++
+	for sub := range t.subs {
+		select {
+		case sub <- iter:
+		default: // slow subscribers miss flips rather than stall injection
+		}
+	}
+
+	if t.rateStart.IsZero() || t.rateIndex != cfg.State.RateIndex {
+		t.rateIndex = cfg.State.RateIndex
+		t.rateStart = time.Now()
+		t.rateCount = 0
+		return
+	}
+	// The counter of a bit campaign counts bits, that of a variable one
+	// values
+	if cfg.State.TestType == "bit" {
+		t.rateCount += len(iter.ErrorData.IntBits)
+	} else {
+		t.rateCount++
+	}
+}
+
+// subscribe returns a channel receiving every flip tracked from now on, and a
+// function to stop the subscription.
+func (t *tracker) subscribe() (<-chan Iteration, func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sub := make(chan Iteration, recentLimit)
+	t.subs[sub] = struct{}{}
+	return sub, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.subs, sub)
+	}
+}
+
+// reset forgets every flip recorded so far, used when a campaign restarts.
+func (t *tracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recent = nil
+	t.next = 0
+	t.sites = make(map[string]int)
+	t.rateStart = time.Time{}
+	t.rateCount = 0
+}
+
+// last returns up to n of the most recent flips, oldest first.
+func (t *tracker) last(n int) []Iteration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if n <= 0 || n > len(t.recent) {
+		n = len(t.recent)
+	}
+	out := make([]Iteration, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, t.recent[(t.next-n+i+len(t.recent))%len(t.recent)])
+	}
+	return out
+}
+
+// progress combines the campaign state in cfg with the flips tracked in this
+// process.
+func (t *tracker) progress(cfg *config.Config) Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := Progress{
+		Running:   cfg.Start,
+		TestType:  cfg.State.TestType,
+		RateIndex: cfg.State.RateIndex,
+		Rates:     cfg.State.ErrorRates,
+		Counter:   cfg.State.TestCounter,
+		Sites:     cfg.Sites,
+		Flips:     make(map[string]int, len(t.sites)),
+	}
+	for site, n := range t.sites {
+		p.Flips[site] = n
+	}
+	if p.RateIndex < len(p.Rates) {
+		p.Rate = p.Rates[p.RateIndex]
+	}
+	remaining := len(p.Rates) - p.RateIndex - 1
+
+	switch cfg.State.TestType {
+	case "bit", "variable":
+		p.Target = cfg.State.Bits
+		if cfg.State.TestType == "variable" {
+			p.Target = cfg.State.VariablesChanged
+		}
+		p.Done = remaining <= 0 && p.Counter >= p.Target
+		// The stored counter also counts the flips of other processes and
+		// earlier runs, so the pace is taken from this process alone
+		if t.rateCount > 0 && t.rateIndex == p.RateIndex {
+			perFlip := time.Since(t.rateStart) / time.Duration(t.rateCount)
+			left := p.Target - p.Counter + remaining*p.Target
+			if left > 0 {
+				p.ETA = perFlip * time.Duration(left)
+			}
+		}
+	default:
+		elapsed := time.Since(time.Unix(cfg.State.StartTime, 0))
+		p.Done = remaining <= 0 && elapsed >= cfg.State.Duration
+		if elapsed < cfg.State.Duration {
+			p.ETA = cfg.State.Duration - elapsed
+		}
+		if remaining > 0 {
+			p.ETA += time.Duration(remaining) * cfg.State.Duration
+		}
+	}
+	return p
+}
+
+// siteEnabled reports whether flips are allowed at the named site. An empty
+// site list in the configuration enables every site.
+func siteEnabled(cfg *config.Config, site string) bool {
+	if len(cfg.Sites) == 0 {
+		return true
+	}
+	for _, s := range cfg.Sites {
+		if s == site {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,263 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// writePEM writes blocks of type kind to a file in dir and returns its path.
+func writePEM(t *testing.T, dir, name, kind string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clientCertificate creates a self-signed client certificate, writing it and
+// its key to dir, and returns it with the paths of the files.
+func clientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "flip client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+}
+
+// serverCA writes the certificate of a TLS test server to dir as a CA file.
+func serverCA(t *testing.T, server *httptest.Server, dir string) string {
+	t.Helper()
+	return writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+func TestTLSCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	p, err := newPoster(config.ServerConfig{
+		Host: server.URL,
+		TLS:  config.TLSConfig{CAFile: serverCA(t, server, t.TempDir())},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.post([]byte("{}"), false); err != nil {
+		t.Fatalf("post to a server signed by the CA file: %v", err)
+	}
+}
+
+func TestTLSUntrustedServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached an untrusted server")
+	}))
+	defer server.Close()
+
+	// Trusting another authority must not make the server trusted
+	_, otherCA, _ := clientCertificate(t, t.TempDir())
+	p, err := newPoster(config.ServerConfig{
+		Host: server.URL,
+		TLS:  config.TLSConfig{CAFile: otherCA},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uErr x509.UnknownAuthorityError
+	if err := p.post([]byte("{}"), false); !errors.As(err, &uErr) {
+		t.Fatalf("error %v, want an unknown certificate authority", err)
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cert, certFile, keyFile := clientCertificate(t, dir)
+	clients := x509.NewCertPool()
+	clients.AddCert(cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "flip client" {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+		}
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	server.StartTLS()
+	defer server.Close()
+	caFile := serverCA(t, server, dir)
+
+	p, err := newPoster(config.ServerConfig{
+		Host: server.URL,
+		TLS:  config.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.post([]byte("{}"), false); err != nil {
+		t.Fatalf("post with a client certificate: %v", err)
+	}
+
+	p, err = newPoster(config.ServerConfig{Host: server.URL, TLS: config.TLSConfig{CAFile: caFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.post([]byte("{}"), false); err == nil {
+		t.Fatal("post without a client certificate accepted")
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	caFile := serverCA(t, server, t.TempDir())
+
+	p, err := newPoster(config.ServerConfig{Host: server.URL, TLS: config.TLSConfig{CAFile: caFile, MinVersion: "1.3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.post([]byte("{}"), false); err == nil {
+		t.Fatal("post negotiated a version below the minimum")
+	}
+	if _, err := newPoster(config.ServerConfig{Host: server.URL, TLS: config.TLSConfig{MinVersion: "2.0"}}); err == nil {
+		t.Error("unknown TLS version accepted")
+	}
+}
+
+// verifySignature checks a request the way a results server would, within a
+// minute of clock skew.
+func verifySignature(r *http.Request, secret []byte) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("no timestamp")
+	}
+	if skew := time.Since(time.Unix(sent, 0)); skew > time.Minute || skew < -time.Minute {
+		return errors.New("stale timestamp")
+	}
+	signature := strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256=")
+	if !hmac.Equal([]byte(signature), []byte(Signature(secret, timestamp, body))) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+func TestHMACSignature(t *testing.T) {
+	secret := "lab secret"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifySignature(r, []byte(secret)); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("FLIP_TEST_SECRET", secret)
+	p, err := newPoster(config.ServerConfig{
+		Host: server.URL,
+		Auth: config.AuthConfig{Type: "hmac", SecretEnv: "FLIP_TEST_SECRET"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.post([]byte(`{"IterationNum":1}`), false); err != nil {
+		t.Fatalf("signed post rejected: %v", err)
+	}
+
+	p, err = newPoster(config.ServerConfig{
+		Host: server.URL,
+		Auth: config.AuthConfig{Type: "hmac", Secret: "wrong secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sErr *statusError
+	if err := p.post([]byte(`{"IterationNum":1}`), false); !errors.As(err, &sErr) || sErr.code != http.StatusUnauthorized {
+		t.Fatalf("error %v, want a post signed with the wrong secret rejected", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(secretFile, []byte("lab token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer lab token" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	p, err := newPoster(config.ServerConfig{
+		Host: server.URL,
+		Auth: config.AuthConfig{Type: "bearer", SecretFile: secretFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.post([]byte("{}"), false); err != nil {
+		t.Fatalf("post with the token from the secret file rejected: %v", err)
+	}
+}
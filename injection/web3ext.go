@@ -0,0 +1,94 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+// ConsoleModule is the name the flip_ namespace is loaded under in the console.
+const ConsoleModule = "flip"
+
+// FlipJs is the web3 extension for the flip_ RPC namespace, in the same form as
+// the modules in go-ethereum's internal/web3ext package.
+//
+// web3 counts the parameters of a call after formatting them, so start, restart
+// and recent may be called without their argument: the null formatter fills it
+// in, as it does for the optional parameters of go-ethereum's own modules.
+const FlipJs = `
+web3._extend({
+	property: 'flip',
+	methods: [
+		new web3._extend.Method({
+			name: 'start',
+			call: 'flip_start',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'stop',
+			call: 'flip_stop',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'restart',
+			call: 'flip_restart',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'status',
+			call: 'flip_status',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'setRates',
+			call: 'flip_setRates',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'setSites',
+			call: 'flip_setSites',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'recent',
+			call: 'flip_recent',
+			params: 1,
+			inputFormatter: [null]
+		}),
+	],
+	properties: [
+		new web3._extend.Property({
+			name: 'progress',
+			getter: 'flip_status'
+		}),
+		new web3._extend.Property({
+			name: 'currentRate',
+			getter: 'flip_status',
+			outputFormatter: function(status) { return status.rate; }
+		}),
+		new web3._extend.Property({
+			name: 'recentFlips',
+			getter: 'flip_recent'
+		}),
+	]
+});
+`
+
+// RegisterConsoleModule adds the flip extension to a console module table,
+// normally go-ethereum's web3ext.Modules. The console loads it for any node
+// that reports the flip_ namespace in rpc_modules.
+func RegisterConsoleModule(modules map[string]string) {
+	modules[ConsoleModule] = FlipJs
+}
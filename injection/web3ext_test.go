@@ -0,0 +1,85 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package injection
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/console"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// TestConsoleModule loads the module into a console attached to the flip
+// namespace and calls its methods with and without their optional argument.
+func TestConsoleModule(t *testing.T) {
+	cfg := configtest.New(t, 1)
+	cfg.Start = false
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The console only loads the modules of go-ethereum's internal web3ext
+	// package, so the module is preloaded instead
+	dir := t.TempDir()
+	preload := filepath.Join(dir, "flip.js")
+	if err := os.WriteFile(preload, []byte(FlipJs+"\nvar flip = web3.flip;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c, err := console.New(console.Config{
+		DataDir: dir,
+		DocRoot: dir,
+		Client:  dialFlip(t),
+		Printer: &out,
+		Preload: []string{preload},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop(false)
+
+	for _, call := range []string{
+		"flip.start()",
+		"flip.start({rates: [1e-6]})",
+		"flip.restart()",
+		"flip.restart({sites: ['console']})",
+		"flip.recent()",
+		"flip.recent(1)",
+		"flip.status()",
+		"flip.currentRate",
+		"flip.stop()",
+	} {
+		out.Reset()
+		c.Evaluate(call)
+		if strings.HasPrefix(out.String(), "Error:") {
+			t.Errorf("%s: %s", call, strings.TrimSpace(out.String()))
+		}
+	}
+
+	cfg, err = config.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Start || len(cfg.Sites) != 1 || cfg.Sites[0] != "console" || cfg.State.ErrorRates[0] != 1e-6 {
+		t.Errorf("configuration after the calls: start %v, sites %v, rates %v", cfg.Start, cfg.Sites, cfg.State.ErrorRates)
+	}
+}
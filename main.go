@@ -1,9 +1,14 @@
 package main
 
 import (
+	"github.com/griffindavis02/eth-bit-flip/analysis"
+	"github.com/griffindavis02/eth-bit-flip/codec"
 	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"github.com/griffindavis02/eth-bit-flip/header"
+	"github.com/griffindavis02/eth-bit-flip/signature"
 )
 
 func main() {
-	config.RunConfig()
+	config.RunConfig(analysis.AnalyzeCommand, analysis.ReportCommand, analysis.CrashCommand, harness.CompareCommand, analysis.AVFCommand, analysis.PlanCommand, codec.RLPCommand, signature.SignatureCommand, header.HeaderCommand)
 }
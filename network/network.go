@@ -0,0 +1,139 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package network corrupts the payloads of devp2p messages as a peer reads
+// and writes them, modelling bit errors on the wire that no checksum caught.
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the wrapper, to be named in the configured sites.
+const (
+	ReadSite  = "p2p.read"  // payloads of messages read from the peer
+	WriteSite = "p2p.write" // payloads of messages written to the peer
+)
+
+// ReadWriter wraps the p2p.MsgReadWriter of a protocol running with a peer,
+// running the payloads of the selected messages through BitFlip as they are
+// read and written. The message code and size are left alone, as a frame
+// with a corrupted header would not make it past the RLPx layer. Every record
+// carries the peer ID, the protocol and the message code in its context.
+//
+// The filters are read from the configuration when the wrapper is made.
+type ReadWriter struct {
+	rw       p2p.MsgReadWriter
+	protocol string
+	peer     enode.ID
+	match    bool
+	codes    map[uint64]bool
+}
+
+// Wrap wraps rw, on which version of protocol runs with peer, with the
+// configured filters.
+func Wrap(rw p2p.MsgReadWriter, protocol string, version uint, peer enode.ID) *ReadWriter {
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		cfg = config.DefaultConfig
+	}
+	w := &ReadWriter{
+		rw:       rw,
+		protocol: fmt.Sprintf("%s/%d", protocol, version),
+		peer:     peer,
+		match:    len(cfg.P2P.Protocols) == 0,
+	}
+	for _, p := range cfg.P2P.Protocols {
+		if strings.EqualFold(p, protocol) || strings.EqualFold(p, w.protocol) {
+			w.match = true
+		}
+	}
+	if len(cfg.P2P.Codes) > 0 {
+		w.codes = make(map[uint64]bool)
+		for _, code := range cfg.P2P.Codes {
+			w.codes[code] = true
+		}
+	}
+	return w
+}
+
+// WrapProtocols returns copies of protocols that run on wrapped message
+// readers and writers, to be handed to a p2p.Server in place of the
+// originals.
+func WrapProtocols(protocols []p2p.Protocol) []p2p.Protocol {
+	wrapped := make([]p2p.Protocol, len(protocols))
+	for i, protocol := range protocols {
+		run := protocol.Run
+		name, version := protocol.Name, protocol.Version
+		wrapped[i] = protocol
+		wrapped[i].Run = func(peer *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return run(peer, Wrap(rw, name, version, peer.ID()))
+		}
+	}
+	return wrapped
+}
+
+// ReadMsg reads a message and corrupts its payload.
+func (w *ReadWriter) ReadMsg() (p2p.Msg, error) {
+	msg, err := w.rw.ReadMsg()
+	if err != nil || !w.matchCode(msg.Code) {
+		return msg, err
+	}
+	return w.flip(msg, ReadSite)
+}
+
+// WriteMsg corrupts the payload of msg and writes it.
+func (w *ReadWriter) WriteMsg(msg p2p.Msg) error {
+	if w.matchCode(msg.Code) {
+		var err error
+		if msg, err = w.flip(msg, WriteSite); err != nil {
+			return err
+		}
+	}
+	return w.rw.WriteMsg(msg)
+}
+
+// matchCode reports whether messages with code pass the configured filters.
+func (w *ReadWriter) matchCode(code uint64) bool {
+	return w.match && (w.codes == nil || w.codes[code])
+}
+
+// flip reads the payload of msg into a buffer of its own, corrupts it and
+// returns msg with the buffer as its payload.
+func (w *ReadWriter) flip(msg p2p.Msg, site string) (p2p.Msg, error) {
+	if msg.Size == 0 {
+		return msg, nil
+	}
+	payload, err := io.ReadAll(msg.Payload)
+	if err != nil {
+		return msg, err
+	}
+	injection.BitFlip(payload, site, injection.Context{
+		"peer":     w.peer.String(),
+		"protocol": w.protocol,
+		"code":     msg.Code,
+	})
+	msg.Payload = bytes.NewReader(payload)
+	return msg, nil
+}
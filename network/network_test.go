@@ -0,0 +1,201 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+var (
+	peer    = enode.ID{1}
+	payload = []byte{0x0f, 0xf0, 0xaa}
+)
+
+// inverse returns b with every bit flipped.
+func inverse(b []byte) []byte {
+	inv := make([]byte, len(b))
+	for i := range b {
+		inv[i] = ^b[i]
+	}
+	return inv
+}
+
+// inject writes a configuration inverting every bit at site with the p2p
+// filters of filter and returns a sink recording the flips.
+func inject(t *testing.T, site string, filter config.P2PConfig) *injection.RingSink {
+	t.Helper()
+	cfg := configtest.New(t, 1, site)
+	cfg.P2P = filter
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ring := injection.NewRingSink(16)
+	injection.AddSink(ring)
+	t.Cleanup(func() { injection.RemoveSink(ring) })
+	return ring
+}
+
+// send writes a message of code and data to w without waiting for it to be
+// read, as a message pipe would.
+func send(t *testing.T, w p2p.MsgWriter, code uint64, data []byte) {
+	go func() {
+		msg := p2p.Msg{Code: code, Size: uint32(len(data)), Payload: bytes.NewReader(data)}
+		if err := w.WriteMsg(msg); err != nil {
+			t.Errorf("writing message %d: %v", code, err)
+		}
+	}()
+}
+
+// receive reads a message from r and its payload.
+func receive(t *testing.T, r p2p.MsgReader) (p2p.Msg, []byte) {
+	t.Helper()
+	msg, err := r.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(msg.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg, data
+}
+
+// Payloads are corrupted as they are read or written, leaving the code and
+// size alone, and the flips name the peer, protocol and code.
+func TestReadWriter(t *testing.T) {
+	for _, site := range []string{ReadSite, WriteSite} {
+		t.Run(site, func(t *testing.T) {
+			ring := inject(t, site, config.P2PConfig{})
+			local, remote := p2p.MsgPipe()
+			defer local.Close()
+			w := Wrap(local, "eth", 66, peer)
+
+			var (
+				msg  p2p.Msg
+				data []byte
+			)
+			if site == ReadSite {
+				send(t, remote, 3, payload)
+				msg, data = receive(t, w)
+			} else {
+				send(t, w, 3, payload)
+				msg, data = receive(t, remote)
+			}
+			if msg.Code != 3 || msg.Size != uint32(len(payload)) {
+				t.Errorf("message %d of %d bytes, want 3 of %d", msg.Code, msg.Size, len(payload))
+			}
+			if !bytes.Equal(data, inverse(payload)) {
+				t.Errorf("payload %x, want %x", data, inverse(payload))
+			}
+
+			records := ring.Records()
+			if len(records) != 1 {
+				t.Fatalf("%d flips, want 1", len(records))
+			}
+			want := injection.Context{"peer": peer.String(), "protocol": "eth/66", "code": uint64(3)}
+			if data := records[0].ErrorData; data.Msg != site || data.Context.String() != want.String() {
+				t.Errorf("flip at %s with %v, want %s with %v", data.Msg, data.Context, site, want)
+			}
+		})
+	}
+}
+
+// Empty messages have no payload to corrupt.
+func TestReadWriterEmpty(t *testing.T) {
+	ring := inject(t, ReadSite, config.P2PConfig{})
+	local, remote := p2p.MsgPipe()
+	defer local.Close()
+
+	send(t, remote, 2, nil)
+	if msg, data := receive(t, Wrap(local, "eth", 66, peer)); msg.Code != 2 || msg.Size != 0 || len(data) != 0 {
+		t.Errorf("message %d of %d bytes with payload %x, want 2 empty", msg.Code, msg.Size, data)
+	}
+	if records := ring.Records(); len(records) != 0 {
+		t.Errorf("%d flips of an empty message", len(records))
+	}
+}
+
+func TestReadWriterFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter config.P2PConfig
+		code   uint64
+		flip   bool
+	}{
+		{"protocol", config.P2PConfig{Protocols: []string{"ETH"}}, 3, true},
+		{"protocol version", config.P2PConfig{Protocols: []string{"snap", "eth/66"}}, 3, true},
+		{"other version", config.P2PConfig{Protocols: []string{"eth/65"}}, 3, false},
+		{"other protocol", config.P2PConfig{Protocols: []string{"snap"}}, 3, false},
+		{"code", config.P2PConfig{Codes: []uint64{2, 3}}, 3, true},
+		{"other code", config.P2PConfig{Codes: []uint64{2}}, 3, false},
+		{"protocol and code", config.P2PConfig{Protocols: []string{"eth"}, Codes: []uint64{3}}, 3, true},
+		{"protocol and other code", config.P2PConfig{Protocols: []string{"eth"}, Codes: []uint64{4}}, 3, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring := inject(t, ReadSite, test.filter)
+			local, remote := p2p.MsgPipe()
+			defer local.Close()
+
+			send(t, remote, test.code, payload)
+			_, data := receive(t, Wrap(local, "eth", 66, peer))
+			flips := 0
+			if test.flip {
+				flips = 1
+			}
+			if flipped := !bytes.Equal(data, payload); flipped != test.flip || len(ring.Records()) != flips {
+				t.Errorf("payload %x with %d flips, flip %v", data, len(ring.Records()), test.flip)
+			}
+		})
+	}
+}
+
+// Wrapped protocols run on wrapped readers and writers for their peer.
+func TestWrapProtocols(t *testing.T) {
+	ring := inject(t, ReadSite, config.P2PConfig{})
+	local, remote := p2p.MsgPipe()
+	defer local.Close()
+
+	received := make(chan []byte, 1)
+	protocols := WrapProtocols([]p2p.Protocol{{
+		Name:    "eth",
+		Version: 66,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			_, data := receive(t, rw)
+			received <- data
+			return nil
+		},
+	}})
+	send(t, remote, 3, payload)
+	if err := protocols[0].Run(p2p.NewPeer(peer, "peer", nil), local); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-received; !bytes.Equal(data, inverse(payload)) {
+		t.Errorf("protocol read %x, want %x", data, inverse(payload))
+	}
+	if records := ring.Records(); len(records) != 1 || records[0].ErrorData.Context["peer"] != peer.String() {
+		t.Errorf("flips %v, want one of peer %s", records, peer)
+	}
+}
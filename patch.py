@@ -5,7 +5,14 @@ import re
 dirGeth = re.sub(r'\\', '/', sys.argv[1])
 fileTriggers = {
     'cmd/utils/flags.go': ['pcsclite "github.com/gballet/go-libpcsclite"', 'Usage: "Catalyst mode (eth2 integration testing)",'],
-    'cmd/geth/main.go': ['utils.MetricsInfluxDBOrganizationFlag,', 'app.Flags = append(app.Flags, metricsFlags...)']
+    'cmd/geth/main.go': ['utils.MetricsInfluxDBOrganizationFlag,', 'app.Flags = append(app.Flags, metricsFlags...)',
+                         'import (', 'func geth(ctx *cli.Context) error {', 'stack, backend := makeFullNode(ctx)'],
+    'internal/web3ext/web3ext.go': ['package web3ext'],
+    'crypto/crypto.go': ['// Keccak256 calculates and returns the Keccak256 hash of the input data.',
+                         'kh.Read(h[:])', 'd.Read(b)', 'd.Read(h[:])'],
+    'trie/hasher.go': ['h.sha.Read(n)'],
+    'core/types/hashing.go': ['sha.Read(h[:])', 'rlp.Encode(sha, x)',
+                              '// rlpHash encodes x and hashes the encoded bytes.']
 }
 
 patches = {
@@ -61,7 +68,50 @@ patches = {
 		utils.FlipHost,
 	}
     """,
-    '\tapp.Flags = append(app.Flags, flipFlags...)']
+    '\tapp.Flags = append(app.Flags, flipFlags...)',
+    '\t"github.com/griffindavis02/eth-bit-flip/injection"\n',
+    '\tdefer injection.CapturePanic()\n',
+    '\t"github.com/ethereum/go-ethereum/crypto"\n\t"github.com/griffindavis02/eth-bit-flip/hashing"\n',
+    '\tif hasher := hashing.New(); hasher != nil {\n\t\tcrypto.DigestHook = hasher.Corrupt\n\t}\n',
+    '\tdefer injection.CloseSinks()\n\tstack.RegisterAPIs(injection.APIs())\n'],
+
+    'internal/web3ext/web3ext.go': ['''
+import "github.com/griffindavis02/eth-bit-flip/injection"
+
+func init() {
+	injection.RegisterConsoleModule(Modules)
+}
+'''],
+
+    'crypto/crypto.go': ['''// DigestHook, if set, is called with every digest computed by the Keccak256
+// helpers and the length of the hashed input. It may modify the digest.
+var DigestHook func(digest []byte, length int)
+
+''',
+    '\tif DigestHook != nil {\n\t\tDigestHook(h[:], len(data))\n\t}\n',
+    '\tif DigestHook != nil {\n\t\tlength := 0\n\t\tfor _, in := range data {\n\t\t\tlength += len(in)\n\t\t}\n\t\tDigestHook(b, length)\n\t}\n',
+    '\tif DigestHook != nil {\n\t\tlength := 0\n\t\tfor _, in := range data {\n\t\t\tlength += len(in)\n\t\t}\n\t\tDigestHook(h[:], length)\n\t}\n'],
+
+    'trie/hasher.go': ['\tif crypto.DigestHook != nil {\n\t\tcrypto.DigestHook(n, len(data))\n\t}\n'],
+
+    'core/types/hashing.go': [
+    '\tif counter != nil {\n\t\tcrypto.DigestHook(h[:], counter.n)\n\t}\n',
+    '\tif counter != nil {\n\t\tcrypto.DigestHook(h[:], 1+counter.n)\n\t}\n',
+    """// countingState counts the bytes rlp encodes into a hasher, the input length
+// passed to crypto.DigestHook.
+type countingState struct {
+\tcrypto.KeccakState
+\tn int
+}
+
+func (s *countingState) Write(b []byte) (int, error) {
+\tn, err := s.KeccakState.Write(b)
+\ts.n += n
+\treturn n, err
+}
+
+""",
+    '\tvar counter *countingState\n\tif crypto.DigestHook != nil {\n\t\tcounter = &countingState{KeccakState: sha}\n\t\tsha = counter\n\t}\n']
 }
 
 def main():
@@ -75,6 +125,42 @@ def main():
     patch(fileNames[0], triggerContent[1], patches[fileNames[0]][0], triggerContent[0], 1)
     triggerContent = findTrigger(fileNames[0], fileTriggers[fileNames[0]][1], 0)
     patch(fileNames[0], triggerContent[1], patches[fileNames[0]][1], triggerContent[0], 0)
+    # Register the console module; the first match is the package comment
+    triggerContent = findTrigger(fileNames[2], fileTriggers[fileNames[2]][0], 1)
+    patch(fileNames[2], triggerContent[1], patches[fileNames[2]][0], triggerContent[0], 0)
+
+    # Capture panics on geth's main goroutine in the crash file
+    triggerContent = findTrigger(fileNames[1], fileTriggers[fileNames[1]][2], 0)
+    patch(fileNames[1], triggerContent[1], patches[fileNames[1]][2], triggerContent[0], 0)
+    triggerContent = findTrigger(fileNames[1], fileTriggers[fileNames[1]][3], 0)
+    patch(fileNames[1], triggerContent[1], patches[fileNames[1]][3], triggerContent[0], 0)
+
+    # Serve the flip_ RPC namespace the console module calls, and write out the
+    # sinks once the node, deferred to close after this, has stopped
+    triggerContent = findTrigger(fileNames[1], fileTriggers[fileNames[1]][4], 0)
+    patch(fileNames[1], triggerContent[1], patches[fileNames[1]][6], triggerContent[0], 0)
+
+    # Let the hashing package corrupt digests, if enabled when geth starts
+    triggerContent = findTrigger(fileNames[1], fileTriggers[fileNames[1]][2], 0)
+    patch(fileNames[1], triggerContent[1], patches[fileNames[1]][4], triggerContent[0], 0)
+    triggerContent = findTrigger(fileNames[1], fileTriggers[fileNames[1]][3], 0)
+    patch(fileNames[1], triggerContent[1], patches[fileNames[1]][5], triggerContent[0], 0)
+    triggerContent = findTrigger(fileNames[3], fileTriggers[fileNames[3]][0], 0)
+    patch(fileNames[3], triggerContent[1], patches[fileNames[3]][0], triggerContent[0], -1)
+    for i in range(1, 4):
+        triggerContent = findTrigger(fileNames[3], fileTriggers[fileNames[3]][i], 0)
+        patch(fileNames[3], triggerContent[1], patches[fileNames[3]][i], triggerContent[0], 0)
+    triggerContent = findTrigger(fileNames[4], fileTriggers[fileNames[4]][0], 0)
+    patch(fileNames[4], triggerContent[1], patches[fileNames[4]][0], triggerContent[0], 0)
+    # Count the bytes rlp encodes into the pooled hasher, which is put back
+    # unwrapped by the deferred call
+    for i in range(2):
+        triggerContent = findTrigger(fileNames[5], fileTriggers[fileNames[5]][1], i)
+        patch(fileNames[5], triggerContent[1], patches[fileNames[5]][3], triggerContent[0], -1)
+        triggerContent = findTrigger(fileNames[5], fileTriggers[fileNames[5]][0], i)
+        patch(fileNames[5], triggerContent[1], patches[fileNames[5]][i], triggerContent[0], 0)
+    triggerContent = findTrigger(fileNames[5], fileTriggers[fileNames[5]][2], 0)
+    patch(fileNames[5], triggerContent[1], patches[fileNames[5]][2], triggerContent[0], -1)
 
 def findTrigger(fileName: str, trigger: str, overrides: int) -> (int, list[str]):
     """
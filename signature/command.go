@@ -0,0 +1,221 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package signature
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/griffindavis02/eth-bit-flip/harness"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// allSites is the key of the summary over every corrupted transaction.
+const allSites = "all"
+
+// Summary counts the outcomes of the transactions a site's value was changed
+// in. A transaction corrupted at several sites counts for each of them.
+// OtherSender is the fraction of those that recovered a different sender.
+type Summary struct {
+	Site        string          `json:"site"`
+	Corrupted   int             `json:"corrupted"`
+	Outcomes    map[Outcome]int `json:"outcomes"`
+	OtherSender float64         `json:"otherSender"`
+}
+
+// Summarize summarizes the corrupted transactions of records by site, in the
+// order the sites first appear, followed by all of them.
+func Summarize(records []*Record) []*Summary {
+	var (
+		summaries []*Summary
+		bySite    = make(map[string]*Summary)
+		total     = &Summary{Site: allSites, Outcomes: make(map[Outcome]int)}
+	)
+	count := func(s *Summary, r *Record) {
+		s.Corrupted++
+		s.Outcomes[r.Outcome]++
+	}
+	for _, r := range records {
+		if !r.Corrupted() {
+			continue
+		}
+		for _, site := range r.Sites {
+			s := bySite[site]
+			if s == nil {
+				s = &Summary{Site: site, Outcomes: make(map[Outcome]int)}
+				bySite[site] = s
+				summaries = append(summaries, s)
+			}
+			count(s, r)
+		}
+		count(total, r)
+	}
+	summaries = append(summaries, total)
+	for _, s := range summaries {
+		if s.Corrupted > 0 {
+			s.OtherSender = float64(s.Outcomes[OtherSender]) / float64(s.Corrupted)
+		}
+	}
+	return summaries
+}
+
+// WriteTable writes summaries as a table.
+func WriteTable(w io.Writer, summaries []*Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Site\tCorrupted\tsame\tother\trejected\tOther sender\t")
+	for _, s := range summaries {
+		other := "-"
+		if s.Corrupted > 0 {
+			other = fmt.Sprintf("%.3f", s.OtherSender)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t\n", s.Site, s.Corrupted,
+			s.Outcomes[SameSender], s.Outcomes[OtherSender], s.Outcomes[Rejected], other)
+	}
+	return tw.Flush()
+}
+
+// Key returns the i-th key of the experiment, derived from i so that runs are
+// reproducible without any key material on disk.
+func Key(i int) *ecdsa.PrivateKey {
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint64(seed, uint64(i))
+	key, err := crypto.ToECDSA(crypto.Keccak256([]byte("eth-bit-flip signature"), seed))
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Transactions signs a legacy, an access list and a dynamic fee transaction
+// with key for the chain of signer.
+func Transactions(key *ecdsa.PrivateKey, signer types.Signer, nonce uint64) ([]*types.Transaction, error) {
+	var (
+		to       = common.BigToAddress(big.NewInt(int64(0x30000 + nonce)))
+		value    = big.NewInt(int64(nonce+1) * params.GWei)
+		gasPrice = big.NewInt(2 * params.InitialBaseFee)
+		data     = crypto.Keccak256(to[:])
+		chainID  = signer.ChainID()
+		txs      []*types.Transaction
+	)
+	for _, inner := range []types.TxData{
+		&types.LegacyTx{Nonce: nonce, GasPrice: gasPrice, Gas: 50000, To: &to, Value: value, Data: data},
+		&types.AccessListTx{ChainID: chainID, Nonce: nonce + 1, GasPrice: gasPrice, Gas: 50000, To: &to, Value: value, Data: data,
+			AccessList: types.AccessList{{Address: to, StorageKeys: []common.Hash{{}}}}},
+		&types.DynamicFeeTx{ChainID: chainID, Nonce: nonce + 2, GasTipCap: big.NewInt(params.GWei), GasFeeCap: gasPrice,
+			Gas: 50000, To: &to, Value: value, Data: data},
+	} {
+		tx, err := types.SignNewTx(key, signer, inner)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+var (
+	keysFlag = cli.IntFlag{
+		Name:  "keys",
+		Usage: "Number of locally generated keys to sign with",
+		Value: 16,
+	}
+	runsFlag = cli.IntFlag{
+		Name:  "runs",
+		Usage: "Number of times to corrupt every transaction",
+		Value: 10,
+	}
+	configFlag = cli.StringFlag{
+		Name:  "config",
+		Usage: "Configuration to inject with (default: the current configuration)",
+	}
+	recordsFlag = cli.StringFlag{
+		Name:  "records",
+		Usage: "File to write every corrupted transaction to as JSON lines",
+	}
+	formatFlag = cli.StringFlag{
+		Name:  "format",
+		Usage: "Output format: table or json",
+		Value: "table",
+	}
+
+	// SignatureCommand is the 'flipcfg signature' subcommand.
+	SignatureCommand = cli.Command{
+		Action: experiment,
+		Name:   "signature",
+		Usage:  "Corrupt signed transactions and recover their senders",
+		Flags:  []cli.Flag{keysFlag, runsFlag, configFlag, recordsFlag, formatFlag},
+		Description: `
+Signs a legacy, an access list and a dynamic fee transaction with each of
+--keys keys derived locally, corrupts their fields and V, R and S values with
+the configuration --runs times, and recovers the sender of every corrupted
+transaction with the London signer. No network or key store is needed.
+
+A corrupted transaction recovers the same sender, another valid sender, or is
+rejected. The outcomes are summarized per site, with the fraction of
+transactions that recovered another sender.`,
+	}
+)
+
+func experiment(ctx *cli.Context) error {
+	exp, err := harness.NewExperiment(ctx, configFlag.Name, recordsFlag.Name, formatFlag.Name)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	var (
+		signer  = types.NewLondonSigner(params.TestChainConfig.ChainID)
+		txs     []*types.Transaction
+		records []*Record
+	)
+	build := func() error {
+		for i := 0; i < ctx.Int(keysFlag.Name); i++ {
+			signed, err := Transactions(Key(i), signer, uint64(3*i))
+			if err != nil {
+				return err
+			}
+			txs = append(txs, signed...)
+		}
+		return nil
+	}
+	inject := func(emit func(interface{}) error) error {
+		for run := 0; run < ctx.Int(runsFlag.Name); run++ {
+			for _, tx := range txs {
+				_, r, err := Corrupt(tx, signer)
+				if err != nil {
+					return err
+				}
+				records = append(records, r)
+				if err := emit(r); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := exp.Run(build, inject); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	summaries := Summarize(records)
+	return exp.Write(summaries, func(w io.Writer) error { return WriteTable(w, summaries) })
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package signature corrupts the fields and ECDSA signatures of transactions
+// before their sender is recovered, to see whether a fault is rejected or
+// turns the transaction into one from somebody else.
+package signature
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the transaction fields and signature values, to be named in the
+// configured sites.
+const (
+	NonceSite     = "tx.nonce"
+	GasPriceSite  = "tx.gas_price"   // legacy and access list transactions
+	GasTipCapSite = "tx.gas_tip_cap" // dynamic fee transactions
+	GasFeeCapSite = "tx.gas_fee_cap" // dynamic fee transactions
+	GasSite       = "tx.gas"
+	ToSite        = "tx.to"
+	ValueSite     = "tx.value"
+	DataSite      = "tx.data"
+	ChainIDSite   = "tx.chain_id" // typed transactions; legacy ones keep it in V
+	VSite         = "tx.v"
+	RSite         = "tx.r"
+	SSite         = "tx.s"
+)
+
+// Outcome classifies the sender recovered from a corrupted transaction.
+type Outcome string
+
+const (
+	// SameSender transactions recover the original sender.
+	SameSender Outcome = "same"
+	// OtherSender transactions recover a valid sender other than the
+	// original one.
+	OtherSender Outcome = "other"
+	// Rejected transactions fail sender recovery.
+	Rejected Outcome = "rejected"
+)
+
+// Record is the outcome of corrupting a transaction. Sites lists the sites
+// whose value the fault changed.
+type Record struct {
+	Hash      common.Hash     `json:"hash"`
+	Type      uint8           `json:"type"`
+	Sites     []string        `json:"sites,omitempty"`
+	Sender    common.Address  `json:"sender"`
+	Recovered *common.Address `json:"recovered,omitempty"`
+	Outcome   Outcome         `json:"outcome"`
+	Err       string          `json:"error,omitempty"`
+}
+
+// Corrupted reports whether the fault changed the transaction.
+func (r *Record) Corrupted() bool {
+	return len(r.Sites) > 0
+}
+
+// Corrupt runs every field and signature value of tx through BitFlip at its
+// site and recovers the sender of the result with signer. Numbers are
+// corrupted as they are encoded, without leading zeros, except for R and S,
+// which are corrupted as the 256 bit words of the signature. Every flip
+// carries the hash and original sender of tx in its context.
+//
+// The corrupted transaction is returned with the record. An error is only
+// returned if the sender of tx itself cannot be recovered.
+func Corrupt(tx *types.Transaction, signer types.Signer) (*types.Transaction, *Record, error) {
+	sender, err := types.Sender(signer, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &Record{Hash: tx.Hash(), Type: tx.Type(), Sender: sender}
+	c := &corrupter{record: r, ctx: injection.Context{"hash": tx.Hash().Hex(), "sender": sender.Hex()}}
+
+	v, rr, s := tx.RawSignatureValues()
+	var (
+		nonce = c.uint64(NonceSite, tx.Nonce())
+		gas   = c.uint64(GasSite, tx.Gas())
+		to    = c.address(ToSite, tx.To())
+		value = c.big(ValueSite, tx.Value())
+		data  = c.bytes(DataSite, tx.Data())
+	)
+	var inner types.TxData
+	switch tx.Type() {
+	case types.LegacyTxType:
+		inner = &types.LegacyTx{
+			Nonce: nonce, GasPrice: c.big(GasPriceSite, tx.GasPrice()), Gas: gas, To: to, Value: value, Data: data,
+			V: c.big(VSite, v), R: c.word(RSite, rr), S: c.word(SSite, s),
+		}
+	case types.AccessListTxType:
+		inner = &types.AccessListTx{
+			ChainID: c.big(ChainIDSite, tx.ChainId()), Nonce: nonce, GasPrice: c.big(GasPriceSite, tx.GasPrice()),
+			Gas: gas, To: to, Value: value, Data: data, AccessList: tx.AccessList(),
+			V: c.big(VSite, v), R: c.word(RSite, rr), S: c.word(SSite, s),
+		}
+	case types.DynamicFeeTxType:
+		inner = &types.DynamicFeeTx{
+			ChainID: c.big(ChainIDSite, tx.ChainId()), Nonce: nonce, GasTipCap: c.big(GasTipCapSite, tx.GasTipCap()),
+			GasFeeCap: c.big(GasFeeCapSite, tx.GasFeeCap()), Gas: gas, To: to, Value: value, Data: data,
+			AccessList: tx.AccessList(), V: c.big(VSite, v), R: c.word(RSite, rr), S: c.word(SSite, s),
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported transaction type %d", tx.Type())
+	}
+	corrupted := types.NewTx(inner)
+
+	recovered, err := types.Sender(signer, corrupted)
+	switch {
+	case err != nil:
+		r.Outcome, r.Err = Rejected, err.Error()
+	case recovered == sender:
+		r.Outcome, r.Recovered = SameSender, &recovered
+	default:
+		r.Outcome, r.Recovered = OtherSender, &recovered
+	}
+	return corrupted, r, nil
+}
+
+// corrupter runs the values of a transaction through BitFlip, noting the
+// sites whose value changed in the record.
+type corrupter struct {
+	record *Record
+	ctx    injection.Context
+}
+
+func (c *corrupter) changed(site string, changed bool) {
+	if changed {
+		c.record.Sites = append(c.record.Sites, site)
+	}
+}
+
+func (c *corrupter) uint64(site string, value uint64) uint64 {
+	flipped := injection.BitFlip(value, site, c.ctx).(uint64)
+	c.changed(site, flipped != value)
+	return flipped
+}
+
+func (c *corrupter) big(site string, value *big.Int) *big.Int {
+	if value == nil {
+		return nil
+	}
+	flipped := injection.BitFlip(new(big.Int).Set(value), site, c.ctx).(*big.Int)
+	c.changed(site, flipped.Cmp(value) != 0)
+	return flipped
+}
+
+func (c *corrupter) word(site string, value *big.Int) *big.Int {
+	word := common.BigToHash(value)
+	injection.BitFlip(word[:], site, c.ctx)
+	flipped := word.Big()
+	c.changed(site, flipped.Cmp(value) != 0)
+	return flipped
+}
+
+func (c *corrupter) bytes(site string, value []byte) []byte {
+	if len(value) == 0 {
+		return value
+	}
+	flipped := injection.BitFlip(common.CopyBytes(value), site, c.ctx).([]byte)
+	c.changed(site, string(flipped) != string(value))
+	return flipped
+}
+
+func (c *corrupter) address(site string, addr *common.Address) *common.Address {
+	if addr == nil {
+		return nil
+	}
+	flipped := common.BytesToAddress(c.bytes(site, addr[:]))
+	return &flipped
+}
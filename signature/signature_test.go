@@ -0,0 +1,93 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package signature
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+)
+
+// corruptAll corrupts the transactions of the first n local keys.
+func corruptAll(t *testing.T, n int) []*Record {
+	t.Helper()
+	signer := types.NewLondonSigner(params.TestChainConfig.ChainID)
+	var records []*Record
+	for i := 0; i < n; i++ {
+		txs, err := Transactions(Key(i), signer, uint64(3*i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range txs {
+			_, r, err := Corrupt(tx, signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// Flipping about one bit of R either leaves it alone, moves it to the x
+// coordinate of another point, recovering somebody else, or off the curve.
+func TestCorruptR(t *testing.T) {
+	configtest.New(t, 1.0/256, RSite)
+
+	outcomes := make(map[Outcome]int)
+	for _, r := range corruptAll(t, 32) {
+		outcomes[r.Outcome]++
+		switch r.Outcome {
+		case SameSender:
+			if r.Corrupted() {
+				t.Errorf("transaction %s recovered its sender with %v corrupted", r.Hash.Hex(), r.Sites)
+			}
+		case OtherSender:
+			if len(r.Sites) != 1 || r.Sites[0] != RSite {
+				t.Errorf("transaction %s corrupted at %v, want %s", r.Hash.Hex(), r.Sites, RSite)
+			}
+			if r.Recovered == nil || *r.Recovered == r.Sender {
+				t.Errorf("transaction %s recovered %v, want another sender", r.Hash.Hex(), r.Recovered)
+			}
+		case Rejected:
+			if r.Err == "" || r.Recovered != nil {
+				t.Errorf("transaction %s rejected without an error", r.Hash.Hex())
+			}
+		}
+	}
+	for _, outcome := range []Outcome{SameSender, OtherSender, Rejected} {
+		if outcomes[outcome] == 0 {
+			t.Errorf("no %s outcome in %v", outcome, outcomes)
+		}
+	}
+}
+
+// Inverting every bit of a low S gives a high S, which the signer refuses.
+func TestCorruptS(t *testing.T) {
+	configtest.New(t, 1, SSite)
+
+	for _, r := range corruptAll(t, 4) {
+		if r.Outcome != Rejected {
+			t.Errorf("transaction %s: outcome %s, want %s", r.Hash.Hex(), r.Outcome, Rejected)
+		}
+		if len(r.Sites) != 1 || r.Sites[0] != SSite {
+			t.Errorf("transaction %s corrupted at %v, want %s", r.Hash.Hex(), r.Sites, SSite)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package statedb corrupts account balances, nonces, code and storage as they
+// pass through a state.StateDB.
+package statedb
+
+import (
+	"log"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the hooks, to be named in the configured sites.
+const (
+	GetBalanceSite = "state.balance.get" // balances returned by GetBalance
+	SetBalanceSite = "state.balance.set" // amounts passed to AddBalance and SubBalance
+	GetNonceSite   = "state.nonce.get"   // nonces returned by GetNonce
+	SetNonceSite   = "state.nonce.set"   // nonces passed to SetNonce
+	GetCodeSite    = "state.code.get"    // code returned by GetCode
+	SetCodeSite    = "state.code.set"    // code passed to SetCode
+	GetStorageSite = "state.storage.get" // values returned by GetState and GetCommittedState
+	SetStorageSite = "state.storage.set" // values passed to SetState
+)
+
+// StateDB wraps a state.StateDB, running the values of the selected accounts
+// and slots through BitFlip as they are read and written. It implements
+// vm.StateDB, so it can be given to vm.NewEVM in place of the state it wraps.
+// Every record carries the account address and, for storage, the slot in its
+// context.
+//
+// Values returned are corrupted copies, so the state itself only changes
+// through a corrupted write. The filters are read from the configuration when
+// the state is wrapped.
+type StateDB struct {
+	*state.StateDB
+	addresses map[common.Address]bool
+	slots     map[common.Hash]bool
+}
+
+// New wraps db with the configured filters.
+func New(db *state.StateDB) *StateDB {
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		cfg = config.DefaultConfig
+	}
+	s := &StateDB{StateDB: db}
+	if len(cfg.StateDB.Addresses) > 0 {
+		s.addresses = make(map[common.Address]bool)
+		for _, addr := range cfg.StateDB.Addresses {
+			if common.IsHexAddress(addr) {
+				s.addresses[common.HexToAddress(addr)] = true
+			} else {
+				log.Printf("WARNING: invalid address \"%s\" in StateDB filter", addr)
+			}
+		}
+	}
+	if len(cfg.StateDB.Slots) > 0 {
+		s.slots = make(map[common.Hash]bool)
+		for _, slot := range cfg.StateDB.Slots {
+			s.slots[common.HexToHash(slot)] = true
+		}
+	}
+	return s
+}
+
+// GetBalance returns the balance of addr, corrupted as a 256 bit word.
+func (s *StateDB) GetBalance(addr common.Address) *big.Int {
+	balance := s.StateDB.GetBalance(addr)
+	if !s.match(addr) {
+		return balance
+	}
+	return s.flipWord(balance, GetBalanceSite, addr)
+}
+
+// AddBalance adds a corrupted amount to the balance of addr.
+func (s *StateDB) AddBalance(addr common.Address, amount *big.Int) {
+	if s.match(addr) {
+		amount = s.flipWord(amount, SetBalanceSite, addr)
+	}
+	s.StateDB.AddBalance(addr, amount)
+}
+
+// SubBalance subtracts a corrupted amount from the balance of addr.
+func (s *StateDB) SubBalance(addr common.Address, amount *big.Int) {
+	if s.match(addr) {
+		amount = s.flipWord(amount, SetBalanceSite, addr)
+	}
+	s.StateDB.SubBalance(addr, amount)
+}
+
+// GetNonce returns the nonce of addr, corrupted.
+func (s *StateDB) GetNonce(addr common.Address) uint64 {
+	nonce := s.StateDB.GetNonce(addr)
+	if !s.match(addr) {
+		return nonce
+	}
+	return injection.BitFlip(nonce, GetNonceSite, context(addr, nil)).(uint64)
+}
+
+// SetNonce sets a corrupted nonce for addr.
+func (s *StateDB) SetNonce(addr common.Address, nonce uint64) {
+	if s.match(addr) {
+		nonce = injection.BitFlip(nonce, SetNonceSite, context(addr, nil)).(uint64)
+	}
+	s.StateDB.SetNonce(addr, nonce)
+}
+
+// GetCode returns a corrupted copy of the code of addr.
+func (s *StateDB) GetCode(addr common.Address) []byte {
+	code := s.StateDB.GetCode(addr)
+	if !s.match(addr) || len(code) == 0 {
+		return code
+	}
+	return injection.BitFlip(common.CopyBytes(code), GetCodeSite, context(addr, nil)).([]byte)
+}
+
+// SetCode sets corrupted code for addr. The code hash is that of the
+// corrupted code, as the state computes it.
+func (s *StateDB) SetCode(addr common.Address, code []byte) {
+	if s.match(addr) && len(code) > 0 {
+		code = injection.BitFlip(common.CopyBytes(code), SetCodeSite, context(addr, nil)).([]byte)
+	}
+	s.StateDB.SetCode(addr, code)
+}
+
+// GetState returns the value of slot in the storage of addr, corrupted.
+func (s *StateDB) GetState(addr common.Address, slot common.Hash) common.Hash {
+	return s.flipSlot(s.StateDB.GetState(addr, slot), GetStorageSite, addr, slot)
+}
+
+// GetCommittedState returns the committed value of slot in the storage of
+// addr, corrupted.
+func (s *StateDB) GetCommittedState(addr common.Address, slot common.Hash) common.Hash {
+	return s.flipSlot(s.StateDB.GetCommittedState(addr, slot), GetStorageSite, addr, slot)
+}
+
+// SetState sets a corrupted value for slot in the storage of addr.
+func (s *StateDB) SetState(addr common.Address, slot, value common.Hash) {
+	s.StateDB.SetState(addr, slot, s.flipSlot(value, SetStorageSite, addr, slot))
+}
+
+// match reports whether addr passes the address filter.
+func (s *StateDB) match(addr common.Address) bool {
+	return s.addresses == nil || s.addresses[addr]
+}
+
+// flipWord corrupts a copy of value as a 256 bit word, so that bits above its
+// highest set bit can flip too.
+func (s *StateDB) flipWord(value *big.Int, site string, addr common.Address) *big.Int {
+	word := common.BigToHash(value)
+	injection.BitFlip(word[:], site, context(addr, nil))
+	return word.Big()
+}
+
+func (s *StateDB) flipSlot(value common.Hash, site string, addr common.Address, slot common.Hash) common.Hash {
+	if !s.match(addr) || (s.slots != nil && !s.slots[slot]) {
+		return value
+	}
+	injection.BitFlip(value[:], site, context(addr, &slot))
+	return value
+}
+
+// context describes a flip in the account at addr and, for storage, slot.
+func context(addr common.Address, slot *common.Hash) injection.Context {
+	ctx := injection.Context{"address": addr.Hex()}
+	if slot != nil {
+		ctx["slot"] = slot.Hex()
+	}
+	return ctx
+}
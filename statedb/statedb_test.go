@@ -0,0 +1,241 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package statedb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+var (
+	addr  = common.HexToAddress("0x01")
+	other = common.HexToAddress("0x02")
+	slot  = common.HexToHash("0x03")
+	word  = common.HexToHash("0x0ff0")
+	code  = []byte{0x60, 0x00}
+)
+
+// inverse returns b with every bit flipped.
+func inverse(b []byte) []byte {
+	inv := make([]byte, len(b))
+	for i := range b {
+		inv[i] = ^b[i]
+	}
+	return inv
+}
+
+// inject writes a configuration inverting every bit at site with the filters
+// of filter and returns a sink recording the flips.
+func inject(t *testing.T, site string, filter config.StateDBConfig) *injection.RingSink {
+	t.Helper()
+	cfg := configtest.New(t, 1, site)
+	cfg.StateDB = filter
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ring := injection.NewRingSink(16)
+	injection.AddSink(ring)
+	t.Cleanup(func() { injection.RemoveSink(ring) })
+	return ring
+}
+
+// newState returns an empty in-memory state.
+func newState(t *testing.T) *state.StateDB {
+	t.Helper()
+	db, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// populate gives the accounts at addr and other a balance, nonce, code and
+// storage slot.
+func populate(db *state.StateDB) {
+	for _, a := range []common.Address{addr, other} {
+		db.SetBalance(a, word.Big())
+		db.SetNonce(a, 7)
+		db.SetCode(a, code)
+		db.SetState(a, slot, word)
+	}
+}
+
+// checkFlip checks that ring recorded a single flip at site in the account at
+// addr and, if storage is set, its slot.
+func checkFlip(t *testing.T, ring *injection.RingSink, site string, storage bool) {
+	t.Helper()
+	records := ring.Records()
+	if len(records) != 1 {
+		t.Fatalf("%d flips, want 1", len(records))
+	}
+	want := injection.Context{"address": addr.Hex()}
+	if storage {
+		want["slot"] = slot.Hex()
+	}
+	if data := records[0].ErrorData; data.Msg != site || data.Context.String() != want.String() {
+		t.Errorf("flip at %s with %v, want %s with %v", data.Msg, data.Context, site, want)
+	}
+}
+
+// Reads return a corrupted copy, leaving the state as it was.
+func TestStateDBGet(t *testing.T) {
+	flipped := common.BytesToHash(inverse(word[:]))
+	tests := []struct {
+		site    string
+		storage bool
+		check   func(t *testing.T, s *StateDB)
+	}{
+		{GetBalanceSite, false, func(t *testing.T, s *StateDB) {
+			if got := s.GetBalance(addr); got.Cmp(flipped.Big()) != 0 {
+				t.Errorf("balance %x, want %x", got, flipped.Big())
+			}
+		}},
+		{GetNonceSite, false, func(t *testing.T, s *StateDB) {
+			if got := s.GetNonce(addr); got != ^uint64(7) {
+				t.Errorf("nonce %d, want %d", got, ^uint64(7))
+			}
+		}},
+		{GetCodeSite, false, func(t *testing.T, s *StateDB) {
+			if got := s.GetCode(addr); !bytes.Equal(got, inverse(code)) {
+				t.Errorf("code %x, want %x", got, inverse(code))
+			}
+		}},
+		{GetStorageSite, true, func(t *testing.T, s *StateDB) {
+			if got := s.GetState(addr, slot); got != flipped {
+				t.Errorf("storage %x, want %x", got, flipped)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.site, func(t *testing.T) {
+			ring := inject(t, test.site, config.StateDBConfig{})
+			db := newState(t)
+			populate(db)
+			test.check(t, New(db))
+
+			if got := db.GetBalance(addr); got.Cmp(word.Big()) != 0 {
+				t.Errorf("state balance changed to %x", got)
+			}
+			if got := db.GetNonce(addr); got != 7 {
+				t.Errorf("state nonce changed to %d", got)
+			}
+			if got := db.GetCode(addr); !bytes.Equal(got, code) {
+				t.Errorf("state code changed to %x", got)
+			}
+			if got := db.GetState(addr, slot); got != word {
+				t.Errorf("state storage changed to %x", got)
+			}
+			checkFlip(t, ring, test.site, test.storage)
+		})
+	}
+}
+
+// Writes store the corrupted value.
+func TestStateDBSet(t *testing.T) {
+	flipped := common.BytesToHash(inverse(word[:]))
+	tests := []struct {
+		site    string
+		storage bool
+		write   func(s *StateDB)
+		check   func(t *testing.T, db *state.StateDB)
+	}{
+		{SetBalanceSite, false, func(s *StateDB) { s.AddBalance(addr, word.Big()) }, func(t *testing.T, db *state.StateDB) {
+			if got := db.GetBalance(addr); got.Cmp(flipped.Big()) != 0 {
+				t.Errorf("balance %x, want %x", got, flipped.Big())
+			}
+		}},
+		{SetNonceSite, false, func(s *StateDB) { s.SetNonce(addr, 7) }, func(t *testing.T, db *state.StateDB) {
+			if got := db.GetNonce(addr); got != ^uint64(7) {
+				t.Errorf("nonce %d, want %d", got, ^uint64(7))
+			}
+		}},
+		{SetCodeSite, false, func(s *StateDB) { s.SetCode(addr, code) }, func(t *testing.T, db *state.StateDB) {
+			if got := db.GetCode(addr); !bytes.Equal(got, inverse(code)) {
+				t.Errorf("code %x, want %x", got, inverse(code))
+			}
+		}},
+		{SetStorageSite, true, func(s *StateDB) { s.SetState(addr, slot, word) }, func(t *testing.T, db *state.StateDB) {
+			if got := db.GetState(addr, slot); got != flipped {
+				t.Errorf("storage %x, want %x", got, flipped)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.site, func(t *testing.T) {
+			ring := inject(t, test.site, config.StateDBConfig{})
+			db := newState(t)
+			original := common.CopyBytes(code)
+			test.write(New(db))
+			test.check(t, db)
+			if !bytes.Equal(code, original) {
+				t.Errorf("caller's code changed to %x", code)
+			}
+			checkFlip(t, ring, test.site, test.storage)
+		})
+	}
+}
+
+func TestStateDBFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter config.StateDBConfig
+		addr   common.Address
+		slot   common.Hash
+		flip   bool
+	}{
+		{"none", config.StateDBConfig{}, other, word, true},
+		{"address", config.StateDBConfig{Addresses: []string{addr.Hex()}}, addr, slot, true},
+		{"other address", config.StateDBConfig{Addresses: []string{addr.Hex()}}, other, slot, false},
+		{"slot", config.StateDBConfig{Slots: []string{slot.Hex()}}, other, slot, true},
+		{"other slot", config.StateDBConfig{Slots: []string{slot.Hex()}}, addr, word, false},
+		{"address and slot", config.StateDBConfig{Addresses: []string{addr.Hex()}, Slots: []string{slot.Hex()}}, addr, slot, true},
+		{"slot of other address", config.StateDBConfig{Addresses: []string{addr.Hex()}, Slots: []string{slot.Hex()}}, other, slot, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ring := inject(t, GetStorageSite, test.filter)
+			db := newState(t)
+			db.SetState(test.addr, test.slot, word)
+			got := New(db).GetState(test.addr, test.slot)
+			if flipped := got != word; flipped != test.flip || (len(ring.Records()) == 1) != test.flip {
+				t.Errorf("read %x with %d flips, flip %v", got, len(ring.Records()), test.flip)
+			}
+		})
+	}
+}
+
+// The address filter applies to accounts as well as storage.
+func TestStateDBAccountFilter(t *testing.T) {
+	ring := inject(t, GetNonceSite, config.StateDBConfig{Addresses: []string{addr.Hex()}})
+	db := newState(t)
+	populate(db)
+	s := New(db)
+	if got := s.GetNonce(other); got != 7 {
+		t.Errorf("nonce of other account %d, want 7", got)
+	}
+	if got := s.GetNonce(addr); got != ^uint64(7) {
+		t.Errorf("nonce %d, want %d", got, ^uint64(7))
+	}
+	checkFlip(t, ring, GetNonceSite, false)
+}
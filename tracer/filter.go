@@ -0,0 +1,98 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package tracer
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/griffindavis02/eth-bit-flip/config"
+)
+
+// filter is the parsed form of config.EVMConfig. A nil map or slice matches
+// everything, while a filter whose entries are all invalid matches nothing.
+type filter struct {
+	opcodes   map[vm.OpCode]bool
+	addresses map[common.Address]bool
+	minDepth  int
+	maxDepth  int
+	pcRanges  []config.PCRange
+}
+
+// warned holds the filter entries already warned about, as the filters are
+// parsed for every transaction.
+var warned sync.Map
+
+func warnOnce(format, entry string) {
+	if _, loaded := warned.LoadOrStore(entry, true); !loaded {
+		log.Printf(format, entry)
+	}
+}
+
+// newFilter parses cfg. Opcodes are named as geth prints them, such as SLOAD
+// or SHA3; unknown names and malformed addresses are logged and left out.
+func newFilter(cfg *config.EVMConfig) filter {
+	f := filter{minDepth: cfg.MinDepth, maxDepth: cfg.MaxDepth, pcRanges: cfg.PCRanges}
+	if len(cfg.Opcodes) > 0 {
+		f.opcodes = make(map[vm.OpCode]bool)
+		for _, name := range cfg.Opcodes {
+			name = strings.ToUpper(strings.TrimSpace(name))
+			if op := vm.StringToOp(name); op.String() == name {
+				f.opcodes[op] = true
+			} else {
+				warnOnce("WARNING: unknown opcode \"%s\" in EVM filter", name)
+			}
+		}
+	}
+	if len(cfg.Addresses) > 0 {
+		f.addresses = make(map[common.Address]bool)
+		for _, addr := range cfg.Addresses {
+			if common.IsHexAddress(addr) {
+				f.addresses[common.HexToAddress(addr)] = true
+			} else {
+				warnOnce("WARNING: invalid address \"%s\" in EVM filter", addr)
+			}
+		}
+	}
+	return f
+}
+
+// match reports whether a flip at s, in the contract at address and at depth,
+// passes every filter.
+func (f filter) match(s step, address common.Address, depth int) bool {
+	if f.opcodes != nil && !f.opcodes[s.op] {
+		return false
+	}
+	if f.addresses != nil && !f.addresses[address] {
+		return false
+	}
+	if depth < f.minDepth || (f.maxDepth > 0 && depth > f.maxDepth) {
+		return false
+	}
+	if len(f.pcRanges) == 0 {
+		return true
+	}
+	for _, r := range f.pcRanges {
+		if s.pc >= r.From && s.pc <= r.To {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracer injects faults into the EVM through a vm.EVMLogger, so EVM
+// campaigns need no BitFlip calls compiled into go-ethereum. It lives apart
+// from the injection package because go-ethereum's patched source imports
+// that package, and importing core/vm from it would be a cycle.
+package tracer
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+// Sites of the injector, to be named in the configured sites.
+const (
+	StackSite      = "evm.stack"      // the result each opcode left on the stack
+	MemorySite     = "evm.memory"     // the memory each opcode is about to read
+	ReturnDataSite = "evm.returndata" // the output of each call
+)
+
+// Injector is a vm.EVMLogger that runs every value it sees through BitFlip,
+// following the configured rates and sites. It corrupts the result each
+// opcode leaves on the stack and, for opcodes that read memory, the memory
+// read. The output of each call frame is corrupted as it returns to its caller.
+//
+// The configured EVM filters narrow this down to opcodes, contracts, call
+// depths and program counter ranges. They are read at the start of every
+// transaction. Each record carries the opcode, pc, depth and contract address
+// of its flip in its context; a return data flip is attributed to the opcode
+// that ended the call.
+//
+// The EVM has already charged gas and expanded memory for an opcode when the
+// injector sees it, so a corrupted offset or size may make the opcode read
+// outside memory and panic, as it would on faulty hardware.
+//
+// An Injector follows a single EVM and must not be shared between EVMs
+// running at the same time.
+type Injector struct {
+	frames []frame
+	filter filter
+}
+
+// frame is an open call frame.
+type frame struct {
+	address common.Address
+	create  bool
+	last    *step // opcode run before the current one
+}
+
+// step is an opcode run at a program counter.
+type step struct {
+	op vm.OpCode
+	pc uint64
+}
+
+// NewInjector returns an injector to set as the tracer of a vm.Config, with
+// Debug enabled.
+func NewInjector() *Injector {
+	return &Injector{}
+}
+
+// CaptureStart reads the filters and opens the outermost call frame.
+func (in *Injector) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		cfg = config.DefaultConfig
+	}
+	in.filter = newFilter(&cfg.EVM)
+	in.frames = append(in.frames[:0], frame{address: to, create: create})
+}
+
+// CaptureState corrupts the result of the previous opcode, now on top of the
+// stack, and the memory op reads.
+func (in *Injector) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil || len(in.frames) == 0 {
+		return
+	}
+	f := &in.frames[len(in.frames)-1]
+	if prev := f.last; prev != nil && hasResult(prev.op) && in.filter.match(*prev, f.address, depth) {
+		if data := scope.Stack.Data(); len(data) > 0 {
+			top := &data[len(data)-1]
+			word := top.Bytes32()
+			top.SetBytes(injection.BitFlip(word[:], StackSite, context(*prev, f.address, depth)).([]byte))
+		}
+	}
+	cur := step{op: op, pc: pc}
+	if in.filter.match(cur, f.address, depth) {
+		if offset, size, ok := memoryRead(op, scope.Stack); ok {
+			mem := scope.Memory.Data()
+			if offset < uint64(len(mem)) {
+				if end := offset + size; end > offset && end <= uint64(len(mem)) {
+					injection.BitFlip(mem[offset:end], MemorySite, context(cur, f.address, depth))
+				}
+			}
+		}
+	}
+	f.last = &cur
+}
+
+// CaptureEnter opens a nested call frame.
+func (in *Injector) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	in.frames = append(in.frames, frame{address: to, create: typ == vm.CREATE || typ == vm.CREATE2})
+}
+
+// CaptureExit corrupts the output of a nested call frame.
+func (in *Injector) CaptureExit(output []byte, gasUsed uint64, err error) {
+	in.exit(output)
+}
+
+// CaptureFault does nothing; the opcode has already failed.
+func (in *Injector) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnd corrupts the output of the outermost call frame.
+func (in *Injector) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) {
+	in.exit(output)
+}
+
+// exit closes the innermost call frame, corrupting its output in place so the
+// caller sees the corrupted bytes. The output of a contract creation is the
+// deployed code, which the state has already taken and hashed, so it is left
+// alone, as is the output of a precompile, which runs no opcodes.
+func (in *Injector) exit(output []byte) {
+	if len(in.frames) == 0 {
+		return
+	}
+	depth := len(in.frames)
+	f := in.frames[depth-1]
+	in.frames = in.frames[:depth-1]
+	if f.create || f.last == nil || len(output) == 0 {
+		return
+	}
+	if in.filter.match(*f.last, f.address, depth) {
+		injection.BitFlip(output, ReturnDataSite, context(*f.last, f.address, depth))
+	}
+}
+
+// context describes a flip made at s.
+func context(s step, address common.Address, depth int) injection.Context {
+	return injection.Context{
+		"opcode":  s.op.String(),
+		"pc":      s.pc,
+		"depth":   depth,
+		"address": address.Hex(),
+	}
+}
+
+// hasResult reports whether op pushes a result onto the stack. SWAP only
+// reorders it.
+func hasResult(op vm.OpCode) bool {
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT:
+		return false
+	}
+	return op < vm.SWAP1 || op > vm.SWAP16
+}
+
+// memoryRead returns the region of memory op is about to read, taken from its
+// operands on stack.
+func memoryRead(op vm.OpCode, stack *vm.Stack) (uint64, uint64, bool) {
+	var offsetArg, sizeArg int
+	switch op {
+	case vm.MLOAD:
+		if len(stack.Data()) < 1 || !stack.Back(0).IsUint64() {
+			return 0, 0, false
+		}
+		return stack.Back(0).Uint64(), 32, true
+	case vm.SHA3, vm.RETURN, vm.REVERT, vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4:
+		offsetArg, sizeArg = 0, 1
+	case vm.CREATE, vm.CREATE2:
+		offsetArg, sizeArg = 1, 2
+	case vm.CALL, vm.CALLCODE:
+		offsetArg, sizeArg = 3, 4
+	case vm.DELEGATECALL, vm.STATICCALL:
+		offsetArg, sizeArg = 2, 3
+	default:
+		return 0, 0, false
+	}
+	if len(stack.Data()) <= sizeArg {
+		return 0, 0, false
+	}
+	offset, size := stack.Back(offsetArg), stack.Back(sizeArg)
+	if !offset.IsUint64() || !size.IsUint64() || size.IsZero() {
+		return 0, 0, false
+	}
+	return offset.Uint64(), size.Uint64(), true
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 The eth-bit-flip Authors
+// This file is part of the eth-bit-flip library.
+//
+// The eth-bit-flip libary is free software: you can redistribute it and/or
+// modify it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or (at your
+// option) any later version.
+//
+// The eth-bit-flip libary is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along with
+// the eth-bit-flip library. If not, see <https://www.gnu.org/licenses/>.
+
+package tracer
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/griffindavis02/eth-bit-flip/config"
+	"github.com/griffindavis02/eth-bit-flip/config/configtest"
+	"github.com/griffindavis02/eth-bit-flip/injection"
+)
+
+var (
+	caller   = common.HexToAddress("0x1000")
+	contract = common.HexToAddress("0x2000")
+
+	// program returns 1 + 2 as a word of memory:
+	//
+	//	0  PUSH1 1      5  PUSH1 0      8  PUSH1 32
+	//	2  PUSH1 2      7  MSTORE      10  PUSH1 0
+	//	4  ADD                         12  RETURN
+	program = common.FromHex("600160020160005260206000f3")
+)
+
+// inverted is the word program returns with every bit flipped.
+func inverted() []byte {
+	word := common.LeftPadBytes([]byte{3}, 32)
+	for i := range word {
+		word[i] = ^word[i]
+	}
+	return word
+}
+
+// execute runs program through an injector inverting every bit of the values
+// at site that pass filter, and returns its output and the flips made.
+func execute(t *testing.T, site string, filter config.EVMConfig) ([]byte, []injection.Iteration) {
+	t.Helper()
+	cfg := configtest.New(t, 1, site)
+	cfg.EVM = filter
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	ring := injection.NewRingSink(16)
+	injection.AddSink(ring)
+	defer injection.RemoveSink(ring)
+
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetCode(contract, program)
+	blockCtx := vm.BlockContext{
+		CanTransfer: func(vm.StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(vm.StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(1),
+		Difficulty:  big.NewInt(1),
+		BaseFee:     big.NewInt(1),
+		GasLimit:    1e7,
+	}
+	evm := vm.NewEVM(blockCtx, vm.TxContext{Origin: caller}, statedb, params.TestChainConfig,
+		vm.Config{Debug: true, Tracer: NewInjector()})
+	ret, _, err := evm.Call(vm.AccountRef(caller), contract, nil, 1e6, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret, ring.Records()
+}
+
+// Each site corrupts its own value, the result of an opcode, the memory an
+// opcode reads or the output of a call, and records where it did so.
+func TestInjectorSites(t *testing.T) {
+	tests := []struct {
+		site   string
+		filter config.EVMConfig
+		op     vm.OpCode
+		pc     uint64
+	}{
+		{StackSite, config.EVMConfig{Opcodes: []string{"ADD"}}, vm.ADD, 4},
+		{MemorySite, config.EVMConfig{Opcodes: []string{"RETURN"}}, vm.RETURN, 12},
+		{ReturnDataSite, config.EVMConfig{}, vm.RETURN, 12},
+	}
+	for _, test := range tests {
+		t.Run(test.site, func(t *testing.T) {
+			ret, flips := execute(t, test.site, test.filter)
+			if !bytes.Equal(ret, inverted()) {
+				t.Errorf("returned %x, want %x", ret, inverted())
+			}
+			if len(flips) != 1 {
+				t.Fatalf("%d flips, want 1", len(flips))
+			}
+			data := flips[0].ErrorData
+			want := context(step{op: test.op, pc: test.pc}, contract, 1)
+			if data.Msg != test.site || data.Context.String() != want.String() {
+				t.Errorf("flip at %s with %v, want %s with %v", data.Msg, data.Context, test.site, want)
+			}
+		})
+	}
+}
+
+// A filter rejecting every step of program leaves it alone.
+func TestInjectorFiltered(t *testing.T) {
+	filters := map[string]config.EVMConfig{
+		"opcode":  {Opcodes: []string{"MUL"}},
+		"address": {Addresses: []string{caller.Hex()}},
+		"depth":   {MinDepth: 2},
+		"pc":      {PCRanges: []config.PCRange{{From: 13, To: 100}}},
+	}
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			ret, flips := execute(t, StackSite, filter)
+			if want := common.LeftPadBytes([]byte{3}, 32); !bytes.Equal(ret, want) || len(flips) != 0 {
+				t.Errorf("returned %x with %d flips, want %x with none", ret, len(flips), want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	add := step{op: vm.ADD, pc: 4}
+	tests := []struct {
+		name    string
+		cfg     config.EVMConfig
+		s       step
+		address common.Address
+		depth   int
+		want    bool
+	}{
+		{"empty", config.EVMConfig{}, add, contract, 1, true},
+		{"opcode", config.EVMConfig{Opcodes: []string{"add", " MUL"}}, add, contract, 1, true},
+		{"other opcode", config.EVMConfig{Opcodes: []string{"MUL"}}, add, contract, 1, false},
+		{"unknown opcode", config.EVMConfig{Opcodes: []string{"NOPE"}}, add, contract, 1, false},
+		{"address", config.EVMConfig{Addresses: []string{contract.Hex()}}, add, contract, 1, true},
+		{"other address", config.EVMConfig{Addresses: []string{caller.Hex()}}, add, contract, 1, false},
+		{"invalid address", config.EVMConfig{Addresses: []string{"0x12"}}, add, contract, 1, false},
+		{"min depth", config.EVMConfig{MinDepth: 2}, add, contract, 2, true},
+		{"below min depth", config.EVMConfig{MinDepth: 2}, add, contract, 1, false},
+		{"max depth", config.EVMConfig{MaxDepth: 2}, add, contract, 2, true},
+		{"above max depth", config.EVMConfig{MaxDepth: 2}, add, contract, 3, false},
+		{"pc range", config.EVMConfig{PCRanges: []config.PCRange{{From: 0, To: 2}, {From: 4, To: 4}}}, add, contract, 1, true},
+		{"outside pc ranges", config.EVMConfig{PCRanges: []config.PCRange{{From: 0, To: 3}, {From: 5, To: 9}}}, add, contract, 1, false},
+		{"all", config.EVMConfig{Opcodes: []string{"ADD"}, Addresses: []string{contract.Hex()}, MinDepth: 1, MaxDepth: 1,
+			PCRanges: []config.PCRange{{From: 4, To: 4}}}, add, contract, 1, true},
+	}
+	for _, test := range tests {
+		cfg := test.cfg
+		if got := newFilter(&cfg).match(test.s, test.address, test.depth); got != test.want {
+			t.Errorf("%s: match %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// The context of the injector reaches the records the same when passed as a
+// plain map, as code patched into go-ethereum without the Context type does.
+func TestInjectorContextMap(t *testing.T) {
+	configtest.New(t, 1, StackSite)
+	ring := injection.NewRingSink(2)
+	injection.AddSink(ring)
+	defer injection.RemoveSink(ring)
+
+	ctx := context(step{op: vm.ADD, pc: 4}, contract, 1)
+	injection.BitFlip(uint64(3), StackSite, ctx)
+	injection.BitFlip(uint64(3), StackSite, map[string]interface{}(ctx))
+
+	records := ring.Records()
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	if got, want := records[1].ErrorData.Context.String(), records[0].ErrorData.Context.String(); got != want {
+		t.Errorf("context from a map %s, want %s", got, want)
+	}
+}